@@ -0,0 +1,80 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/config"
+	"github.com/mdhender/wraithi/internal/wraith"
+	"io"
+	"log"
+	"os"
+)
+
+// auditExport writes the audit log as JSON Lines, oldest event first.
+//
+//	wraith [flags] audit-export [-actor a] [-action a] [-target t] [-since t] [-until t] [-output file]
+func auditExport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("audit-export", flag.ContinueOnError)
+	var f audit.Filter
+	var since, until, output string
+	fs.StringVar(&f.Actor, "actor", "", "only events by this user id or handle")
+	fs.StringVar(&f.Action, "action", "", "only this action, or every action with a prefix ending in '.'")
+	fs.StringVar(&f.Target, "target", "", "only events for this target id")
+	fs.StringVar(&since, "since", "", "only events at or after this date or RFC 3339 time")
+	fs.StringVar(&until, "until", "", "only events before this date or RFC 3339 time")
+	fs.StringVar(&output, "output", "", "file to write to (default is standard output)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if f.Since, err = audit.ParseTime(since); err != nil {
+		return fmt.Errorf("since: %w", err)
+	} else if f.Until, err = audit.ParseTime(until); err != nil {
+		return fmt.Errorf("until: %w", err)
+	}
+
+	db, err := sql.Open("mysql", cfg.DB.DSN())
+	if err != nil {
+		return err
+	}
+	defer func(db *sql.DB) {
+		if err := db.Close(); err != nil {
+			log.Printf("[audit] db.close: %v\n", err)
+		}
+	}(db)
+
+	events, _, err := wraith.NewDB(context.Background(), db).AuditEvents(f)
+	if err != nil {
+		return err
+	}
+	// events are read newest first, but an export reads better in order
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		fp, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = fp.Close()
+		}()
+		w = fp
+	}
+	bw := bufio.NewWriter(w)
+	if err := audit.WriteJSONL(bw, events); err != nil {
+		return err
+	} else if err := bw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("[audit] exported %d events\n", len(events))
+	return nil
+}
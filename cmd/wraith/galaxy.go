@@ -0,0 +1,116 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/config"
+	"github.com/mdhender/wraithi/internal/engine"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// newGalaxy generates a galaxy and writes it to the data path so that a game master can look it over.
+// The seed is logged so that the galaxy can be generated again.
+//
+//	wraith [flags] new-galaxy [-seed n] [-players n] [-systems-per-player n] [-radius n] [-kinds k=w,...] [-richness n] [-neighborhood n] [-output file]
+func newGalaxy(cfg *config.Config, args []string) error {
+	opts := engine.NewGalaxyOptions()
+	fs := flag.NewFlagSet("new-galaxy", flag.ContinueOnError)
+	var seed, kinds, output string
+	fs.StringVar(&seed, "seed", "", "64-bit seed (default is random)")
+	fs.IntVar(&opts.Players, "players", opts.Players, "number of players")
+	fs.IntVar(&opts.SystemsPerPlayer, "systems-per-player", opts.SystemsPerPlayer, "number of star systems for each player")
+	fs.IntVar(&opts.Radius, "radius", opts.Radius, "radius of the galaxy (default suits the number of systems)")
+	fs.StringVar(&kinds, "kinds", formatKinds(opts.PlanetKinds), "relative weight of each kind of planet")
+	fs.IntVar(&opts.Richness, "richness", opts.Richness, "percent to scale planet resources by")
+	fs.IntVar(&opts.Neighborhood, "neighborhood", opts.Neighborhood, "number of systems around each home to balance")
+	fs.StringVar(&output, "output", "", "file to write to (default is galaxies/galaxy-SEED.json under the data path)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var n uint64
+	if seed == "" {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	} else if v, err := strconv.ParseUint(seed, 0, 64); err != nil {
+		return fmt.Errorf("seed: %w", err)
+	} else {
+		n = v
+	}
+	var err error
+	if opts.PlanetKinds, err = parseKinds(kinds); err != nil {
+		return fmt.Errorf("kinds: %w", err)
+	}
+
+	g, err := engine.Generate(n, opts)
+	if err != nil {
+		return err
+	}
+	g.Name = fmt.Sprintf("galaxy-%d", n)
+
+	if output == "" {
+		output = filepath.Join(cfg.App.Data, "galaxies", g.Name+".json")
+		if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+			return err
+		}
+	}
+	fp, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := g.Save(fp); err != nil {
+		_ = fp.Close()
+		return err
+	} else if err := fp.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("[galaxy] seed %d: %d systems, radius %d\n", n, len(g.Galaxy.Systems), g.Galaxy.Radius)
+	scores := g.NeighborhoodScores(opts.Neighborhood)
+	for _, nation := range g.Nations {
+		_, home := g.Planet(nation.Home)
+		log.Printf("[galaxy] nation %d: home %s %v, neighborhood score %d\n", nation.Id, home.Name, home.Coord, scores[nation.Id])
+	}
+	log.Printf("[galaxy] wrote %s\n", output)
+	return nil
+}
+
+// parseKinds parses planet kind weights like "terrestrial=5,gas-giant=3".
+// Kinds that aren't listed get no planets.
+func parseKinds(s string) (map[engine.PlanetKind]int, error) {
+	kinds := map[engine.PlanetKind]int{}
+	for _, field := range strings.Split(s, ",") {
+		kind, weight, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want kind=weight", field)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", field, err)
+		}
+		kinds[engine.PlanetKind(kind)] = n
+	}
+	return kinds, nil
+}
+
+// formatKinds formats planet kind weights in the form that parseKinds reads.
+func formatKinds(kinds map[engine.PlanetKind]int) string {
+	var fields []string
+	for _, kind := range []engine.PlanetKind{engine.Terrestrial, engine.GasGiant, engine.AsteroidBelt} {
+		if weight, ok := kinds[kind]; ok {
+			fields = append(fields, fmt.Sprintf("%s=%d", kind, weight))
+		}
+	}
+	return strings.Join(fields, ",")
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/mdhender/wraithi/internal/config"
 	"github.com/mdhender/wraithi/internal/dot"
 	"github.com/mdhender/wraithi/internal/wraith"
@@ -31,7 +32,19 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if err := run(cfg); err != nil {
+	if len(cfg.Args) == 0 {
+		err = run(cfg)
+	} else {
+		switch cfg.Args[0] {
+		case "audit-export":
+			err = auditExport(cfg, cfg.Args[1:])
+		case "new-galaxy":
+			err = newGalaxy(cfg, cfg.Args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", cfg.Args[0])
+		}
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -8,6 +8,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/peterbourgon/ff/v3 v3.4.0
+	golang.org/x/crypto v0.14.0
 	golang.org/x/oauth2 v0.12.0
 )
 
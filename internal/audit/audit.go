@@ -0,0 +1,112 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package audit defines the events that record who did what, to what, and when.
+// The events are stored by the application's database; this package only
+// describes them and writes them out.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AdminClearLockout    = "admin.clear-lockout"    // an administrator cleared a sign-in lockout
+	AdminDelete          = "admin.delete"           // an administrator deleted a user
+	AdminDisable         = "admin.disable"          // an administrator disabled a user
+	AdminEnable          = "admin.enable"           // an administrator re-enabled a user
+	AdminImpersonate     = "admin.impersonate"      // an administrator started acting as a user
+	AdminImpersonateStop = "admin.impersonate-stop" // an administrator stopped acting as a user
+	AdminImpersonated    = "admin.impersonated"     // a request was made while an administrator was acting as a user
+	AdminRevokeSession   = "admin.revoke-session"   // an administrator signed a user out everywhere
+	AdminTwoFactor       = "admin.reset-2fa"        // an administrator turned off a user's two-factor authentication
+	GameCreate           = "game.create"            // a user created a game
+	GameFinish           = "game.finish"            // a game master finished a game
+	GameMember           = "game.member"            // a game master changed a member's role
+	GameStart            = "game.start"             // a game master generated the galaxy and started a game
+	InviteCreate         = "invite.create"          // an administrator or game master created an invitation
+	InviteDelete         = "invite.delete"          // an administrator or game master deleted an invitation
+	InviteRedeem         = "invite.redeem"          // a user signed up or joined a game with an invitation
+	OrdersSubmit         = "orders.submit"          // a player submitted orders
+	RoleChange           = "role.change"            // an administrator changed a user's roles
+	SignIn               = "signin"                 // a user signed in
+	SignInLocked         = "signin.locked"          // repeated failures locked an account or address out
+	SignInFailed         = "signin.failed"          // a sign-in attempt was rejected
+	SignUp               = "signup"                 // a user created an account
+	TurnRun              = "turn.run"               // a turn was processed
+)
+
+// Target types.
+const (
+	TargetGame   = "game"
+	TargetInvite = "invite"
+	TargetIP     = "ip"
+	TargetUser   = "user"
+)
+
+// Event is a single entry in the audit log.
+type Event struct {
+	Id         int64     `json:"id"`
+	At         time.Time `json:"at"`
+	ActorId    string    `json:"actor_id,omitempty"` // empty for anonymous requests and the system
+	Actor      string    `json:"actor,omitempty"`    // the actor's handle when the event was read
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type,omitempty"`
+	TargetId   string    `json:"target_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	// ImpersonatorId is set when an administrator was acting as the actor.
+	ImpersonatorId string `json:"impersonator_id,omitempty"`
+}
+
+// Filter selects events from the log.
+// Empty fields match every event.
+type Filter struct {
+	Actor  string // the actor's id or handle
+	Action string // matches the action or, if it ends with a ".", every action with that prefix
+	Target string // the target's id
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int // zero for no limit
+}
+
+// Store records and reads events.
+type Store interface {
+	AuditEvents(f Filter) ([]Event, int, error)
+	RecordAudit(e Event) error
+}
+
+// ErrInvalidTime is returned when a time can't be parsed.
+var ErrInvalidTime = errors.New("invalid time")
+
+// ParseTime parses a time from a filter.
+// It accepts RFC 3339 timestamps and plain dates, which are taken as midnight UTC.
+// An empty string is the zero time.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, ErrInvalidTime
+}
+
+// WriteJSONL writes the events as JSON Lines, one event per line.
+func WriteJSONL(w io.Writer, events []Event) error {
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package audit_test
+
+import (
+	"bytes"
+	"github.com/mdhender/wraithi/internal/audit"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	for _, tc := range []struct {
+		id    int
+		input string
+		want  time.Time
+		ok    bool
+	}{
+		{1, "", time.Time{}, true},
+		{2, "2023-10-01", time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), true},
+		{3, "2023-10-01T13:45", time.Date(2023, 10, 1, 13, 45, 0, 0, time.UTC), true},
+		{4, "2023-10-01T13:45:30-05:00", time.Date(2023, 10, 1, 18, 45, 30, 0, time.UTC), true},
+		{5, "yesterday", time.Time{}, false},
+	} {
+		got, err := audit.ParseTime(tc.input)
+		if tc.ok && err != nil {
+			t.Errorf("%d: want nil, got %v", tc.id, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%d: want error, got nil", tc.id)
+		} else if !got.Equal(tc.want) {
+			t.Errorf("%d: want %v, got %v", tc.id, tc.want, got)
+		}
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	at := time.Date(2023, 10, 1, 13, 45, 0, 0, time.UTC)
+	events := []audit.Event{
+		{Id: 1, At: at, Action: audit.SignInFailed, TargetType: audit.TargetUser, TargetId: "u1", IP: "127.0.0.1"},
+		{Id: 2, At: at, ActorId: "u1", Action: audit.GameCreate, TargetType: audit.TargetGame, TargetId: "g1", Detail: "alpha"},
+	}
+	var b bytes.Buffer
+	if err := audit.WriteJSONL(&b, events); err != nil {
+		t.Fatalf("write: want nil, got %v", err)
+	}
+	want := `{"id":1,"at":"2023-10-01T13:45:00Z","action":"signin.failed","target_type":"user","target_id":"u1","ip":"127.0.0.1"}
+{"id":2,"at":"2023-10-01T13:45:00Z","actor_id":"u1","action":"game.create","target_type":"game","target_id":"g1","detail":"alpha"}
+`
+	if got := b.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
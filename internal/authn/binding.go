@@ -0,0 +1,58 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package authn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BindState returns a signed value, meant for a short-lived cookie, that ties
+// the state nonce of a login attempt to the browser that started it.
+// Without it, an attacker could start a login, then trick a victim into
+// completing it with the attacker's code (login CSRF).
+func BindState(key []byte, provider, state string, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(provider)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(state)) + "." + expires
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signState(key, payload))
+}
+
+// CheckState returns nil if the bound value was signed with the key,
+// hasn't expired, and matches the provider and state from the callback.
+// Otherwise, it returns ErrStateMismatch.
+func CheckState(key []byte, bound, provider, state string) error {
+	fields := strings.Split(bound, ".")
+	if len(fields) != 4 || state == "" {
+		return ErrStateMismatch
+	}
+	payload := strings.Join(fields[:3], ".")
+	sig, err := base64.RawURLEncoding.DecodeString(fields[3])
+	if err != nil || !hmac.Equal(sig, signState(key, payload)) {
+		return ErrStateMismatch
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(expires, 0)) {
+		return ErrStateMismatch
+	}
+	boundProvider, err := base64.RawURLEncoding.DecodeString(fields[0])
+	if err != nil || string(boundProvider) != provider {
+		return ErrStateMismatch
+	}
+	boundState, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil || !hmac.Equal(boundState, []byte(state)) {
+		return ErrStateMismatch
+	}
+	return nil
+}
+
+// signState returns the HMAC-SHA256 signature of the payload.
+func signState(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("authn-state:" + payload))
+	return mac.Sum(nil)
+}
@@ -4,14 +4,21 @@ package authn
 
 // Errors used by the package.
 const (
-	ErrDecodingResponse = constError("decoding response")
-	ErrExchangeCode     = constError("exchange-code failed")
-	ErrFetchUser        = constError("fetch-user failed")
-	ErrInvalidNonce     = constError("invalid nonce")
-	ErrMethodNotAllowed = constError("method not allowd")
-	ErrNotImplemented   = constError("not implemented")
-	ErrReadingResponse  = constError("reading response")
-	ErrUnknownProvider  = constError("unknown provider")
+	ErrAccessDenied      = constError("access denied")
+	ErrDecodingResponse  = constError("decoding response")
+	ErrDiscovery         = constError("discovery failed")
+	ErrDuplicateProvider = constError("duplicate provider")
+	ErrExchangeCode      = constError("exchange-code failed")
+	ErrFetchUser         = constError("fetch-user failed")
+	ErrInvalidIdToken    = constError("invalid id token")
+	ErrInvalidNonce      = constError("invalid nonce")
+	ErrMethodNotAllowed  = constError("method not allowd")
+	ErrMissingIssuer     = constError("missing issuer")
+	ErrMissingVerifier   = constError("missing code verifier")
+	ErrNotImplemented    = constError("not implemented")
+	ErrReadingResponse   = constError("reading response")
+	ErrStateMismatch     = constError("state does not match browser")
+	ErrUnknownProvider   = constError("unknown provider")
 )
 
 // declarations to support constant errors
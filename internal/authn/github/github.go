@@ -0,0 +1,200 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/authn"
+	"github.com/mdhender/wraithi/internal/nonces"
+	"github.com/mdhender/wraithi/internal/semver"
+	"golang.org/x/oauth2"
+	oa "golang.org/x/oauth2/github"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type Provider struct {
+	api     string // base url for the GitHub REST API
+	debug   bool
+	nf      *nonces.Factory
+	pkce    bool
+	oauth2  *oauth2.Config
+	version string
+}
+
+// GitHub returns an OAuth2 client that uses GitHub as a provider.
+// The id and secret parameters are the Client ID and Secret.
+// The cb parameter is the callback URL to send to the provider.
+// The nf parameter is the nonce factory used when requesting and validating tokens.
+func GitHub(id, secret, cb string, nf *nonces.Factory) *Provider {
+	return &Provider{
+		api:  "https://api.github.com",
+		nf:   nf,
+		pkce: true,
+		oauth2: &oauth2.Config{
+			RedirectURL:  cb,
+			ClientID:     id,
+			ClientSecret: secret,
+			Scopes: []string{
+				"read:user",
+				"user:email",
+			},
+			Endpoint: oa.Endpoint,
+		},
+		version: semver.Version{Major: 0, Minor: 1, Patch: 0}.String(),
+	}
+}
+
+// Name implements the authn.Provider interface.
+func (p *Provider) Name() string {
+	return "GitHub"
+}
+
+// Code implements the authn.Provider interface.
+func (p *Provider) Code() string {
+	return "github"
+}
+
+// Debug implements the Provider interface.
+func (p *Provider) Debug(flag bool) {
+	p.debug = flag
+}
+
+// PKCE implements the Provider interface.
+func (p *Provider) PKCE(flag bool) {
+	p.pkce = flag
+}
+
+// LoginURL implements the authn.Provider interface.
+func (p *Provider) LoginURL() (string, string, error) {
+	// create a nonce and bind a code verifier to it
+	var nonce, verifier string
+	var err error
+	if p.pkce {
+		nonce, verifier, err = p.nf.CreateWithVerifier()
+	} else {
+		nonce, err = p.nf.Create()
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	// lookup the provider's login page and add our nonce to it.
+	url := p.oauth2.AuthCodeURL(nonce, authn.ChallengeOptions(verifier)...)
+	if p.debug {
+		log.Printf("[github] login: %q\n", url)
+	}
+
+	return url, nonce, nil
+}
+
+// ProcessCallback implements the authn.Provider interface.
+func (p *Provider) ProcessCallback(r *http.Request) (authn.Authentication, error) {
+	if r.Method != "GET" {
+		return authn.Authentication{}, authn.ErrMethodNotAllowed
+	}
+
+	// extract nonce and code from the request
+	nonce, code := r.FormValue("state"), r.FormValue("code")
+	verifier, ok := p.nf.Verifier(nonce)
+	if !ok {
+		return authn.Authentication{}, authn.ErrInvalidNonce
+	} else if p.pkce && verifier == "" {
+		return authn.Authentication{}, errors.Join(authn.ErrInvalidNonce, authn.ErrMissingVerifier)
+	} else if reason := r.FormValue("error"); reason != "" {
+		// the user declined to sign in, or the provider refused them
+		return authn.Authentication{}, errors.Join(authn.ErrAccessDenied, errors.New(reason))
+	}
+
+	// exchange the code for a token
+	ctx := r.Context()
+	token, err := p.oauth2.Exchange(ctx, code, authn.VerifierOptions(verifier)...)
+	if err != nil {
+		return authn.Authentication{}, errors.Join(authn.ErrExchangeCode, err)
+	}
+	client := p.oauth2.Client(ctx, token)
+
+	// use that token to request user information
+	var user struct {
+		Id        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name,omitempty"`
+		AvatarURL string `json:"avatar_url,omitempty"`
+	}
+	if err := p.get(ctx, client, "/user", &user); err != nil {
+		return authn.Authentication{}, err
+	} else if user.Id == 0 {
+		return authn.Authentication{}, errors.Join(authn.ErrFetchUser, fmt.Errorf("missing user id"))
+	}
+	if p.debug {
+		log.Printf("[github] getUserInfo: user %+v\n", user)
+	}
+
+	// the profile only has the public email, so fetch the list of addresses to find the primary one
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, client, "/user/emails", &emails); err != nil {
+		return authn.Authentication{}, err
+	}
+
+	authorization := authn.Authentication{
+		Id:     strconv.FormatInt(user.Id, 10),
+		Name:   user.Name,
+		Avatar: user.AvatarURL,
+	}
+	if authorization.Name == "" {
+		authorization.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			authorization.Email, authorization.VerifiedEmail = email.Email, true
+			break
+		}
+	}
+
+	return authorization, nil
+}
+
+// Version implements the authz.Provider interface.
+func (p *Provider) Version() string {
+	return p.version
+}
+
+// get fetches a resource from the GitHub API and decodes the JSON response into v.
+func (p *Provider) get(ctx context.Context, client *http.Client, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.api+path, nil)
+	if err != nil {
+		return errors.Join(authn.ErrFetchUser, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	response, err := client.Do(req)
+	if err != nil {
+		return errors.Join(authn.ErrFetchUser, err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return errors.Join(authn.ErrFetchUser, fmt.Errorf("%s: %s", path, response.Status))
+	}
+
+	contents, err := io.ReadAll(response.Body)
+	if err != nil {
+		return errors.Join(authn.ErrReadingResponse, err)
+	}
+	if p.debug {
+		log.Printf("[github] get %s: contents %s\n", path, string(contents))
+	}
+	if err := json.Unmarshal(contents, v); err != nil {
+		return errors.Join(authn.ErrDecodingResponse, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package github
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/mdhender/wraithi/internal/authn"
+	"github.com/mdhender/wraithi/internal/nonces"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// stub returns a server that stands in for github.com and api.github.com.
+// The token endpoint checks the PKCE verifier against the challenge.
+func stub(t *testing.T, challenge *string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("code") != "good-code" {
+			http.Error(w, `{"error":"bad_verification_code"}`, http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"stub-token","token_type":"bearer","scope":"read:user,user:email"}`))
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer stub-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":1234,"login":"octocat","name":"","avatar_url":"https://example.com/octocat.png"}`))
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer stub-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"email":"old@example.com","primary":false,"verified":true},
+			{"email":"octocat@example.com","primary":true,"verified":true}
+		]`))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestProcessCallback(t *testing.T) {
+	var challenge string
+	ts := stub(t, &challenge)
+	p := GitHub("client-id", "client-secret", "http://localhost/auth/callback/github", nonces.NewFactory(time.Minute))
+	p.api = ts.URL
+	p.oauth2.Endpoint.AuthURL = ts.URL + "/login/oauth/authorize"
+	p.oauth2.Endpoint.TokenURL = ts.URL + "/login/oauth/access_token"
+
+	loginURL, _, err := p.LoginURL()
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	state := u.Query().Get("state")
+	if state == "" {
+		t.Fatalf("login: missing state in %q", loginURL)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("login: missing S256 challenge in %q", loginURL)
+	}
+	challenge = u.Query().Get("code_challenge")
+
+	// an unknown nonce must be rejected before the code is exchanged
+	r := httptest.NewRequest("GET", "/auth/callback/github?state=bogus&code=good-code", nil)
+	if _, err := p.ProcessCallback(r); !errors.Is(err, authn.ErrInvalidNonce) {
+		t.Errorf("bogus state: want %v, got %v", authn.ErrInvalidNonce, err)
+	}
+
+	r = httptest.NewRequest("GET", "/auth/callback/github?state="+url.QueryEscape(state)+"&code=good-code", nil)
+	got, err := p.ProcessCallback(r)
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	want := authn.Authentication{
+		Id:            "1234",
+		Name:          "octocat",
+		Email:         "octocat@example.com",
+		VerifiedEmail: true,
+		Avatar:        "https://example.com/octocat.png",
+	}
+	if got != want {
+		t.Errorf("callback: want %+v, got %+v", want, got)
+	}
+
+	// nonces are single use
+	r = httptest.NewRequest("GET", "/auth/callback/github?state="+url.QueryEscape(state)+"&code=good-code", nil)
+	if _, err := p.ProcessCallback(r); !errors.Is(err, authn.ErrInvalidNonce) {
+		t.Errorf("replayed state: want %v, got %v", authn.ErrInvalidNonce, err)
+	}
+}
@@ -19,6 +19,7 @@ import (
 type Provider struct {
 	debug   bool
 	nf      *nonces.Factory
+	pkce    bool
 	oauth2  *oauth2.Config
 	version string
 }
@@ -29,7 +30,8 @@ type Provider struct {
 // The nf parameter is the nonce factory used when requesting and validating tokens.
 func Google(id, secret, cb string, nf *nonces.Factory) *Provider {
 	return &Provider{
-		nf: nf,
+		nf:   nf,
+		pkce: true,
 		oauth2: &oauth2.Config{
 			RedirectURL:  cb,
 			ClientID:     id,
@@ -59,21 +61,32 @@ func (p *Provider) Debug(flag bool) {
 	p.debug = flag
 }
 
+// PKCE implements the Provider interface.
+func (p *Provider) PKCE(flag bool) {
+	p.pkce = flag
+}
+
 // LoginURL implements the authn.Provider interface.
-func (p *Provider) LoginURL() (string, error) {
-	// create a nonce
-	nonce, err := p.nf.Create()
+func (p *Provider) LoginURL() (string, string, error) {
+	// create a nonce and bind a code verifier to it
+	var nonce, verifier string
+	var err error
+	if p.pkce {
+		nonce, verifier, err = p.nf.CreateWithVerifier()
+	} else {
+		nonce, err = p.nf.Create()
+	}
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	// lookup the provider's login page and add our nonce to it.
-	url := p.oauth2.AuthCodeURL(nonce)
+	url := p.oauth2.AuthCodeURL(nonce, authn.ChallengeOptions(verifier)...)
 	if p.debug {
 		log.Printf("[google] login: %q\n", url)
 	}
 
-	return url, nil
+	return url, nonce, nil
 }
 
 // ProcessCallback implements the authn.Provider interface.
@@ -84,12 +97,18 @@ func (p *Provider) ProcessCallback(r *http.Request) (authn.Authentication, error
 
 	// extract nonce and code from the request
 	nonce, code := r.FormValue("state"), r.FormValue("code")
-	if !p.nf.Lookup(nonce) {
+	verifier, ok := p.nf.Verifier(nonce)
+	if !ok {
 		return authn.Authentication{}, authn.ErrInvalidNonce
+	} else if p.pkce && verifier == "" {
+		return authn.Authentication{}, errors.Join(authn.ErrInvalidNonce, authn.ErrMissingVerifier)
+	} else if reason := r.FormValue("error"); reason != "" {
+		// the user declined to sign in, or the provider refused them
+		return authn.Authentication{}, errors.Join(authn.ErrAccessDenied, errors.New(reason))
 	}
 
 	// exchange the code for a token
-	token, err := p.oauth2.Exchange(context.TODO(), code)
+	token, err := p.oauth2.Exchange(context.TODO(), code, authn.VerifierOptions(verifier)...)
 	if err != nil {
 		return authn.Authentication{}, errors.Join(authn.ErrExchangeCode, err)
 	}
@@ -0,0 +1,284 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/authn"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway is the allowance for clock skew between us and the issuer.
+const leeway = time.Minute
+
+// claims are the id_token claims that we use.
+type claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Name            string   `json:"name,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	EmailVerified   bool     `json:"email_verified,omitempty"`
+	Picture         string   `json:"picture,omitempty"`
+}
+
+// audience is either a single string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the signature and claims of the id_token and returns the claims.
+func (p *Provider) verify(ctx context.Context, rawIdToken, nonce string, now time.Time) (claims, error) {
+	fail := func(format string, args ...any) (claims, error) {
+		return claims{}, errors.Join(authn.ErrInvalidIdToken, fmt.Errorf(format, args...))
+	}
+
+	parts := strings.Split(rawIdToken, ".")
+	if len(parts) != 3 {
+		return fail("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return fail("header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fail("signature: %v", err)
+	}
+	key, err := p.keys.lookup(ctx, p, header.Kid)
+	if err != nil {
+		return claims{}, errors.Join(authn.ErrInvalidIdToken, err)
+	} else if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return claims{}, errors.Join(authn.ErrInvalidIdToken, err)
+	}
+
+	// the signature is good, so we can trust the claims
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return fail("claims: %v", err)
+	}
+	switch {
+	case strings.TrimSuffix(c.Issuer, "/") != p.issuer:
+		return fail("issuer: want %q: got %q", p.issuer, c.Issuer)
+	case !c.Audience.contains(p.oauth2.ClientID):
+		return fail("audience: %v does not include client", []string(c.Audience))
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.oauth2.ClientID:
+		return fail("azp: want %q: got %q", p.oauth2.ClientID, c.AuthorizedParty)
+	case !now.Before(time.Unix(c.Expiry, 0).Add(leeway)):
+		return fail("expired at %v", time.Unix(c.Expiry, 0).UTC())
+	case time.Unix(c.IssuedAt, 0).After(now.Add(leeway)):
+		return fail("issued in the future at %v", time.Unix(c.IssuedAt, 0).UTC())
+	case c.Nonce == "" || c.Nonce != nonce:
+		return fail("nonce mismatch")
+	case c.Subject == "":
+		return fail("missing subject")
+	}
+	return c, nil
+}
+
+// decodeSegment decodes a base64 encoded JSON segment of a token.
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks the signature using the algorithm from the token header.
+// We only accept the asymmetric algorithms; "none" and the HMAC family are rejected.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			return fmt.Errorf("algorithm %q does not match key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' {
+			return fmt.Errorf("algorithm %q does not match key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+// keySet caches the issuer's signing keys.
+type keySet struct {
+	sync.Mutex
+	uri       string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// lookup returns the key with the given id.
+// The keys are refreshed when an unknown key id is seen, since that
+// usually means that the issuer has rotated its keys, but never more
+// often than once a minute.
+func (ks *keySet) lookup(ctx context.Context, p *Provider, kid string) (crypto.PublicKey, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	if key, ok := ks.find(kid); ok {
+		return key, nil
+	} else if time.Since(ks.fetchedAt) < time.Minute {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, ks.uri, &jwks); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	ks.keys, ks.fetchedAt = make(map[string]crypto.PublicKey), time.Now()
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			ks.keys[k.Kid] = key
+		} else if p.debug {
+			log.Printf("[%s] jwks: kid %q: %v\n", p.code, k.Kid, err)
+		}
+	}
+
+	if key, ok := ks.find(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// find returns the key with the id.
+// If the token doesn't name a key and there is only one, we use it.
+func (ks *keySet) find(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// jwk is a JSON Web Key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
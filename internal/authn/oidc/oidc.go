@@ -0,0 +1,231 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package oidc implements a generic OpenID Connect provider.
+// The provider's endpoints are read from the issuer's discovery document
+// and the identity of the user is taken from the verified id_token.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/authn"
+	"github.com/mdhender/wraithi/internal/nonces"
+	"github.com/mdhender/wraithi/internal/semver"
+	"golang.org/x/oauth2"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Provider struct {
+	sync.Mutex
+	code      string
+	name      string
+	issuer    string
+	debug     bool
+	discovery *discovery // nil until the discovery document is fetched
+	keys      *keySet
+	nf        *nonces.Factory
+	pkce      bool
+	oauth2    *oauth2.Config
+	client    *http.Client
+	version   string
+}
+
+// OIDC returns an OpenID Connect client for the issuer.
+// The code is the lower case name used in the callback URL.
+// The id and secret parameters are the Client ID and Secret.
+// The cb parameter is the callback URL to send to the provider.
+// The nf parameter is the nonce factory used when requesting and validating tokens.
+//
+// The discovery document isn't fetched until the first login so that
+// the server can start even if the issuer is unavailable.
+func OIDC(code, name, issuer, id, secret, cb string, nf *nonces.Factory) *Provider {
+	return &Provider{
+		code:   strings.ToLower(code),
+		name:   name,
+		issuer: strings.TrimSuffix(issuer, "/"),
+		nf:     nf,
+		pkce:   true,
+		oauth2: &oauth2.Config{
+			RedirectURL:  cb,
+			ClientID:     id,
+			ClientSecret: secret,
+			Scopes:       []string{"openid", "email", "profile"},
+		},
+		client:  &http.Client{Timeout: 10 * time.Second},
+		version: semver.Version{Major: 0, Minor: 1, Patch: 0}.String(),
+	}
+}
+
+// Name implements the authn.Provider interface.
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// Code implements the authn.Provider interface.
+func (p *Provider) Code() string {
+	return p.code
+}
+
+// Debug implements the Provider interface.
+func (p *Provider) Debug(flag bool) {
+	p.debug = flag
+}
+
+// PKCE implements the Provider interface.
+func (p *Provider) PKCE(flag bool) {
+	p.pkce = flag
+}
+
+// LoginURL implements the authn.Provider interface.
+// The nonce is sent as both the state and the OpenID nonce.
+func (p *Provider) LoginURL() (string, string, error) {
+	if err := p.discover(context.Background()); err != nil {
+		return "", "", err
+	}
+
+	// create a nonce and bind a code verifier to it
+	var nonce, verifier string
+	var err error
+	if p.pkce {
+		nonce, verifier, err = p.nf.CreateWithVerifier()
+	} else {
+		nonce, err = p.nf.Create()
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	// lookup the provider's login page and add our nonce to it.
+	url := p.oauth2.AuthCodeURL(nonce, append(authn.ChallengeOptions(verifier), oauth2.SetAuthURLParam("nonce", nonce))...)
+	if p.debug {
+		log.Printf("[%s] login: %q\n", p.code, url)
+	}
+
+	return url, nonce, nil
+}
+
+// ProcessCallback implements the authn.Provider interface.
+func (p *Provider) ProcessCallback(r *http.Request) (authn.Authentication, error) {
+	if r.Method != "GET" {
+		return authn.Authentication{}, authn.ErrMethodNotAllowed
+	}
+
+	// extract nonce and code from the request
+	nonce, code := r.FormValue("state"), r.FormValue("code")
+	verifier, ok := p.nf.Verifier(nonce)
+	if !ok {
+		return authn.Authentication{}, authn.ErrInvalidNonce
+	} else if p.pkce && verifier == "" {
+		return authn.Authentication{}, errors.Join(authn.ErrInvalidNonce, authn.ErrMissingVerifier)
+	} else if reason := r.FormValue("error"); reason != "" {
+		// the user declined to sign in, or the provider refused them
+		return authn.Authentication{}, errors.Join(authn.ErrAccessDenied, errors.New(reason))
+	}
+
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, p.client)
+	if err := p.discover(ctx); err != nil {
+		return authn.Authentication{}, err
+	}
+
+	// exchange the code for a token
+	token, err := p.oauth2.Exchange(ctx, code, authn.VerifierOptions(verifier)...)
+	if err != nil {
+		return authn.Authentication{}, errors.Join(authn.ErrExchangeCode, err)
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIdToken == "" {
+		return authn.Authentication{}, errors.Join(authn.ErrInvalidIdToken, fmt.Errorf("missing id_token"))
+	}
+
+	c, err := p.verify(ctx, rawIdToken, nonce, time.Now())
+	if err != nil {
+		return authn.Authentication{}, err
+	}
+	if p.debug {
+		log.Printf("[%s] id_token: claims %+v\n", p.code, c)
+	}
+
+	return authn.Authentication{
+		Id:            c.Subject,
+		Name:          c.Name,
+		Email:         c.Email,
+		VerifiedEmail: c.EmailVerified,
+		Avatar:        c.Picture,
+	}, nil
+}
+
+// Version implements the authz.Provider interface.
+func (p *Provider) Version() string {
+	return p.version
+}
+
+// discovery is the subset of the discovery document that we use.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// discover fetches the discovery document from the issuer if we haven't already.
+func (p *Provider) discover(ctx context.Context) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.discovery != nil {
+		return nil
+	}
+
+	var d discovery
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return errors.Join(authn.ErrDiscovery, err)
+	} else if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return errors.Join(authn.ErrDiscovery, fmt.Errorf("issuer: want %q: got %q", p.issuer, d.Issuer))
+	} else if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return errors.Join(authn.ErrDiscovery, fmt.Errorf("missing endpoints"))
+	}
+	if p.debug {
+		log.Printf("[%s] discovery: %+v\n", p.code, d)
+	}
+
+	p.discovery = &d
+	p.keys = &keySet{uri: d.JWKSURI}
+	p.oauth2.Endpoint = oauth2.Endpoint{
+		AuthURL:  d.AuthorizationEndpoint,
+		TokenURL: d.TokenEndpoint,
+	}
+	return nil
+}
+
+// getJSON fetches the resource and decodes the JSON response into v.
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	response, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, response.Status)
+	}
+	contents, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return errors.Join(authn.ErrReadingResponse, err)
+	}
+	if err := json.Unmarshal(contents, v); err != nil {
+		return errors.Join(authn.ErrDecodingResponse, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/wraithi/internal/authn"
+	"github.com/mdhender/wraithi/internal/nonces"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// issuer is a stub OpenID Connect issuer.
+type issuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any // claims for the next id_token
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &issuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.URL,
+			"authorization_endpoint": iss.URL + "/authorize",
+			"token_endpoint":         iss.URL + "/token",
+			"jwks_uri":               iss.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "stub-token",
+			"token_type":   "Bearer",
+			"id_token":     iss.sign(t, iss.claims),
+		})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *issuer) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key-1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestProcessCallback(t *testing.T) {
+	iss := newIssuer(t)
+	p := OIDC("dex", "Dex", iss.URL, "wraith", "secret", "http://localhost/auth/callback/dex", nonces.NewFactory(time.Minute))
+
+	// login returns the state, which the issuer echoes back as the nonce
+	login := func() string {
+		loginURL, _, err := p.LoginURL()
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+		u, _ := url.Parse(loginURL)
+		if u.Query().Get("nonce") != u.Query().Get("state") {
+			t.Fatalf("login: nonce and state differ in %q", loginURL)
+		}
+		return u.Query().Get("state")
+	}
+	callback := func(state string) (authn.Authentication, error) {
+		r := httptest.NewRequest("GET", "/auth/callback/dex?code=abc&state="+url.QueryEscape(state), nil)
+		return p.ProcessCallback(r)
+	}
+	valid := func(nonce string) map[string]any {
+		return map[string]any{
+			"iss":            iss.URL,
+			"sub":            "user-42",
+			"aud":            "wraith",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          nonce,
+			"name":           "Forty Two",
+			"email":          "42@example.com",
+			"email_verified": true,
+		}
+	}
+
+	state := login()
+	iss.claims = valid(state)
+	got, err := callback(state)
+	if err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	want := authn.Authentication{Id: "user-42", Name: "Forty Two", Email: "42@example.com", VerifiedEmail: true}
+	if got != want {
+		t.Errorf("valid token: want %+v, got %+v", want, got)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(c map[string]any)
+	}{
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "someone-else" }},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"wrong nonce", func(c map[string]any) { c["nonce"] = "replayed" }},
+		{"multiple audiences without azp", func(c map[string]any) { c["aud"] = []string{"wraith", "other"} }},
+	} {
+		state := login()
+		iss.claims = valid(state)
+		tc.mutate(iss.claims)
+		if _, err := callback(state); !errors.Is(err, authn.ErrInvalidIdToken) {
+			t.Errorf("%s: want %v, got %v", tc.name, authn.ErrInvalidIdToken, err)
+		}
+	}
+
+	// a token signed by a different key must be rejected
+	state = login()
+	iss.claims = valid(state)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	iss.key, other = other, iss.key
+	_, err = callback(state)
+	iss.key = other
+	if !errors.Is(err, authn.ErrInvalidIdToken) {
+		t.Errorf("forged signature: want %v, got %v", authn.ErrInvalidIdToken, err)
+	}
+}
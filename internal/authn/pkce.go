@@ -0,0 +1,33 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package authn
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"golang.org/x/oauth2"
+)
+
+// ChallengeOptions returns the options that add an S256 PKCE code challenge
+// for the verifier to the authorization URL, per RFC 7636 section 4.2.
+func ChallengeOptions(verifier string) []oauth2.AuthCodeOption {
+	if verifier == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+	}
+}
+
+// VerifierOptions returns the options that send the PKCE code verifier
+// with the token exchange, per RFC 7636 section 4.5.
+func VerifierOptions(verifier string) []oauth2.AuthCodeOption {
+	if verifier == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_verifier", verifier),
+	}
+}
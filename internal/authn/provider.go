@@ -5,11 +5,24 @@ package authn
 import "net/http"
 
 // Provider interface for OAuth2 clients.
+//
+// Providers use PKCE with the S256 method by default.
+// LoginURL binds a code verifier to the state nonce and sends the challenge
+// to the provider; ProcessCallback must send that verifier when it exchanges
+// the code, so an intercepted authorization code is useless on its own.
+//
+// LoginURL also returns the state nonce so that the caller can bind it to
+// the browser (see BindState) before redirecting to the provider.
+//
+// ProcessCallback returns ErrInvalidNonce if the state is unknown or was
+// already used, and ErrAccessDenied if the provider sent back an error
+// (for example, because the user declined to sign in).
 type Provider interface {
-	Name() string              // official name of the provider
-	Code() string              // lower case name of the provider
-	Debug(bool)                // enable/disable debugging for the provider
-	LoginURL() (string, error) // get login url (with nonce, if needed)
+	Name() string                             // official name of the provider
+	Code() string                             // lower case name of the provider
+	Debug(bool)                               // enable/disable debugging for the provider
+	PKCE(bool)                                // enable/disable PKCE for the provider (enabled by default)
+	LoginURL() (url, state string, err error) // get login url (with nonce and code challenge, if needed) and the state nonce
 	ProcessCallback(r *http.Request) (Authentication, error)
 	Version() string
 }
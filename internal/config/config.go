@@ -16,6 +16,7 @@ import (
 
 // Config defines configuration information for the application.
 type Config struct {
+	Args  []string // command and its arguments; empty to run the server
 	Debug bool
 	App   struct {
 		Data            string // path to data files
@@ -25,7 +26,9 @@ type Config struct {
 		TimestampFormat string
 	}
 	Auth struct {
-		Providers string // comma separated list of authentication providers
+		CallbackURL string // base url for provider callbacks; the provider code is appended
+		Providers   string // comma separated list of authentication providers
+		InviteOnly  bool   // new accounts need an invitation
 	}
 	Cookies struct {
 		HttpOnly bool
@@ -34,7 +37,18 @@ type Config struct {
 	DB       DBConfig
 	FileName string
 	Home     string
-	Server   struct {
+	Mail     struct {
+		From      string // address that messages are sent from
+		Transport string // either "smtp", "file", or "memory"
+		SMTP      struct {
+			Host   string
+			Port   int
+			User   string
+			Secret string
+		}
+	}
+	Server struct {
+		BaseURL        string // public url for links in e-mail; derived from scheme, host, and port if empty
 		Scheme         string
 		Host           string
 		MaxHeaderBytes int
@@ -47,6 +61,13 @@ type Config struct {
 		Key  string
 		Salt string
 	}
+	Lockout struct {
+		Store string // either "mysql" or "memory"
+	}
+	Sessions struct {
+		Store string        // either "mysql" or "memory"
+		TTL   time.Duration // lifetime of a session token
+	}
 }
 
 // Default returns a default configuration.
@@ -58,8 +79,14 @@ func Default() (*Config, error) {
 	cfg.App.Data = filepath.Join(cfg.App.Root, "testdata")
 	cfg.App.Templates = filepath.Join(cfg.App.Root, "templates")
 	cfg.App.TimestampFormat = "2006-01-02T15:04:05.99999999Z"
+	cfg.Auth.CallbackURL = "http://localhost:8080/auth/callback"
 	cfg.Auth.Providers = "Google"
+	cfg.Cookies.HttpOnly = true
 	cfg.DB.Port = 3306
+	cfg.Mail.From = "Wraith <wraith@localhost>"
+	cfg.Mail.Transport = "file"
+	cfg.Mail.SMTP.Host = "localhost"
+	cfg.Mail.SMTP.Port = 1025
 	if home, err := homedir.Dir(); err != nil {
 		return nil, fmt.Errorf("home: %w", err)
 	} else {
@@ -74,6 +101,9 @@ func Default() (*Config, error) {
 	cfg.Server.Timeout.Idle = 10 * time.Second
 	cfg.Server.Timeout.Read = 5 * time.Second
 	cfg.Server.Timeout.Write = 10 * time.Second
+	cfg.Lockout.Store = "mysql"
+	cfg.Sessions.Store = "mysql"
+	cfg.Sessions.TTL = 24 * time.Hour
 	return &cfg, nil
 }
 
@@ -92,26 +122,40 @@ func (cfg *Config) Load() error {
 	fs.DurationVar(&cfg.Server.Timeout.Idle, "idle-timeout", cfg.Server.Timeout.Idle, "http idle timeout")
 	fs.DurationVar(&cfg.Server.Timeout.Read, "read-timeout", cfg.Server.Timeout.Read, "http read timeout")
 	fs.DurationVar(&cfg.Server.Timeout.Write, "write-timeout", cfg.Server.Timeout.Write, "http write timeout")
+	fs.DurationVar(&cfg.Sessions.TTL, "session-ttl", cfg.Sessions.TTL, "lifetime of session tokens")
 	fs.IntVar(&cfg.DB.Port, "db-port", cfg.DB.Port, "port of mysql database")
+	fs.IntVar(&cfg.Mail.SMTP.Port, "smtp-port", cfg.Mail.SMTP.Port, "port of smtp server")
 	fs.StringVar(&cfg.App.Assets, "assets", cfg.App.Assets, "path to serve web assets from")
 	fs.StringVar(&cfg.App.Data, "data", cfg.App.Data, "path to data files")
 	fs.StringVar(&cfg.App.Root, "root", cfg.App.Root, "path to treat as root for relative file references")
+	fs.StringVar(&cfg.Auth.CallbackURL, "auth-callback-url", cfg.Auth.CallbackURL, "base url for authentication provider callbacks")
+	fs.StringVar(&cfg.Auth.Providers, "auth-providers", cfg.Auth.Providers, "comma separated list of authentication providers")
+	fs.BoolVar(&cfg.Auth.InviteOnly, "invite-only", cfg.Auth.InviteOnly, "require an invitation to sign up")
 	fs.StringVar(&cfg.App.Templates, "templates", cfg.App.Templates, "path to template files")
 	fs.StringVar(&cfg.DB.Host, "db-host", cfg.DB.Host, "host of mysql database")
 	fs.StringVar(&cfg.DB.Name, "db-name", cfg.DB.Name, "name of mysql database")
 	fs.StringVar(&cfg.DB.Secret, "db-secret", cfg.DB.Secret, "secret for mysql database")
 	fs.StringVar(&cfg.DB.User, "db-user", cfg.DB.User, "user in mysql database")
 	fs.StringVar(&cfg.FileName, "config", cfg.FileName, "config file (optional)")
+	fs.StringVar(&cfg.Lockout.Store, "lockout-store", cfg.Lockout.Store, "sign-in failure counters, either 'mysql' or 'memory'")
+	fs.StringVar(&cfg.Mail.From, "mail-from", cfg.Mail.From, "address to send e-mail from")
+	fs.StringVar(&cfg.Mail.Transport, "mail-transport", cfg.Mail.Transport, "how to send e-mail, either 'smtp', 'file', or 'memory'")
+	fs.StringVar(&cfg.Mail.SMTP.Host, "smtp-host", cfg.Mail.SMTP.Host, "host of smtp server")
+	fs.StringVar(&cfg.Mail.SMTP.Secret, "smtp-secret", cfg.Mail.SMTP.Secret, "secret for smtp server")
+	fs.StringVar(&cfg.Mail.SMTP.User, "smtp-user", cfg.Mail.SMTP.User, "user for smtp server (optional)")
+	fs.StringVar(&cfg.Server.BaseURL, "base-url", cfg.Server.BaseURL, "public url of the server, used in e-mail links (optional)")
 	fs.StringVar(&cfg.Server.Host, "host", cfg.Server.Host, "host name (or IP) to listen on")
 	fs.StringVar(&cfg.Server.Key, "key", cfg.Server.Key, "set key for signing tokens")
 	fs.StringVar(&cfg.Server.Port, "port", cfg.Server.Port, "port to listen on")
 	fs.StringVar(&cfg.Server.Salt, "salt", cfg.Server.Salt, "set salt for hashing passwords")
 	fs.StringVar(&cfg.Server.Scheme, "scheme", cfg.Server.Scheme, "http scheme, either 'http' or 'https'")
+	fs.StringVar(&cfg.Sessions.Store, "session-store", cfg.Sessions.Store, "session store, either 'mysql' or 'memory'")
 
 	err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("WRAITH"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser))
 	if err != nil {
 		return err
 	}
+	cfg.Args = fs.Args()
 
 	if cfg.App.Root, err = filepath.Abs(cfg.App.Root); err != nil {
 		return fmt.Errorf("root: %w", err)
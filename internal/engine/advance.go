@@ -0,0 +1,400 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Rules for running a turn.
+const (
+	combatRounds      = 3
+	warshipDamage     = 10    // hull points each warship takes off a target every round
+	settlerPopulation = 1_000 // the population of a new colony
+)
+
+// Advance runs the next turn with each nation's orders and returns the new state
+// along with the events of the turn. Nations without orders do nothing.
+// The game that is passed in is left unchanged.
+//
+// The turn runs in the order of the phases. Within each phase, nations,
+// systems, and planets are handled in the order of their ids, and contested
+// results are decided by rules rather than by who went first, so the result
+// depends only on the state and the orders and never on the order that the
+// orders were submitted in.
+func Advance(g *Game, orders map[int][]Order) (Turn, error) {
+	if err := g.Validate(); err != nil {
+		return Turn{}, err
+	}
+	for nation := range orders {
+		if g.Nation(nation) == nil {
+			return Turn{}, fmt.Errorf("orders: nation %d: %w", nation, ErrUnknownNation)
+		}
+	}
+
+	t := &turn{g: g.Clone(), orders: map[int][]Order{}}
+	t.g.Turn++
+	for _, n := range g.Nations {
+		t.nations = append(t.nations, n.Id)
+	}
+	sort.Ints(t.nations)
+
+	if err := t.intake(g, orders); err != nil {
+		return Turn{}, err
+	}
+	t.movement()
+	t.combat()
+	t.colonization()
+	t.production()
+	t.research()
+	t.reporting()
+	return Turn{Number: t.g.Turn, Game: t.g, Events: t.events}, nil
+}
+
+// turn is the work in progress while a turn runs.
+type turn struct {
+	g       *Game
+	nations []int           // every nation's id, in order
+	orders  map[int][]Order // each nation's accepted orders
+	phase   Phase
+	events  []Event
+}
+
+// log records an event in the current phase.
+func (t *turn) log(e Event) {
+	e.Phase = t.phase
+	t.events = append(t.events, e)
+}
+
+// newId returns the next unused id.
+func (t *turn) newId() int {
+	id := t.g.NextId
+	t.g.NextId++
+	return id
+}
+
+// intake checks every nation's orders against its view of the state at the start
+// of the turn, so that no nation's orders can change whether another's are accepted
+// and no order can depend on something that the nation can't see.
+// Fleets get their destinations and scrapped ships are removed.
+func (t *turn) intake(start *Game, orders map[int][]Order) error {
+	t.phase = PhaseIntake
+	for _, nation := range t.nations {
+		view, err := start.View(nation)
+		if err != nil {
+			return err
+		}
+		v := ValidateOrders(view.Game, nation, orders[nation])
+		for _, r := range v.Rejected {
+			t.log(Event{Kind: OrderRejected, Nation: nation, Order: r.Order.String(), Line: r.Order.Line, Reason: r.Reason})
+		}
+		t.orders[nation] = v.Accepted
+		for _, o := range v.Accepted {
+			switch o.Kind {
+			case Hold:
+				t.g.Fleet(o.Fleet).Destination = 0
+			case Move:
+				t.g.Fleet(o.Fleet).Destination = o.System
+			case Scrap:
+				f := fleetWith(t.g, o.Ship)
+				for i, s := range f.Ships {
+					if s.Id == o.Ship {
+						t.log(Event{Kind: ShipScrapped, Nation: nation, System: f.System, Fleet: f.Id, Ship: s.Id, Product: string(s.Class)})
+						f.Ships = append(f.Ships[:i], f.Ships[i+1:]...)
+						break
+					}
+				}
+			}
+		}
+	}
+	t.removeEmptyFleets()
+	return nil
+}
+
+// movement moves every fleet with a destination to it.
+func (t *turn) movement() {
+	t.phase = PhaseMovement
+	for _, f := range t.g.Fleets {
+		if f.Destination != 0 && f.Destination != f.System {
+			t.log(Event{Kind: FleetMoved, Nation: f.Nation, System: f.Destination, From: f.System, Fleet: f.Id})
+			f.System = f.Destination
+		}
+		f.Destination = 0
+	}
+}
+
+// combat fights a battle in every system where a nation's warships share the system
+// with another nation's ships. In each round, every warship fires at the first enemy
+// ship that isn't already doomed, and the damage from all sides lands at once.
+func (t *turn) combat() {
+	t.phase = PhaseCombat
+	for _, s := range t.g.Galaxy.Systems {
+		present, armed := t.nationsIn(s.Id)
+		if len(present) < 2 || !armed {
+			continue
+		}
+		for _, n := range present {
+			t.log(Event{Kind: Battle, Nation: n, Observers: without(present, n), System: s.Id})
+		}
+		for round := 0; round < combatRounds; round++ {
+			damage := map[*Ship]int{}
+			for _, n := range present {
+				targets := t.targets(s.Id, n)
+				for _, f := range t.g.Fleets {
+					if f.System != s.Id || f.Nation != n {
+						continue
+					}
+					for _, warship := range f.Ships {
+						if warship.Class != Warship {
+							continue
+						}
+						for _, target := range targets {
+							if damage[target] < target.Hull {
+								damage[target] += warshipDamage
+								break
+							}
+						}
+					}
+				}
+			}
+			if len(damage) == 0 {
+				break
+			}
+			for _, f := range t.g.Fleets {
+				if f.System != s.Id {
+					continue
+				}
+				ships := f.Ships[:0]
+				for _, ship := range f.Ships {
+					if ship.Hull -= damage[ship]; ship.Hull > 0 {
+						ships = append(ships, ship)
+						continue
+					}
+					t.log(Event{Kind: ShipDestroyed, Nation: f.Nation, Observers: without(present, f.Nation), System: s.Id, Fleet: f.Id, Ship: ship.Id, Product: string(ship.Class)})
+				}
+				f.Ships = ships
+			}
+		}
+	}
+	t.removeEmptyFleets()
+}
+
+// nationsIn returns the nations with ships in the system, in order,
+// and whether any of those ships are warships.
+func (t *turn) nationsIn(system int) (nations []int, armed bool) {
+	seen := map[int]bool{}
+	for _, f := range t.g.Fleets {
+		if f.System != system || len(f.Ships) == 0 {
+			continue
+		} else if !seen[f.Nation] {
+			seen[f.Nation] = true
+			nations = append(nations, f.Nation)
+		}
+		armed = armed || countClass(f, Warship) != 0
+	}
+	sort.Ints(nations)
+	return nations, armed
+}
+
+// targets returns the ships of every other nation in the system.
+func (t *turn) targets(system, nation int) []*Ship {
+	var list []*Ship
+	for _, f := range t.g.Fleets {
+		if f.System == system && f.Nation != nation {
+			list = append(list, f.Ships...)
+		}
+	}
+	return list
+}
+
+// colonization settles planets with colony ships.
+// When more than one nation tries to settle a planet in the same turn, none of them do.
+func (t *turn) colonization() {
+	t.phase = PhaseColonization
+	type attempt struct {
+		nation int
+		order  Order
+	}
+	attempts := map[int][]attempt{}
+	var planets []int
+	for _, nation := range t.nations {
+		for _, o := range t.orders[nation] {
+			if o.Kind != Colonize {
+				continue
+			} else if len(attempts[o.Planet]) == 0 {
+				planets = append(planets, o.Planet)
+			}
+			attempts[o.Planet] = append(attempts[o.Planet], attempt{nation: nation, order: o})
+		}
+	}
+	sort.Ints(planets)
+
+	for _, id := range planets {
+		p, s := t.g.Planet(id)
+		for _, a := range attempts[id] {
+			failed := func(reason string) {
+				t.log(Event{Kind: ColonizeFailed, Nation: a.nation, System: s.Id, Planet: p.Id, Fleet: a.order.Fleet, Reason: reason})
+			}
+			f := t.g.Fleet(a.order.Fleet)
+			if len(attempts[id]) > 1 {
+				failed("another nation tried to settle the planet")
+			} else if f == nil {
+				failed(fmt.Sprintf("fleet %d was lost", a.order.Fleet))
+			} else if f.System != s.Id {
+				failed(fmt.Sprintf("fleet %d isn't in system %d", a.order.Fleet, s.Id))
+			} else if countClass(f, ColonyShip) == 0 {
+				failed(fmt.Sprintf("fleet %d has no colony ship", f.Id))
+			} else if t.g.ColonyOn(p.Id) != nil {
+				failed("the planet already has a colony")
+			} else {
+				for i, ship := range f.Ships {
+					if ship.Class == ColonyShip {
+						f.Ships = append(f.Ships[:i], f.Ships[i+1:]...)
+						break
+					}
+				}
+				c := &Colony{Id: t.newId(), Nation: a.nation, Planet: p.Id, Population: min(settlerPopulation, p.Capacity())}
+				t.g.Colonies = append(t.g.Colonies, c)
+				t.log(Event{Kind: ColonyFounded, Nation: a.nation, System: s.Id, Planet: p.Id, Colony: c.Id, Fleet: f.Id})
+			}
+		}
+	}
+	t.removeEmptyFleets()
+}
+
+// production spends production on building and transfers,
+// then grows every colony and adds its output to the stockpile.
+// Ships built at a colony form a new fleet in the colony's system.
+func (t *turn) production() {
+	t.phase = PhaseProduction
+	for _, nation := range t.nations {
+		n := t.g.Nation(nation)
+		built := map[int]*Fleet{} // colony id to the fleet of new ships
+		for _, o := range t.orders[nation] {
+			switch o.Kind {
+			case Build:
+				c := t.g.Colony(o.Colony)
+				_, s := t.g.Planet(c.Planet)
+				n.Stockpile -= o.Amount * Cost(o.Product)
+				e := Event{Kind: Built, Nation: nation, System: s.Id, Colony: c.Id, Product: o.Product, Amount: o.Amount}
+				if o.Product == Industry {
+					c.Industry += o.Amount
+				} else {
+					f := built[c.Id]
+					if f == nil {
+						f = &Fleet{Id: t.newId(), Nation: nation, System: s.Id}
+						built[c.Id] = f
+						t.g.Fleets = append(t.g.Fleets, f)
+					}
+					for i := 0; i < o.Amount; i++ {
+						class := ShipClass(o.Product)
+						f.Ships = append(f.Ships, &Ship{Id: t.newId(), Class: class, Hull: class.Hull()})
+					}
+					e.Fleet = f.Id
+				}
+				t.log(e)
+			case Transfer:
+				n.Stockpile -= o.Amount
+				t.g.Nation(o.Nation).Stockpile += o.Amount
+				t.log(Event{Kind: Transferred, Nation: nation, Observers: []int{o.Nation}, Other: o.Nation, Amount: o.Amount})
+			}
+		}
+	}
+	grow(t.g)
+	for _, c := range t.g.Colonies {
+		if output := min(c.Industry, c.Population/10); output > 0 {
+			t.g.Nation(c.Nation).Stockpile += output
+			t.log(Event{Kind: Produced, Nation: c.Nation, Planet: c.Planet, Colony: c.Id, Amount: output})
+		}
+	}
+}
+
+// research spends production on research.
+func (t *turn) research() {
+	t.phase = PhaseResearch
+	for _, nation := range t.nations {
+		n := t.g.Nation(nation)
+		for _, o := range t.orders[nation] {
+			if o.Kind == Research {
+				n.Stockpile -= o.Amount
+				n.Research += o.Amount
+				t.log(Event{Kind: Researched, Nation: nation, Amount: o.Amount})
+			}
+		}
+	}
+}
+
+// reporting shares fleet movements, battles, and new colonies with every nation
+// that can see the system at the end of the turn, then updates what every nation
+// remembers.
+func (t *turn) reporting() {
+	t.phase = PhaseReporting
+	visible := map[int]map[int]bool{}
+	for _, n := range t.nations {
+		visible[n] = t.g.Visible(n)
+	}
+	for i := range t.events {
+		e := &t.events[i]
+		switch e.Kind {
+		case Battle, ColonyFounded, FleetMoved, ShipDestroyed:
+		default:
+			continue
+		}
+		for _, n := range t.nations {
+			if visible[n][e.System] && !e.SeenBy(n) {
+				e.Observers = append(e.Observers, n)
+			}
+		}
+		sort.Ints(e.Observers)
+	}
+	t.g.remember()
+}
+
+// removeEmptyFleets removes fleets that have lost all their ships.
+func (t *turn) removeEmptyFleets() {
+	fleets := t.g.Fleets[:0]
+	for _, f := range t.g.Fleets {
+		if len(f.Ships) != 0 {
+			fleets = append(fleets, f)
+		}
+	}
+	t.g.Fleets = fleets
+}
+
+// grow grows the population of every colony by up to a tenth,
+// depending on the planet's habitability, without passing its capacity.
+func grow(g *Game) {
+	for _, c := range g.Colonies {
+		p, _ := g.Planet(c.Planet)
+		if c.Population == 0 || c.Population >= p.Capacity() {
+			continue
+		}
+		growth := c.Population * p.Habitability / (10 * MaxHabitability)
+		if growth == 0 && p.Habitability != 0 {
+			growth = 1
+		}
+		c.Population = min(c.Population+growth, p.Capacity())
+	}
+}
+
+// contains returns true if the id is in the list.
+func contains(list []int, id int) bool {
+	for _, v := range list {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// without returns a copy of the list without the id.
+func without(list []int, id int) []int {
+	var other []int
+	for _, v := range list {
+		if v != id {
+			other = append(other, v)
+		}
+	}
+	return other
+}
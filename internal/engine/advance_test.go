@@ -0,0 +1,170 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/engine"
+	"github.com/mdhender/wraithi/internal/orders"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected turn results in testdata")
+
+// The fixtures are games in the data folder's layout.
+// For each orders-NNNN folder, the test runs the turn from turn-(NNNN-1).json
+// with the nation-N.txt orders in the folder and compares the results to
+// turn-NNNN.json and events-NNNN.json.
+const fixtures = "../../testdata/games"
+
+func TestAdvanceFixtures(t *testing.T) {
+	folders, err := filepath.Glob(filepath.Join(fixtures, "*", "orders-*"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(folders) == 0 {
+		t.Fatalf("no fixtures in %s", fixtures)
+	}
+	for _, folder := range folders {
+		game := filepath.Dir(folder)
+		number, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(folder), "orders-"))
+		if err != nil {
+			t.Fatalf("%s: %v", folder, err)
+		}
+		name := fmt.Sprintf("%s/%04d", filepath.Base(game), number)
+
+		g := loadFixture(t, filepath.Join(game, fmt.Sprintf("turn-%04d.json", number-1)))
+		turn, err := engine.Advance(g, loadOrders(t, folder))
+		if err != nil {
+			t.Errorf("%s: advance: want nil, got %v", name, err)
+			continue
+		}
+		gotState, gotEvents := &bytes.Buffer{}, &bytes.Buffer{}
+		if err := turn.Game.Save(gotState); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if err := engine.SaveEvents(gotEvents, turn.Events); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		statePath := filepath.Join(game, fmt.Sprintf("turn-%04d.json", number))
+		eventsPath := filepath.Join(game, fmt.Sprintf("events-%04d.json", number))
+		if *update {
+			if err := os.WriteFile(statePath, gotState.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			} else if err := os.WriteFile(eventsPath, gotEvents.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		for _, tc := range []struct {
+			path string
+			got  []byte
+		}{
+			{statePath, gotState.Bytes()},
+			{eventsPath, gotEvents.Bytes()},
+		} {
+			want, err := os.ReadFile(tc.path)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			} else if !bytes.Equal(tc.got, want) {
+				t.Errorf("%s: %s: results differ; run the tests with -update to see how", name, filepath.Base(tc.path))
+			}
+		}
+	}
+}
+
+// loadFixture reads a game's state.
+func loadFixture(t *testing.T, path string) *engine.Game {
+	t.Helper()
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+	g, err := engine.Load(fp)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return g
+}
+
+// loadOrders reads the nation-N.txt files in the folder.
+// Lines with mistakes are left out, as they are when a game master runs a turn.
+func loadOrders(t *testing.T, folder string) map[int][]engine.Order {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(folder, "nation-*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := map[int][]engine.Order{}
+	for _, file := range files {
+		nation, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "nation-"), ".txt"))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list[nation], _ = orders.Parse(string(b))
+	}
+	return list
+}
+
+func TestAdvanceOrders(t *testing.T) {
+	g := testGame()
+	g.Fleets[0].Destination = 0
+	g.Fleets = append(g.Fleets,
+		&engine.Fleet{Id: 10, Nation: 1, System: 4, Ships: []*engine.Ship{{Id: 11, Class: engine.ColonyShip, Hull: 10}}},
+		&engine.Fleet{Id: 12, Nation: 2, System: 4, Ships: []*engine.Ship{{Id: 13, Class: engine.ColonyShip, Hull: 10}}},
+	)
+	g.Galaxy.Systems[1].Planets = append(g.Galaxy.Systems[1].Planets, &engine.Planet{Id: 14, Orbit: 2, Kind: engine.Terrestrial, Habitability: 5})
+	g.NextId = 15
+
+	// both nations try to settle planet 14, so neither does
+	orders := map[int][]engine.Order{
+		1: {{Kind: engine.Colonize, Planet: 14, Fleet: 10}},
+		2: {{Kind: engine.Colonize, Planet: 14, Fleet: 12}, {Kind: engine.Transfer, Amount: 7, Nation: 1}},
+	}
+	turn, err := engine.Advance(g, orders)
+	if err != nil {
+		t.Fatalf("advance: want nil, got %v", err)
+	}
+	if c := turn.Game.ColonyOn(14); c != nil {
+		t.Errorf("contested: want no colony, got %+v", c)
+	}
+	var failed []int
+	for _, e := range turn.Events {
+		if e.Kind == engine.ColonizeFailed {
+			failed = append(failed, e.Nation)
+		}
+	}
+	if !reflect.DeepEqual(failed, []int{1, 2}) {
+		t.Errorf("contested: want failures for nations [1 2], got %v", failed)
+	}
+	// the transfer lands before the nation's own output is added
+	if got := turn.Game.Nation(1).Stockpile; got != 7+50 {
+		t.Errorf("transfer: want %d, got %d", 7+50, got)
+	}
+
+	// the same orders in a different order, and in a new map, give the same result
+	again, err := engine.Advance(g, map[int][]engine.Order{2: orders[2], 1: orders[1]})
+	if err != nil {
+		t.Fatalf("advance: want nil, got %v", err)
+	} else if !reflect.DeepEqual(again, turn) {
+		t.Errorf("advance: results differ")
+	}
+
+	// orders for a nation that isn't in the game are an error
+	if _, err := engine.Advance(g, map[int][]engine.Order{9: nil}); err == nil {
+		t.Errorf("unknown nation: want error, got nil")
+	}
+}
@@ -0,0 +1,229 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package engine implements the rules of the game.
+//
+// The engine only works on values: it loads a game's state from a reader,
+// advances it, and saves it to a writer. It never touches the network,
+// the database, the clock, or the file system, and it never modifies the
+// state that it is given, so every rule can be tested without a server.
+package engine
+
+import "math"
+
+// Game is the complete state of a game at the start of a turn.
+type Game struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Turn     int       `json:"turn"` // zero before the first turn has been run
+	Galaxy   Galaxy    `json:"galaxy"`
+	Nations  []*Nation `json:"nations"`
+	Colonies []*Colony `json:"colonies"`
+	Fleets   []*Fleet  `json:"fleets"`
+	// NextId is the next id for a system, planet, colony, fleet, or ship.
+	// Those ids are unique across the whole game.
+	NextId int `json:"next_id"`
+}
+
+// Turn is the result of advancing a game by one turn.
+type Turn struct {
+	Number int     // the turn that was run
+	Game   *Game   // the state at the end of the turn
+	Events []Event // what happened, in the order it happened
+}
+
+// Coord is a location in the galaxy.
+type Coord struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+}
+
+// Distance returns the straight-line distance between two locations.
+func (c Coord) Distance(to Coord) float64 {
+	dx, dy, dz := float64(to.X-c.X), float64(to.Y-c.Y), float64(to.Z-c.Z)
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// Galaxy is the map that the game is played on.
+type Galaxy struct {
+	Seed    uint64        `json:"seed"`   // the seed the galaxy was generated from
+	Radius  int           `json:"radius"` // every system is within this distance of the center
+	Systems []*StarSystem `json:"systems"`
+}
+
+// StarSystem is a star and the planets orbiting it.
+type StarSystem struct {
+	Id      int       `json:"id"`
+	Name    string    `json:"name"`
+	Coord   Coord     `json:"coord"`
+	Planets []*Planet `json:"planets"`
+}
+
+// PlanetKind is the kind of a planet.
+type PlanetKind string
+
+// Kinds of planets.
+const (
+	AsteroidBelt PlanetKind = "asteroid-belt"
+	GasGiant     PlanetKind = "gas-giant"
+	Terrestrial  PlanetKind = "terrestrial"
+)
+
+// Planet is a body orbiting a star.
+type Planet struct {
+	Id           int        `json:"id"`
+	Orbit        int        `json:"orbit"` // 1 is closest to the star
+	Kind         PlanetKind `json:"kind"`
+	Habitability int        `json:"habitability"` // 0 (uninhabitable) to MaxHabitability
+	Resources    int        `json:"resources"`    // 0 (barren) to MaxResources
+}
+
+// Limits on a planet's attributes.
+const (
+	MaxHabitability = 25
+	MaxResources    = 25
+)
+
+// Capacity returns the largest population the planet can support.
+func (p *Planet) Capacity() int {
+	return p.Habitability * 1_000
+}
+
+// Nation is a player's empire.
+// Its id is the nation number that the game's members are bound to.
+type Nation struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Home      int    `json:"home"`      // id of the nation's home planet
+	Stockpile int    `json:"stockpile"` // production that hasn't been spent
+	Research  int    `json:"research"`  // research points earned so far
+	// Allies are the nations that this nation shares what it sees with.
+	Allies []int `json:"allies,omitempty"`
+	// Sightings are what the nation saw of each system when it last saw it,
+	// in the order of the systems' ids. They are updated at the end of every turn.
+	Sightings []*Sighting `json:"sightings,omitempty"`
+}
+
+// Colony is a nation's settlement on a planet.
+type Colony struct {
+	Id         int `json:"id"`
+	Nation     int `json:"nation"`
+	Planet     int `json:"planet"`
+	Population int `json:"population"`
+	Industry   int `json:"industry"` // factories; each needs ten units of population to run
+}
+
+// Fleet is a group of ships that move together.
+type Fleet struct {
+	Id          int     `json:"id"`
+	Nation      int     `json:"nation"`
+	System      int     `json:"system"`                // id of the system the fleet is in
+	Destination int     `json:"destination,omitempty"` // id of the system the fleet is moving to; zero if it isn't moving
+	Ships       []*Ship `json:"ships"`
+}
+
+// ShipClass is the design of a ship.
+type ShipClass string
+
+// Classes of ships.
+const (
+	ColonyShip ShipClass = "colony"
+	Scout      ShipClass = "scout"
+	Transport  ShipClass = "transport"
+	Warship    ShipClass = "warship"
+)
+
+// Hull returns the hull points of a new ship of the class.
+func (c ShipClass) Hull() int {
+	switch c {
+	case ColonyShip:
+		return 10
+	case Scout:
+		return 5
+	case Transport:
+		return 15
+	case Warship:
+		return 30
+	}
+	return 0
+}
+
+// ScanRange returns how far past its own system a ship of the class can see.
+// It is zero for ships that only see the system they're in.
+func (c ShipClass) ScanRange() int {
+	switch c {
+	case Scout:
+		return 4
+	}
+	return 0
+}
+
+// Ship is a single vessel.
+type Ship struct {
+	Id    int       `json:"id"`
+	Class ShipClass `json:"class"`
+	Hull  int       `json:"hull"` // remaining hull points; the ship is destroyed at zero
+}
+
+// System returns the star system with the given id, or nil if there isn't one.
+func (g *Game) System(id int) *StarSystem {
+	for _, s := range g.Galaxy.Systems {
+		if s.Id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// Planet returns the planet with the given id and the system it orbits,
+// or nils if there isn't one.
+func (g *Game) Planet(id int) (*Planet, *StarSystem) {
+	for _, s := range g.Galaxy.Systems {
+		for _, p := range s.Planets {
+			if p.Id == id {
+				return p, s
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Nation returns the nation with the given id, or nil if there isn't one.
+func (g *Game) Nation(id int) *Nation {
+	for _, n := range g.Nations {
+		if n.Id == id {
+			return n
+		}
+	}
+	return nil
+}
+
+// Colony returns the colony with the given id, or nil if there isn't one.
+func (g *Game) Colony(id int) *Colony {
+	for _, c := range g.Colonies {
+		if c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// ColonyOn returns the colony on the planet, or nil if the planet isn't settled.
+func (g *Game) ColonyOn(planet int) *Colony {
+	for _, c := range g.Colonies {
+		if c.Planet == planet {
+			return c
+		}
+	}
+	return nil
+}
+
+// Fleet returns the fleet with the given id, or nil if there isn't one.
+func (g *Game) Fleet(id int) *Fleet {
+	for _, f := range g.Fleets {
+		if f.Id == id {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mdhender/wraithi/internal/engine"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testGame returns a small, valid game with two nations.
+func testGame() *engine.Game {
+	return &engine.Game{
+		Id:   "g1",
+		Name: "Test",
+		Galaxy: engine.Galaxy{Radius: 10, Systems: []*engine.StarSystem{
+			{Id: 1, Name: "Alpha", Coord: engine.Coord{X: 1, Y: 2, Z: 3}, Planets: []*engine.Planet{
+				{Id: 2, Orbit: 1, Kind: engine.Terrestrial, Habitability: 25, Resources: 10},
+				{Id: 3, Orbit: 2, Kind: engine.GasGiant},
+			}},
+			{Id: 4, Name: "Beta", Coord: engine.Coord{X: -4, Y: 0, Z: 1}, Planets: []*engine.Planet{
+				{Id: 5, Orbit: 1, Kind: engine.Terrestrial, Habitability: 10, Resources: 20},
+			}},
+		}},
+		Nations: []*engine.Nation{
+			{Id: 1, Name: "Red", Home: 2},
+			{Id: 2, Name: "Blue", Home: 5, Stockpile: 7},
+		},
+		Colonies: []*engine.Colony{
+			{Id: 6, Nation: 1, Planet: 2, Population: 1000, Industry: 50},
+			{Id: 7, Nation: 2, Planet: 5, Population: 9995, Industry: 2000},
+		},
+		Fleets: []*engine.Fleet{
+			{Id: 8, Nation: 1, System: 1, Destination: 4, Ships: []*engine.Ship{{Id: 9, Class: engine.Scout, Hull: 5}}},
+		},
+		NextId: 10,
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	g := testGame()
+	buf := &bytes.Buffer{}
+	if err := g.Save(buf); err != nil {
+		t.Fatalf("save: want nil, got %v", err)
+	}
+	got, err := engine.Load(buf)
+	if err != nil {
+		t.Fatalf("load: want nil, got %v", err)
+	} else if !reflect.DeepEqual(got, g) {
+		t.Errorf("load: want %+v, got %+v", g, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		id     int
+		change func(g *engine.Game)
+		want   error
+	}{
+		{1, func(g *engine.Game) {}, nil},
+		{2, func(g *engine.Game) { g.Turn = -1 }, engine.ErrInvalidTurn},
+		{3, func(g *engine.Game) { g.Galaxy.Systems[1].Planets[0].Id = 3 }, engine.ErrDuplicateId},
+		{4, func(g *engine.Game) { g.NextId = 9 }, engine.ErrInvalidId},
+		{5, func(g *engine.Game) { g.Galaxy.Radius = 3 }, engine.ErrOutOfBounds},
+		{6, func(g *engine.Game) { g.Galaxy.Systems[0].Planets[0].Habitability = 26 }, engine.ErrInvalidValue},
+		{7, func(g *engine.Game) { g.Galaxy.Systems[0].Planets[1].Kind = "comet" }, engine.ErrInvalidValue},
+		{8, func(g *engine.Game) { g.Nations[1].Id = 1 }, engine.ErrDuplicateId},
+		{9, func(g *engine.Game) { g.Nations[0].Home = 4 }, engine.ErrUnknownPlanet},
+		{10, func(g *engine.Game) { g.Colonies[0].Nation = 3 }, engine.ErrUnknownNation},
+		{11, func(g *engine.Game) { g.Colonies[1].Planet = 2 }, engine.ErrPlanetSettled},
+		{12, func(g *engine.Game) { g.Colonies[0].Population = -1 }, engine.ErrInvalidValue},
+		{13, func(g *engine.Game) { g.Fleets[0].Destination = 2 }, engine.ErrUnknownSystem},
+		{14, func(g *engine.Game) { g.Fleets[0].Ships[0].Hull = 0 }, engine.ErrInvalidValue},
+		{15, func(g *engine.Game) { g.Nations[0].Allies = []int{1} }, engine.ErrUnknownNation},
+		{16, func(g *engine.Game) { g.Nations[0].Sightings = []*engine.Sighting{{System: 2}} }, engine.ErrUnknownSystem},
+		{17, func(g *engine.Game) { g.Nations[0].Sightings = []*engine.Sighting{{System: 4, Turn: 1}} }, engine.ErrInvalidTurn},
+		{18, func(g *engine.Game) { g.Galaxy.Systems[1] = nil }, engine.ErrInvalidValue},
+		{19, func(g *engine.Game) { g.Galaxy.Systems[0].Planets[1] = nil }, engine.ErrInvalidValue},
+		{20, func(g *engine.Game) { g.Nations[0] = nil }, engine.ErrInvalidValue},
+		{21, func(g *engine.Game) { g.Nations[1] = nil }, engine.ErrInvalidValue},
+		{22, func(g *engine.Game) { g.Colonies[0] = nil }, engine.ErrInvalidValue},
+		{23, func(g *engine.Game) { g.Fleets[0] = nil }, engine.ErrInvalidValue},
+		{24, func(g *engine.Game) { g.Fleets[0].Ships[0] = nil }, engine.ErrInvalidValue},
+		{25, func(g *engine.Game) { g.Nations[0].Sightings = []*engine.Sighting{nil} }, engine.ErrInvalidValue},
+		{26, func(g *engine.Game) {
+			g.Nations[0].Sightings = []*engine.Sighting{{System: 4, Fleets: []*engine.Fleet{{Id: 8, Ships: []*engine.Ship{nil}}}}}
+		}, engine.ErrInvalidValue},
+	} {
+		g := testGame()
+		tc.change(g)
+		if err := g.Validate(); !errors.Is(err, tc.want) {
+			t.Errorf("%d: want %v, got %v", tc.id, tc.want, err)
+		}
+	}
+}
+
+func TestLoadNil(t *testing.T) {
+	for _, tc := range []struct {
+		id    int
+		state string
+	}{
+		{1, `{"galaxy":{"systems":[null]}}`},
+		{2, `{"galaxy":{"systems":[{"id":1,"planets":[null]}]},"next_id":2}`},
+		{3, `{"nations":[null]}`},
+		{4, `{"colonies":[null]}`},
+		{5, `{"fleets":[null]}`},
+	} {
+		if _, err := engine.Load(strings.NewReader(tc.state)); !errors.Is(err, engine.ErrInvalidValue) {
+			t.Errorf("%d: want %v, got %v", tc.id, engine.ErrInvalidValue, err)
+		}
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	g := testGame()
+	before := &bytes.Buffer{}
+	if err := g.Save(before); err != nil {
+		t.Fatalf("save: want nil, got %v", err)
+	}
+
+	turn, err := engine.Advance(g, nil)
+	if err != nil {
+		t.Fatalf("advance: want nil, got %v", err)
+	}
+
+	// the input must not change
+	after := &bytes.Buffer{}
+	if err := g.Save(after); err != nil {
+		t.Fatalf("save: want nil, got %v", err)
+	} else if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Errorf("advance: input was modified")
+	}
+
+	next := turn.Game
+	if turn.Number != 1 || next.Turn != 1 {
+		t.Errorf("turn: want 1, got %d and %d", turn.Number, next.Turn)
+	}
+	if f := next.Fleet(8); f.System != 4 || f.Destination != 0 {
+		t.Errorf("fleet: want system 4 and no destination, got %d and %d", f.System, f.Destination)
+	}
+	for _, tc := range []struct {
+		id         int
+		colony     int
+		population int
+	}{
+		{1, 6, 1100},  // grows by a tenth on a perfect planet
+		{2, 7, 10000}, // stops at the planet's capacity
+	} {
+		if got := next.Colony(tc.colony).Population; got != tc.population {
+			t.Errorf("%d: population: want %d, got %d", tc.id, tc.population, got)
+		}
+	}
+	for _, tc := range []struct {
+		id        int
+		nation    int
+		stockpile int
+	}{
+		{1, 1, 50},       // limited by industry
+		{2, 2, 7 + 1000}, // limited by population
+	} {
+		if got := next.Nation(tc.nation).Stockpile; got != tc.stockpile {
+			t.Errorf("%d: stockpile: want %d, got %d", tc.id, tc.stockpile, got)
+		}
+	}
+
+	// the same state must always produce the same result
+	again, err := engine.Advance(g, nil)
+	if err != nil {
+		t.Fatalf("advance: want nil, got %v", err)
+	} else if !reflect.DeepEqual(again, turn) {
+		t.Errorf("advance: results differ")
+	}
+}
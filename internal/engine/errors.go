@@ -0,0 +1,25 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+// Errors used by the package.
+const (
+	ErrDuplicateId    = constError("duplicate id")
+	ErrGalaxyTooSmall = constError("galaxy is too small for the systems")
+	ErrInvalidId      = constError("invalid id")
+	ErrInvalidOption  = constError("invalid option")
+	ErrInvalidTurn    = constError("invalid turn")
+	ErrInvalidValue   = constError("invalid value")
+	ErrOutOfBounds    = constError("outside the galaxy")
+	ErrPlanetSettled  = constError("planet already has a colony")
+	ErrUnknownNation  = constError("unknown nation")
+	ErrUnknownPlanet  = constError("unknown planet")
+	ErrUnknownSystem  = constError("unknown system")
+)
+
+// declarations to support constant errors
+type constError string
+
+func (ce constError) Error() string {
+	return string(ce)
+}
@@ -0,0 +1,108 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Phase is a step in running a turn.
+// The phases always run in the order they're listed.
+type Phase string
+
+// Phases of a turn.
+const (
+	PhaseIntake       Phase = "intake"       // orders are checked, fleets are given destinations, and ships are scrapped
+	PhaseMovement     Phase = "movement"     // fleets arrive at their destinations
+	PhaseCombat       Phase = "combat"       // warships fire on the other nations' ships in their system
+	PhaseColonization Phase = "colonization" // colony ships settle planets
+	PhaseProduction   Phase = "production"   // production is spent, populations grow, and colonies produce
+	PhaseResearch     Phase = "research"     // production is spent on research
+	PhaseReporting    Phase = "reporting"    // events are shared with the nations that saw them
+)
+
+// EventKind is what happened.
+type EventKind string
+
+// Kinds of events.
+const (
+	Battle         EventKind = "battle"          // the nation's ships fought in the system
+	Built          EventKind = "built"           // the colony built ships or industry
+	ColonizeFailed EventKind = "colonize-failed" // a colony ship couldn't settle the planet
+	ColonyFounded  EventKind = "colony-founded"  // a colony ship settled the planet
+	FleetMoved     EventKind = "fleet-moved"     // the fleet arrived in the system
+	OrderRejected  EventKind = "order-rejected"  // the order won't be carried out
+	Produced       EventKind = "produced"        // the colony added to the nation's stockpile
+	Researched     EventKind = "researched"      // the nation spent production on research
+	ShipDestroyed  EventKind = "ship-destroyed"  // the ship was lost in battle
+	ShipScrapped   EventKind = "ship-scrapped"   // the ship was broken up
+	Transferred    EventKind = "transferred"     // the nation gave production to another
+)
+
+// Event is something that happened while a turn was run.
+// Only the fields that the kind of event uses are set.
+type Event struct {
+	Phase     Phase     `json:"phase"`
+	Kind      EventKind `json:"kind"`
+	Nation    int       `json:"nation"`              // the nation the event happened to
+	Observers []int     `json:"observers,omitempty"` // other nations that saw it
+	Other     int       `json:"other,omitempty"`     // the nation receiving a transfer
+	System    int       `json:"system,omitempty"`
+	From      int       `json:"from,omitempty"` // the system a fleet left
+	Planet    int       `json:"planet,omitempty"`
+	Colony    int       `json:"colony,omitempty"`
+	Fleet     int       `json:"fleet,omitempty"`
+	Ship      int       `json:"ship,omitempty"`
+	Product   string    `json:"product,omitempty"` // a ship class or Industry
+	Amount    int       `json:"amount,omitempty"`
+	Order     string    `json:"order,omitempty"` // the order that was rejected
+	Line      int       `json:"line,omitempty"`  // the line the rejected order was on
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// SeenBy returns true if the nation knows about the event.
+func (e Event) SeenBy(nation int) bool {
+	if e.Nation == nation {
+		return true
+	}
+	for _, n := range e.Observers {
+		if n == nation {
+			return true
+		}
+	}
+	return false
+}
+
+// EventsFor returns the events that the nation knows about, in the order they happened.
+func EventsFor(events []Event, nation int) []Event {
+	var list []Event
+	for _, e := range events {
+		if e.SeenBy(nation) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// LoadEvents reads the events of a turn.
+func LoadEvents(r io.Reader) ([]Event, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var events []Event
+	if err := dec.Decode(&events); err != nil {
+		return nil, fmt.Errorf("load events: %w", err)
+	}
+	return events, nil
+}
+
+// SaveEvents writes the events of a turn in the format that LoadEvents reads.
+func SaveEvents(w io.Writer, events []Event) error {
+	if events == nil {
+		events = []Event{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(events)
+}
@@ -0,0 +1,350 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// GalaxyOptions are the parameters for a new galaxy.
+type GalaxyOptions struct {
+	Players          int
+	SystemsPerPlayer int
+	Radius           int                // zero picks a radius that suits the number of systems
+	PlanetKinds      map[PlanetKind]int // relative weight of each kind of planet
+	Richness         int                // percent to scale resources by; 100 is normal
+	Neighborhood     int                // number of systems nearest a home that the fairness pass balances
+}
+
+// NewGalaxyOptions returns the options for a four player galaxy.
+func NewGalaxyOptions() GalaxyOptions {
+	return GalaxyOptions{
+		Players:          4,
+		SystemsPerPlayer: 10,
+		PlanetKinds:      map[PlanetKind]int{AsteroidBelt: 2, GasGiant: 3, Terrestrial: 5},
+		Richness:         100,
+		Neighborhood:     5,
+	}
+}
+
+// Limits on the galaxy options.
+const (
+	MaxPlayers          = 100
+	MaxSystemsPerPlayer = 100
+	MaxRichness         = 500
+)
+
+// Starting state for each nation.
+const (
+	homeHabitability = MaxHabitability
+	homeResources    = 15
+	homePopulation   = 5_000
+	homeIndustry     = 250
+)
+
+// planetKinds is the order that kinds are drawn in, since maps aren't ordered.
+var planetKinds = []PlanetKind{AsteroidBelt, GasGiant, Terrestrial}
+
+// Generate creates a game at turn zero with a new galaxy and a nation,
+// home colony, and starting fleet for each player.
+// The same seed and options always produce the same game.
+func Generate(seed uint64, opts GalaxyOptions) (*Game, error) {
+	if opts.Players < 1 || opts.Players > MaxPlayers {
+		return nil, fmt.Errorf("players: %w", ErrInvalidOption)
+	} else if opts.SystemsPerPlayer < 1 || opts.SystemsPerPlayer > MaxSystemsPerPlayer {
+		return nil, fmt.Errorf("systems per player: %w", ErrInvalidOption)
+	} else if opts.Richness < 1 || opts.Richness > MaxRichness {
+		return nil, fmt.Errorf("richness: %w", ErrInvalidOption)
+	} else if opts.Neighborhood < 1 || opts.Neighborhood > opts.SystemsPerPlayer {
+		return nil, fmt.Errorf("neighborhood: %w", ErrInvalidOption)
+	}
+	totalWeight := 0
+	for kind, weight := range opts.PlanetKinds {
+		if weight < 0 || (kind != AsteroidBelt && kind != GasGiant && kind != Terrestrial) {
+			return nil, fmt.Errorf("planet kinds: %w", ErrInvalidOption)
+		}
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("planet kinds: %w", ErrInvalidOption)
+	}
+
+	// systems are kept at least two units apart, so each one needs about eight cubic units.
+	// the default radius gives them about 64 so that there is room to maneuver.
+	count := opts.Players * opts.SystemsPerPlayer
+	radius := opts.Radius
+	if radius == 0 {
+		radius = int(math.Ceil(math.Cbrt(3 * float64(count) * 64 / (4 * math.Pi))))
+	} else if radius < 0 || 4*math.Pi*math.Pow(float64(radius), 3)/3 < float64(count)*16 {
+		return nil, fmt.Errorf("radius: %w", ErrGalaxyTooSmall)
+	}
+
+	r := newPRNG(seed)
+	g := &Game{Galaxy: Galaxy{Seed: seed, Radius: radius}, NextId: 1}
+	newId := func() int {
+		id := g.NextId
+		g.NextId++
+		return id
+	}
+
+	occupied := map[Coord]bool{}
+	names := map[string]bool{}
+	for attempts := 0; len(g.Galaxy.Systems) < count; attempts++ {
+		if attempts > count*1_000 {
+			return nil, fmt.Errorf("radius: %w", ErrGalaxyTooSmall)
+		}
+		c := Coord{X: r.between(-radius, radius), Y: r.between(-radius, radius), Z: r.between(-radius, radius)}
+		if c.Distance(Coord{}) > float64(radius) || crowded(occupied, c) {
+			continue
+		}
+		occupied[c] = true
+		s := &StarSystem{Id: newId(), Name: systemName(r, names), Coord: c}
+		for orbit, n := 1, r.between(1, 6); orbit <= n; orbit++ {
+			s.Planets = append(s.Planets, newPlanet(r, newId(), orbit, opts))
+		}
+		g.Galaxy.Systems = append(g.Galaxy.Systems, s)
+	}
+
+	for i, home := range pickHomes(r, g.Galaxy.Systems, opts.Players) {
+		// the home planet is always in the third orbit, or the outermost if there are fewer
+		p := home.Planets[min(3, len(home.Planets))-1]
+		p.Kind, p.Habitability, p.Resources = Terrestrial, homeHabitability, homeResources
+		n := &Nation{Id: i + 1, Name: fmt.Sprintf("Nation %d", i+1), Home: p.Id}
+		g.Nations = append(g.Nations, n)
+		g.Colonies = append(g.Colonies, &Colony{Id: newId(), Nation: n.Id, Planet: p.Id, Population: homePopulation, Industry: homeIndustry})
+		f := &Fleet{Id: newId(), Nation: n.Id, System: home.Id}
+		for _, class := range []ShipClass{Scout, ColonyShip} {
+			f.Ships = append(f.Ships, &Ship{Id: newId(), Class: class, Hull: class.Hull()})
+		}
+		g.Fleets = append(g.Fleets, f)
+	}
+
+	balance(g, opts.Neighborhood)
+	g.remember()
+	return g, g.Validate()
+}
+
+// crowded returns true if there is a system next to the location.
+func crowded(occupied map[Coord]bool, c Coord) bool {
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dz := -1; dz <= 1; dz++ {
+				if occupied[Coord{X: c.X + dx, Y: c.Y + dy, Z: c.Z + dz}] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// newPlanet returns a random planet for the orbit.
+// Terrestrial planets are most habitable in the third orbit.
+func newPlanet(r *prng, id, orbit int, opts GalaxyOptions) *Planet {
+	total := 0
+	for _, kind := range planetKinds {
+		total += opts.PlanetKinds[kind]
+	}
+	p := &Planet{Id: id, Orbit: orbit}
+	n := r.intn(total)
+	for _, kind := range planetKinds {
+		if n < opts.PlanetKinds[kind] {
+			p.Kind = kind
+			break
+		}
+		n -= opts.PlanetKinds[kind]
+	}
+	switch p.Kind {
+	case AsteroidBelt:
+		p.Resources = r.between(5, 25)
+	case GasGiant:
+		p.Resources = r.between(0, 10)
+	case Terrestrial:
+		distance := orbit - 3
+		if distance < 0 {
+			distance = -distance
+		}
+		p.Habitability = max(0, min(MaxHabitability, MaxHabitability-6*distance+r.between(-3, 3)))
+		p.Resources = r.between(0, 15)
+	}
+	p.Resources = min(MaxResources, p.Resources*opts.Richness/100)
+	return p
+}
+
+// syllables are used to make up names for systems.
+var syllables = []string{
+	"al", "an", "ar", "be", "cor", "da", "el", "en", "fa", "gal", "ha", "ir",
+	"ka", "lo", "ma", "nor", "or", "pe", "qua", "ra", "sol", "ta", "ul", "ve", "xi", "zan",
+}
+
+// systemName returns a name that hasn't been used yet.
+func systemName(r *prng, used map[string]bool) string {
+	var name string
+	for attempt := 0; attempt == 0 || used[name]; attempt++ {
+		name = ""
+		for n := r.between(2, 3); n > 0; n-- {
+			name += syllables[r.intn(len(syllables))]
+		}
+		if attempt >= 10 {
+			name = fmt.Sprintf("%s-%d", name, attempt)
+		}
+		name = string(name[0]-'a'+'A') + name[1:]
+	}
+	used[name] = true
+	return name
+}
+
+// pickHomes spreads the home systems out. The first is random and
+// each of the others is the system farthest from the homes already picked.
+// Homes need a planet in the third orbit, so systems without one are only
+// used when there aren't enough with one.
+func pickHomes(r *prng, systems []*StarSystem, players int) []*StarSystem {
+	var candidates []*StarSystem
+	for _, s := range systems {
+		if len(s.Planets) >= 3 {
+			candidates = append(candidates, s)
+		}
+	}
+	if len(candidates) < players {
+		candidates = systems
+	}
+	homes := []*StarSystem{candidates[r.intn(len(candidates))]}
+	for len(homes) < players {
+		var best *StarSystem
+		bestDistance := -1.0
+		for _, s := range candidates {
+			nearest := math.MaxFloat64
+			for _, h := range homes {
+				nearest = math.Min(nearest, s.Coord.Distance(h.Coord))
+			}
+			if nearest > bestDistance {
+				best, bestDistance = s, nearest
+			}
+		}
+		homes = append(homes, best)
+	}
+	return homes
+}
+
+// Neighborhoods returns each nation's neighborhood, which is the given number of
+// systems nearest its home that are closer to its home than to any other.
+// The systems are ordered by distance from the home, which is first.
+func (g *Game) Neighborhoods(size int) map[int][]*StarSystem {
+	homes := map[int]*StarSystem{}
+	for _, n := range g.Nations {
+		_, homes[n.Id] = g.Planet(n.Home)
+	}
+	cells := map[int][]*StarSystem{}
+	for _, s := range g.Galaxy.Systems {
+		owner, nearest := 0, math.MaxFloat64
+		for _, n := range g.Nations {
+			if d := s.Coord.Distance(homes[n.Id].Coord); d < nearest {
+				owner, nearest = n.Id, d
+			}
+		}
+		if owner != 0 {
+			cells[owner] = append(cells[owner], s)
+		}
+	}
+	for id, cell := range cells {
+		home := homes[id].Coord
+		sort.SliceStable(cell, func(i, j int) bool {
+			di, dj := cell[i].Coord.Distance(home), cell[j].Coord.Distance(home)
+			if di != dj {
+				return di < dj
+			}
+			return cell[i].Id < cell[j].Id
+		})
+		cells[id] = cell[:min(size, len(cell))]
+	}
+	return cells
+}
+
+// NeighborhoodScores returns the sum of the habitability and resources
+// of the planets in each nation's neighborhood, not counting home planets.
+func (g *Game) NeighborhoodScores(size int) map[int]int {
+	scores := map[int]int{}
+	for id, systems := range g.Neighborhoods(size) {
+		for _, p := range g.frontier(systems) {
+			scores[id] += p.Habitability + p.Resources
+		}
+	}
+	return scores
+}
+
+// frontier returns the planets in the systems that aren't anybody's home.
+func (g *Game) frontier(systems []*StarSystem) []*Planet {
+	homes := map[int]bool{}
+	for _, n := range g.Nations {
+		homes[n.Home] = true
+	}
+	var planets []*Planet
+	for _, s := range systems {
+		for _, p := range s.Planets {
+			if !homes[p.Id] {
+				planets = append(planets, p)
+			}
+		}
+	}
+	return planets
+}
+
+// balance is the fairness pass. It brings every nation's neighborhood score to
+// the best score that every neighborhood can reach. Poor neighborhoods gain
+// resources, and then habitability, starting with the planets closest to home.
+// Rich ones lose them starting with the planets farthest away.
+// Neighborhoods don't overlap, so changing one never changes another.
+func balance(g *Game, size int) {
+	neighborhoods, scores := g.Neighborhoods(size), g.NeighborhoodScores(size)
+	target := 0
+	for _, score := range scores {
+		target = max(target, score)
+	}
+	for id := range neighborhoods {
+		reachable := 0
+		for _, p := range g.frontier(neighborhoods[id]) {
+			reachable += MaxResources
+			if p.Kind == Terrestrial {
+				reachable += MaxHabitability
+			}
+		}
+		target = min(target, reachable)
+	}
+
+	for _, n := range g.Nations {
+		planets, score := g.frontier(neighborhoods[n.Id]), scores[n.Id]
+		for changed := true; score < target && changed; {
+			changed = false
+			for _, p := range planets {
+				if score == target {
+					break
+				} else if p.Resources < MaxResources {
+					p.Resources++
+				} else if p.Kind == Terrestrial && p.Habitability < MaxHabitability {
+					p.Habitability++
+				} else {
+					continue
+				}
+				score, changed = score+1, true
+			}
+		}
+		for changed := true; score > target && changed; {
+			changed = false
+			for i := len(planets) - 1; i >= 0; i-- {
+				p := planets[i]
+				if score == target {
+					break
+				} else if p.Resources > 0 {
+					p.Resources--
+				} else if p.Habitability > 0 {
+					p.Habitability--
+				} else {
+					continue
+				}
+				score, changed = score-1, true
+			}
+		}
+	}
+}
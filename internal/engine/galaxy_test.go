@@ -0,0 +1,84 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mdhender/wraithi/internal/engine"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	opts := engine.NewGalaxyOptions()
+	save := func(seed uint64) []byte {
+		g, err := engine.Generate(seed, opts)
+		if err != nil {
+			t.Fatalf("generate %d: want nil, got %v", seed, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := g.Save(buf); err != nil {
+			t.Fatalf("save %d: want nil, got %v", seed, err)
+		}
+		return buf.Bytes()
+	}
+	if a, b := save(42), save(42); !bytes.Equal(a, b) {
+		t.Errorf("generate: same seed produced different galaxies")
+	}
+	if a, b := save(42), save(43); bytes.Equal(a, b) {
+		t.Errorf("generate: different seeds produced the same galaxy")
+	}
+
+	for _, seed := range []uint64{0, 1, 42, 1 << 63} {
+		g, err := engine.Generate(seed, opts)
+		if err != nil {
+			t.Fatalf("%d: generate: want nil, got %v", seed, err)
+		}
+		if got := len(g.Galaxy.Systems); got != opts.Players*opts.SystemsPerPlayer {
+			t.Errorf("%d: systems: want %d, got %d", seed, opts.Players*opts.SystemsPerPlayer, got)
+		}
+		if got := len(g.Nations); got != opts.Players {
+			t.Errorf("%d: nations: want %d, got %d", seed, opts.Players, got)
+		}
+		for _, n := range g.Nations {
+			if p, _ := g.Planet(n.Home); p.Kind != engine.Terrestrial || p.Habitability != engine.MaxHabitability {
+				t.Errorf("%d: nation %d: home: want habitable terrestrial, got %+v", seed, n.Id, *p)
+			} else if c := g.ColonyOn(n.Home); c == nil || c.Nation != n.Id {
+				t.Errorf("%d: nation %d: home: want colony, got %+v", seed, n.Id, c)
+			}
+		}
+		// the fairness pass gives every neighborhood the same score
+		scores := g.NeighborhoodScores(opts.Neighborhood)
+		for _, n := range g.Nations {
+			if scores[n.Id] != scores[1] {
+				t.Errorf("%d: scores: want equal, got %v", seed, scores)
+				break
+			}
+		}
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	for _, tc := range []struct {
+		id     int
+		change func(o *engine.GalaxyOptions)
+		want   error
+	}{
+		{1, func(o *engine.GalaxyOptions) {}, nil},
+		{2, func(o *engine.GalaxyOptions) { o.Players = 0 }, engine.ErrInvalidOption},
+		{3, func(o *engine.GalaxyOptions) { o.SystemsPerPlayer = engine.MaxSystemsPerPlayer + 1 }, engine.ErrInvalidOption},
+		{4, func(o *engine.GalaxyOptions) { o.Richness = 0 }, engine.ErrInvalidOption},
+		{5, func(o *engine.GalaxyOptions) { o.Neighborhood = o.SystemsPerPlayer + 1 }, engine.ErrInvalidOption},
+		{6, func(o *engine.GalaxyOptions) { o.PlanetKinds = map[engine.PlanetKind]int{"comet": 1} }, engine.ErrInvalidOption},
+		{7, func(o *engine.GalaxyOptions) { o.PlanetKinds = nil }, engine.ErrInvalidOption},
+		{8, func(o *engine.GalaxyOptions) { o.Radius = 3 }, engine.ErrGalaxyTooSmall},
+		{9, func(o *engine.GalaxyOptions) { o.Radius = 30 }, nil},
+		{10, func(o *engine.GalaxyOptions) { o.PlanetKinds = map[engine.PlanetKind]int{engine.GasGiant: 1} }, nil},
+	} {
+		opts := engine.NewGalaxyOptions()
+		tc.change(&opts)
+		if _, err := engine.Generate(7, opts); !errors.Is(err, tc.want) {
+			t.Errorf("%d: want %v, got %v", tc.id, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+import "fmt"
+
+// OrderKind is what an order tells the nation to do.
+type OrderKind string
+
+// Kinds of orders.
+const (
+	Build    OrderKind = "build"    // build ships or industry at a colony
+	Colonize OrderKind = "colonize" // use a fleet's colony ship to settle a planet
+	Hold     OrderKind = "hold"     // stop a fleet that is moving
+	Move     OrderKind = "move"     // send a fleet to a system
+	Research OrderKind = "research" // spend production on research
+	Scrap    OrderKind = "scrap"    // break up a ship
+	Transfer OrderKind = "transfer" // give production to another nation
+)
+
+// Industry is the product for orders that build factories.
+const Industry = "industry"
+
+// Order is a single instruction from a nation.
+// Only the fields that the kind of order uses are set.
+type Order struct {
+	Kind    OrderKind `json:"kind"`
+	Fleet   int       `json:"fleet,omitempty"`
+	Colony  int       `json:"colony,omitempty"`
+	Ship    int       `json:"ship,omitempty"`
+	System  int       `json:"system,omitempty"`
+	Planet  int       `json:"planet,omitempty"`
+	Nation  int       `json:"nation,omitempty"`  // the nation receiving a transfer
+	Product string    `json:"product,omitempty"` // a ship class or Industry
+	Amount  int       `json:"amount,omitempty"`  // things to build or production to spend
+	// Line and Col are where the order starts in the text it was read from.
+	// They are zero for orders that weren't read from text.
+	Line int `json:"line,omitempty"`
+	Col  int `json:"col,omitempty"`
+}
+
+// String returns the order in the orders language.
+func (o Order) String() string {
+	switch o.Kind {
+	case Build:
+		return fmt.Sprintf("build %d %s at colony %d", o.Amount, o.Product, o.Colony)
+	case Colonize:
+		return fmt.Sprintf("colonize planet %d with fleet %d", o.Planet, o.Fleet)
+	case Hold:
+		return fmt.Sprintf("hold fleet %d", o.Fleet)
+	case Move:
+		return fmt.Sprintf("move fleet %d to system %d", o.Fleet, o.System)
+	case Research:
+		return fmt.Sprintf("research %d", o.Amount)
+	case Scrap:
+		return fmt.Sprintf("scrap ship %d", o.Ship)
+	case Transfer:
+		return fmt.Sprintf("transfer %d to nation %d", o.Amount, o.Nation)
+	}
+	return string(o.Kind)
+}
+
+// Cost returns the production needed to build one of the product.
+// It is zero for things that can't be built.
+func Cost(product string) int {
+	switch product {
+	case Industry:
+		return 5
+	case string(ColonyShip):
+		return 50
+	case string(Scout):
+		return 10
+	case string(Transport):
+		return 30
+	case string(Warship):
+		return 60
+	}
+	return 0
+}
+
+// Rejection is an order that can't be carried out and the reason why.
+type Rejection struct {
+	Order  Order  `json:"order"`
+	Reason string `json:"reason"`
+}
+
+// Validation is the result of checking a nation's orders.
+type Validation struct {
+	Accepted []Order     `json:"accepted"`
+	Rejected []Rejection `json:"rejected"`
+}
+
+// ValidateOrders checks a nation's orders against the state of the game.
+// Orders are checked in the order given, and each accepted order counts
+// against the ones after it: production can only be spent once, a fleet
+// can only be given one destination, and a ship can only be scrapped once.
+// The game is not changed.
+func ValidateOrders(g *Game, nation int, orders []Order) Validation {
+	var v Validation
+	n := g.Nation(nation)
+	if n == nil {
+		for _, o := range orders {
+			v.Rejected = append(v.Rejected, Rejection{Order: o, Reason: "you don't have a nation in this game"})
+		}
+		return v
+	}
+
+	budget := n.Stockpile
+	moving := map[int]int{}     // fleet id to destination
+	colonizing := map[int]int{} // fleet id to colony ships used
+	settling := map[int]bool{}  // planet ids
+	scrapped := map[int]bool{}  // ship ids
+	for _, o := range orders {
+		reason := ""
+		switch o.Kind {
+		case Build:
+			if c := g.Colony(o.Colony); c == nil || c.Nation != nation {
+				reason = fmt.Sprintf("you don't have colony %d", o.Colony)
+			} else if Cost(o.Product) == 0 {
+				reason = fmt.Sprintf("%q can't be built", o.Product)
+			} else if o.Amount < 1 {
+				reason = "the amount must be at least 1"
+			} else if cost := o.Amount * Cost(o.Product); cost > budget {
+				reason = fmt.Sprintf("it costs %d, but only %d production is left", cost, budget)
+			} else {
+				budget -= cost
+			}
+		case Colonize:
+			f := g.Fleet(o.Fleet)
+			p, s := g.Planet(o.Planet)
+			if f == nil || f.Nation != nation {
+				reason = fmt.Sprintf("you don't have fleet %d", o.Fleet)
+			} else if p == nil {
+				reason = fmt.Sprintf("there is no planet %d", o.Planet)
+			} else if f.System != s.Id && moving[f.Id] != s.Id {
+				reason = fmt.Sprintf("fleet %d won't be in system %d", o.Fleet, s.Id)
+			} else if countClass(f, ColonyShip) <= colonizing[f.Id] {
+				reason = fmt.Sprintf("fleet %d has no colony ship left", o.Fleet)
+			} else if p.Habitability == 0 {
+				reason = fmt.Sprintf("planet %d is uninhabitable", o.Planet)
+			} else if c := g.ColonyOn(p.Id); (c != nil && c.Nation == nation) || settling[p.Id] {
+				reason = fmt.Sprintf("you already have a colony on planet %d", o.Planet)
+			} else {
+				colonizing[f.Id]++
+				settling[p.Id] = true
+			}
+		case Hold:
+			if f := g.Fleet(o.Fleet); f == nil || f.Nation != nation {
+				reason = fmt.Sprintf("you don't have fleet %d", o.Fleet)
+			} else if _, ok := moving[f.Id]; ok {
+				reason = fmt.Sprintf("fleet %d already has orders to move", o.Fleet)
+			} else {
+				moving[f.Id] = f.System
+			}
+		case Move:
+			if f := g.Fleet(o.Fleet); f == nil || f.Nation != nation {
+				reason = fmt.Sprintf("you don't have fleet %d", o.Fleet)
+			} else if g.System(o.System) == nil {
+				reason = fmt.Sprintf("there is no system %d", o.System)
+			} else if f.System == o.System {
+				reason = fmt.Sprintf("fleet %d is already in system %d", o.Fleet, o.System)
+			} else if _, ok := moving[f.Id]; ok {
+				reason = fmt.Sprintf("fleet %d already has orders to move", o.Fleet)
+			} else {
+				moving[f.Id] = o.System
+			}
+		case Research:
+			if o.Amount < 1 {
+				reason = "the amount must be at least 1"
+			} else if o.Amount > budget {
+				reason = fmt.Sprintf("only %d production is left", budget)
+			} else {
+				budget -= o.Amount
+			}
+		case Scrap:
+			if f := fleetWith(g, o.Ship); f == nil || f.Nation != nation {
+				reason = fmt.Sprintf("you don't have ship %d", o.Ship)
+			} else if scrapped[o.Ship] {
+				reason = fmt.Sprintf("ship %d is already being scrapped", o.Ship)
+			} else {
+				scrapped[o.Ship] = true
+			}
+		case Transfer:
+			if o.Nation == nation {
+				reason = "you can't transfer production to yourself"
+			} else if g.Nation(o.Nation) == nil {
+				reason = fmt.Sprintf("there is no nation %d", o.Nation)
+			} else if o.Amount < 1 {
+				reason = "the amount must be at least 1"
+			} else if o.Amount > budget {
+				reason = fmt.Sprintf("only %d production is left", budget)
+			} else {
+				budget -= o.Amount
+			}
+		default:
+			reason = fmt.Sprintf("unknown order %q", o.Kind)
+		}
+		if reason != "" {
+			v.Rejected = append(v.Rejected, Rejection{Order: o, Reason: reason})
+		} else {
+			v.Accepted = append(v.Accepted, o)
+		}
+	}
+	return v
+}
+
+// countClass returns the number of ships of the class in the fleet.
+func countClass(f *Fleet, class ShipClass) int {
+	n := 0
+	for _, s := range f.Ships {
+		if s.Class == class {
+			n++
+		}
+	}
+	return n
+}
+
+// fleetWith returns the fleet that the ship is in, or nil if there is no such ship.
+func fleetWith(g *Game, ship int) *Fleet {
+	for _, f := range g.Fleets {
+		for _, s := range f.Ships {
+			if s.Id == ship {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// Products returns the things that can be built, in the order they're listed for players.
+func Products() []string {
+	return []string{Industry, string(Scout), string(Transport), string(ColonyShip), string(Warship)}
+}
@@ -0,0 +1,68 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine_test
+
+import (
+	"github.com/mdhender/wraithi/internal/engine"
+	"reflect"
+	"testing"
+)
+
+func TestValidateOrders(t *testing.T) {
+	g := testGame()
+	g.Nations[0].Stockpile = 100
+	g.Fleets[0].Destination = 0
+	g.Fleets[0].Ships = append(g.Fleets[0].Ships, &engine.Ship{Id: 10, Class: engine.ColonyShip, Hull: 10})
+	g.NextId = 11
+	before := g.Clone()
+
+	for _, tc := range []struct {
+		id       int
+		orders   []engine.Order
+		accepted int
+		reasons  []string
+	}{
+		{1, []engine.Order{{Kind: engine.Build, Amount: 10, Product: engine.Industry, Colony: 6}}, 1, nil},
+		{2, []engine.Order{{Kind: engine.Build, Amount: 1, Product: engine.Industry, Colony: 7}}, 0, []string{"you don't have colony 7"}},
+		{3, []engine.Order{{Kind: engine.Build, Amount: 1, Product: "frigate", Colony: 6}}, 0, []string{`"frigate" can't be built`}},
+		{4, []engine.Order{
+			{Kind: engine.Build, Amount: 1, Product: string(engine.ColonyShip), Colony: 6},
+			{Kind: engine.Research, Amount: 40},
+			{Kind: engine.Transfer, Amount: 20, Nation: 2},
+		}, 2, []string{"only 10 production is left"}},
+		{5, []engine.Order{{Kind: engine.Transfer, Amount: 1, Nation: 1}}, 0, []string{"you can't transfer production to yourself"}},
+		{6, []engine.Order{
+			{Kind: engine.Move, Fleet: 8, System: 4},
+			{Kind: engine.Move, Fleet: 8, System: 1},
+			{Kind: engine.Hold, Fleet: 8},
+		}, 1, []string{"fleet 8 is already in system 1", "fleet 8 already has orders to move"}},
+		{7, []engine.Order{{Kind: engine.Move, Fleet: 8, System: 99}}, 0, []string{"there is no system 99"}},
+		{8, []engine.Order{{Kind: engine.Colonize, Planet: 5, Fleet: 8}}, 0, []string{"fleet 8 won't be in system 4"}},
+		{9, []engine.Order{
+			{Kind: engine.Move, Fleet: 8, System: 4},
+			{Kind: engine.Colonize, Planet: 5, Fleet: 8},
+		}, 2, nil},
+		{10, []engine.Order{{Kind: engine.Colonize, Planet: 3, Fleet: 8}}, 0, []string{"planet 3 is uninhabitable"}},
+		{11, []engine.Order{{Kind: engine.Colonize, Planet: 2, Fleet: 8}}, 0, []string{"you already have a colony on planet 2"}},
+		{12, []engine.Order{
+			{Kind: engine.Scrap, Ship: 9},
+			{Kind: engine.Scrap, Ship: 9},
+			{Kind: engine.Scrap, Ship: 99},
+		}, 1, []string{"ship 9 is already being scrapped", "you don't have ship 99"}},
+	} {
+		v := engine.ValidateOrders(g, 1, tc.orders)
+		var reasons []string
+		for _, r := range v.Rejected {
+			reasons = append(reasons, r.Reason)
+		}
+		if len(v.Accepted) != tc.accepted {
+			t.Errorf("%d: accepted: want %d, got %d", tc.id, tc.accepted, len(v.Accepted))
+		}
+		if !reflect.DeepEqual(reasons, tc.reasons) {
+			t.Errorf("%d: rejected: want %q, got %q", tc.id, tc.reasons, reasons)
+		}
+	}
+	if !reflect.DeepEqual(g, before) {
+		t.Errorf("validate: game was modified")
+	}
+}
@@ -0,0 +1,39 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+// prng is a SplitMix64 pseudo-random number generator.
+// We use our own rather than math/rand so that a seed produces
+// the same galaxy with every release of Go and on every platform.
+type prng struct {
+	state uint64
+}
+
+func newPRNG(seed uint64) *prng {
+	return &prng{state: seed}
+}
+
+// next returns the next 64 bits from the sequence.
+func (r *prng) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a number from 0 up to but not including n, which must be positive.
+func (r *prng) intn(n int) int {
+	// rejecting the top of the range removes the bias toward small numbers
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		if v := r.next(); v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// between returns a number from lo to hi, inclusive.
+func (r *prng) between(lo, hi int) int {
+	return lo + r.intn(hi-lo+1)
+}
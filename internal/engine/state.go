@@ -0,0 +1,227 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Load reads a game's state, which must be valid.
+func Load(r io.Reader) (*Game, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var g Game
+	if err := dec.Decode(&g); err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	} else if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	return &g, nil
+}
+
+// Save writes the game's state in the format that Load reads.
+func (g *Game) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// Clone returns a deep copy of the game.
+// Changes to the copy never show up in the original.
+func (g *Game) Clone() *Game {
+	c := *g
+	c.Galaxy.Systems = make([]*StarSystem, 0, len(g.Galaxy.Systems))
+	for _, s := range g.Galaxy.Systems {
+		cs := *s
+		cs.Planets = make([]*Planet, 0, len(s.Planets))
+		for _, p := range s.Planets {
+			cp := *p
+			cs.Planets = append(cs.Planets, &cp)
+		}
+		c.Galaxy.Systems = append(c.Galaxy.Systems, &cs)
+	}
+	c.Nations = make([]*Nation, 0, len(g.Nations))
+	for _, n := range g.Nations {
+		cn := *n
+		cn.Allies = append([]int(nil), n.Allies...)
+		cn.Sightings = nil
+		for _, s := range n.Sightings {
+			cn.Sightings = append(cn.Sightings, s.clone())
+		}
+		c.Nations = append(c.Nations, &cn)
+	}
+	c.Colonies = make([]*Colony, 0, len(g.Colonies))
+	for _, col := range g.Colonies {
+		cc := *col
+		c.Colonies = append(c.Colonies, &cc)
+	}
+	c.Fleets = make([]*Fleet, 0, len(g.Fleets))
+	for _, f := range g.Fleets {
+		c.Fleets = append(c.Fleets, f.clone())
+	}
+	return &c
+}
+
+// clone returns a deep copy of the fleet.
+func (f *Fleet) clone() *Fleet {
+	cf := *f
+	cf.Ships = make([]*Ship, 0, len(f.Ships))
+	for _, s := range f.Ships {
+		cs := *s
+		cf.Ships = append(cf.Ships, &cs)
+	}
+	return &cf
+}
+
+// clone returns a deep copy of the sighting.
+func (s *Sighting) clone() *Sighting {
+	cs := *s
+	cs.Colonies, cs.Fleets = nil, nil
+	for _, c := range s.Colonies {
+		cc := *c
+		cs.Colonies = append(cs.Colonies, &cc)
+	}
+	for _, f := range s.Fleets {
+		cs.Fleets = append(cs.Fleets, f.clone())
+	}
+	return &cs
+}
+
+// Validate checks that the state is consistent:
+// no list has a missing entry, ids are unique and below NextId,
+// every reference is to something that exists, and no quantity is out of range.
+func (g *Game) Validate() error {
+	if g.Turn < 0 {
+		return ErrInvalidTurn
+	}
+	ids := map[int]bool{}
+	useId := func(kind string, id int) error {
+		if id <= 0 || id >= g.NextId {
+			return fmt.Errorf("%s %d: %w", kind, id, ErrInvalidId)
+		} else if ids[id] {
+			return fmt.Errorf("%s %d: %w", kind, id, ErrDuplicateId)
+		}
+		ids[id] = true
+		return nil
+	}
+
+	for i, s := range g.Galaxy.Systems {
+		if s == nil {
+			return fmt.Errorf("system #%d: %w", i+1, ErrInvalidValue)
+		} else if err := useId("system", s.Id); err != nil {
+			return err
+		} else if s.Coord.Distance(Coord{}) > float64(g.Galaxy.Radius) {
+			return fmt.Errorf("system %d: %w", s.Id, ErrOutOfBounds)
+		}
+		for i, p := range s.Planets {
+			if p == nil {
+				return fmt.Errorf("system %d: planet #%d: %w", s.Id, i+1, ErrInvalidValue)
+			} else if err := useId("planet", p.Id); err != nil {
+				return err
+			} else if p.Orbit < 1 || p.Habitability < 0 || p.Habitability > MaxHabitability || p.Resources < 0 || p.Resources > MaxResources {
+				return fmt.Errorf("planet %d: %w", p.Id, ErrInvalidValue)
+			}
+			switch p.Kind {
+			case AsteroidBelt, GasGiant, Terrestrial:
+			default:
+				return fmt.Errorf("planet %d: %w", p.Id, ErrInvalidValue)
+			}
+		}
+	}
+
+	nations := map[int]bool{}
+	for i, n := range g.Nations {
+		if n == nil {
+			return fmt.Errorf("nation #%d: %w", i+1, ErrInvalidValue)
+		} else if n.Id <= 0 {
+			return fmt.Errorf("nation %d: %w", n.Id, ErrInvalidId)
+		} else if nations[n.Id] {
+			return fmt.Errorf("nation %d: %w", n.Id, ErrDuplicateId)
+		} else if p, _ := g.Planet(n.Home); p == nil {
+			return fmt.Errorf("nation %d: home %d: %w", n.Id, n.Home, ErrUnknownPlanet)
+		} else if n.Stockpile < 0 || n.Research < 0 {
+			return fmt.Errorf("nation %d: %w", n.Id, ErrInvalidValue)
+		}
+		nations[n.Id] = true
+	}
+	for _, n := range g.Nations {
+		for _, ally := range n.Allies {
+			if ally == n.Id || !nations[ally] {
+				return fmt.Errorf("nation %d: ally %d: %w", n.Id, ally, ErrUnknownNation)
+			}
+		}
+		for i, s := range n.Sightings {
+			if s == nil {
+				return fmt.Errorf("nation %d: sighting #%d: %w", n.Id, i+1, ErrInvalidValue)
+			} else if g.System(s.System) == nil {
+				return fmt.Errorf("nation %d: sighting: system %d: %w", n.Id, s.System, ErrUnknownSystem)
+			} else if s.Turn < 0 || s.Turn > g.Turn {
+				return fmt.Errorf("nation %d: sighting: system %d: %w", n.Id, s.System, ErrInvalidTurn)
+			}
+			for _, c := range s.Colonies {
+				if c == nil {
+					return fmt.Errorf("nation %d: sighting: system %d: colony: %w", n.Id, s.System, ErrInvalidValue)
+				}
+			}
+			for _, f := range s.Fleets {
+				if f == nil {
+					return fmt.Errorf("nation %d: sighting: system %d: fleet: %w", n.Id, s.System, ErrInvalidValue)
+				}
+				for _, ship := range f.Ships {
+					if ship == nil {
+						return fmt.Errorf("nation %d: sighting: system %d: fleet %d: ship: %w", n.Id, s.System, f.Id, ErrInvalidValue)
+					}
+				}
+			}
+		}
+	}
+
+	settled := map[int]bool{}
+	for i, c := range g.Colonies {
+		if c == nil {
+			return fmt.Errorf("colony #%d: %w", i+1, ErrInvalidValue)
+		} else if err := useId("colony", c.Id); err != nil {
+			return err
+		} else if !nations[c.Nation] {
+			return fmt.Errorf("colony %d: nation %d: %w", c.Id, c.Nation, ErrUnknownNation)
+		} else if p, _ := g.Planet(c.Planet); p == nil {
+			return fmt.Errorf("colony %d: planet %d: %w", c.Id, c.Planet, ErrUnknownPlanet)
+		} else if settled[c.Planet] {
+			return fmt.Errorf("colony %d: planet %d: %w", c.Id, c.Planet, ErrPlanetSettled)
+		} else if c.Population < 0 || c.Industry < 0 {
+			return fmt.Errorf("colony %d: %w", c.Id, ErrInvalidValue)
+		}
+		settled[c.Planet] = true
+	}
+
+	for i, f := range g.Fleets {
+		if f == nil {
+			return fmt.Errorf("fleet #%d: %w", i+1, ErrInvalidValue)
+		} else if err := useId("fleet", f.Id); err != nil {
+			return err
+		} else if !nations[f.Nation] {
+			return fmt.Errorf("fleet %d: nation %d: %w", f.Id, f.Nation, ErrUnknownNation)
+		} else if g.System(f.System) == nil {
+			return fmt.Errorf("fleet %d: system %d: %w", f.Id, f.System, ErrUnknownSystem)
+		} else if f.Destination != 0 && g.System(f.Destination) == nil {
+			return fmt.Errorf("fleet %d: destination %d: %w", f.Id, f.Destination, ErrUnknownSystem)
+		}
+		for i, s := range f.Ships {
+			if s == nil {
+				return fmt.Errorf("fleet %d: ship #%d: %w", f.Id, i+1, ErrInvalidValue)
+			} else if err := useId("ship", s.Id); err != nil {
+				return err
+			} else if s.Hull <= 0 {
+				return fmt.Errorf("ship %d: %w", s.Id, ErrInvalidValue)
+			}
+			switch s.Class {
+			case ColonyShip, Scout, Transport, Warship:
+			default:
+				return fmt.Errorf("ship %d: %w", s.Id, ErrInvalidValue)
+			}
+		}
+	}
+	return nil
+}
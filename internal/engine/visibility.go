@@ -0,0 +1,180 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// View is what a nation knows about the game at the start of a turn.
+//
+// The embedded Game has the whole star map, the nation's own colonies and
+// fleets in full, the other nations' colonies and fleets in the systems it
+// can see now, and only the names of the other nations. What it remembers of
+// the systems it can't see now is kept apart, in Remembered, so that stale
+// information is never mistaken for current.
+//
+// Pages, reports, and order checks work from a View and never from the
+// complete state, which only the engine uses.
+type View struct {
+	*Game
+	Nation     int         `json:"nation"`     // the nation whose view this is
+	Remembered []*Sighting `json:"remembered"` // systems the nation can't see now, as it last saw them
+}
+
+// Sighting is what a nation saw of a star system on a turn.
+// It only holds other nations' colonies and fleets.
+type Sighting struct {
+	System   int       `json:"system"`
+	Turn     int       `json:"turn"` // the turn the system was last seen
+	Colonies []*Colony `json:"colonies,omitempty"`
+	Fleets   []*Fleet  `json:"fleets,omitempty"`
+}
+
+// Visible returns the ids of the systems that the nation can see now:
+// the systems with its colonies and fleets, the systems within range of
+// its ships' scanners, and the systems that its allies can see.
+func (g *Game) Visible(nation int) map[int]bool {
+	visible := g.scanned(nation)
+	for _, n := range g.Nations {
+		if n.Id != nation && contains(n.Allies, nation) {
+			for id := range g.scanned(n.Id) {
+				visible[id] = true
+			}
+		}
+	}
+	return visible
+}
+
+// scanned returns the ids of the systems that the nation's own colonies and ships can see.
+func (g *Game) scanned(nation int) map[int]bool {
+	seen := map[int]bool{}
+	for _, c := range g.Colonies {
+		if c.Nation == nation {
+			_, s := g.Planet(c.Planet)
+			seen[s.Id] = true
+		}
+	}
+	for _, f := range g.Fleets {
+		if f.Nation != nation {
+			continue
+		}
+		seen[f.System] = true
+		scan := 0
+		for _, ship := range f.Ships {
+			scan = max(scan, ship.Class.ScanRange())
+		}
+		if scan == 0 {
+			continue
+		}
+		from := g.System(f.System)
+		for _, s := range g.Galaxy.Systems {
+			if from.Coord.Distance(s.Coord) <= float64(scan) {
+				seen[s.Id] = true
+			}
+		}
+	}
+	return seen
+}
+
+// View returns what the nation knows about the game.
+// The view is a copy; changing it never changes the game.
+func (g *Game) View(nation int) (*View, error) {
+	if g.Nation(nation) == nil {
+		return nil, fmt.Errorf("nation %d: %w", nation, ErrUnknownNation)
+	}
+	c := g.Clone()
+	current, remembered := c.sightings(nation)
+	visible := map[int]bool{}
+	for _, s := range current {
+		visible[s.System] = true
+	}
+
+	for i, n := range c.Nations {
+		if n.Id == nation {
+			n.Sightings = nil
+		} else {
+			c.Nations[i] = &Nation{Id: n.Id, Name: n.Name}
+		}
+	}
+	colonies := c.Colonies[:0]
+	for _, col := range c.Colonies {
+		if _, s := c.Planet(col.Planet); col.Nation == nation || visible[s.Id] {
+			colonies = append(colonies, col)
+		}
+	}
+	c.Colonies = colonies
+	fleets := c.Fleets[:0]
+	for _, f := range c.Fleets {
+		if f.Nation == nation {
+			fleets = append(fleets, f)
+		} else if visible[f.System] {
+			f.Destination = 0
+			fleets = append(fleets, f)
+		}
+	}
+	c.Fleets = fleets
+	// the seed would let the nation regenerate the galaxy and find every home
+	c.Galaxy.Seed, c.NextId = 0, 0
+	return &View{Game: c, Nation: nation, Remembered: remembered}, nil
+}
+
+// sightings returns what the nation sees now, one sighting for each system it can see,
+// and what it remembers of the systems it can't see now. Fleets that it sees now
+// are left out of what it remembers, since they aren't where they were seen.
+// Both lists are in the order of the systems' ids.
+func (g *Game) sightings(nation int) (current, remembered []*Sighting) {
+	visible := g.Visible(nation)
+	bySystem := map[int]*Sighting{}
+	for _, s := range g.Galaxy.Systems {
+		if visible[s.Id] {
+			bySystem[s.Id] = &Sighting{System: s.Id, Turn: g.Turn}
+			current = append(current, bySystem[s.Id])
+		}
+	}
+	for _, c := range g.Colonies {
+		if _, s := g.Planet(c.Planet); c.Nation != nation && visible[s.Id] {
+			cc := *c
+			bySystem[s.Id].Colonies = append(bySystem[s.Id].Colonies, &cc)
+		}
+	}
+	seen := map[int]bool{} // ids of fleets seen now
+	for _, f := range g.Fleets {
+		if f.Nation != nation && visible[f.System] {
+			cf := f.clone()
+			cf.Destination = 0
+			bySystem[f.System].Fleets = append(bySystem[f.System].Fleets, cf)
+			seen[f.Id] = true
+		}
+	}
+
+	for _, s := range g.Nation(nation).Sightings {
+		if visible[s.System] {
+			continue
+		}
+		rs := &Sighting{System: s.System, Turn: s.Turn, Colonies: s.Colonies}
+		for _, f := range s.Fleets {
+			if !seen[f.Id] {
+				rs.Fleets = append(rs.Fleets, f)
+			}
+		}
+		remembered = append(remembered, rs)
+	}
+	sort.Slice(remembered, func(i, j int) bool {
+		return remembered[i].System < remembered[j].System
+	})
+	return current, remembered
+}
+
+// remember updates what every nation remembers with what it sees now.
+// It is done at the end of every turn, after the state has settled.
+func (g *Game) remember() {
+	for _, n := range g.Nations {
+		current, remembered := g.sightings(n.Id)
+		n.Sightings = append(current, remembered...)
+		sort.Slice(n.Sightings, func(i, j int) bool {
+			return n.Sightings[i].System < n.Sightings[j].System
+		})
+	}
+}
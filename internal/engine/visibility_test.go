@@ -0,0 +1,128 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package engine_test
+
+import (
+	"github.com/mdhender/wraithi/internal/engine"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// ids returns the ids of the nation's colonies and fleets in the view,
+// and of the other nations' colonies and fleets.
+func ids(v *engine.View) (own, other []int) {
+	for _, c := range v.Colonies {
+		if c.Nation == v.Nation {
+			own = append(own, c.Id)
+		} else {
+			other = append(other, c.Id)
+		}
+	}
+	for _, f := range v.Fleets {
+		if f.Nation == v.Nation {
+			own = append(own, f.Id)
+		} else {
+			other = append(other, f.Id)
+		}
+	}
+	return own, other
+}
+
+func TestView(t *testing.T) {
+	g := loadFixture(t, filepath.Join(fixtures, "example", "turn-0000.json"))
+	g.Galaxy.Systems[1].Coord.X = 4 // Beta, in range of Red's scout in Alpha
+	g.Nations[1].Allies = []int{3}  // Blue shares what it sees with Green
+	g.Fleets[1].Destination = 7
+
+	for _, tc := range []struct {
+		id      int
+		nation  int
+		visible []int
+		own     []int
+		other   []int
+	}{
+		{1, 1, []int{1, 4}, []int{12, 15, 25}, []int{13, 18}},
+		{2, 2, []int{4}, []int{13, 18}, nil},
+		{3, 3, []int{4, 10}, []int{14, 22}, []int{13, 18}},
+	} {
+		var visible []int
+		for _, s := range g.Galaxy.Systems {
+			if g.Visible(tc.nation)[s.Id] {
+				visible = append(visible, s.Id)
+			}
+		}
+		if !reflect.DeepEqual(visible, tc.visible) {
+			t.Errorf("%d: visible: want %v, got %v", tc.id, tc.visible, visible)
+		}
+		v, err := g.View(tc.nation)
+		if err != nil {
+			t.Fatalf("%d: view: want nil, got %v", tc.id, err)
+		}
+		own, other := ids(v)
+		if !reflect.DeepEqual(own, tc.own) {
+			t.Errorf("%d: own: want %v, got %v", tc.id, tc.own, own)
+		}
+		if !reflect.DeepEqual(other, tc.other) {
+			t.Errorf("%d: other: want %v, got %v", tc.id, tc.other, other)
+		}
+		for _, n := range v.Nations {
+			if n.Id != tc.nation && (n.Stockpile != 0 || n.Home != 0) {
+				t.Errorf("%d: nation %d: want name only, got %+v", tc.id, n.Id, *n)
+			}
+		}
+		for _, f := range v.Fleets {
+			if f.Nation != tc.nation && f.Destination != 0 {
+				t.Errorf("%d: fleet %d: want no destination, got %d", tc.id, f.Id, f.Destination)
+			}
+		}
+		if v.Galaxy.Seed != 0 || v.NextId != 0 {
+			t.Errorf("%d: want seed and next id hidden, got %d and %d", tc.id, v.Galaxy.Seed, v.NextId)
+		}
+	}
+	if g.Fleets[1].Destination != 7 {
+		t.Errorf("view: changed the game")
+	}
+	if _, err := g.View(9); err == nil {
+		t.Errorf("unknown nation: want error, got nil")
+	}
+}
+
+func TestViewRemembered(t *testing.T) {
+	g := loadFixture(t, filepath.Join(fixtures, "example", "turn-0000.json"))
+	g.Galaxy.Systems[1].Coord.X = 4 // Beta, in range of Red's scout in Alpha
+
+	// Red sees Beta at the end of turn 1, then sends its scout out of range
+	one, err := engine.Advance(g, nil)
+	if err != nil {
+		t.Fatalf("turn 1: want nil, got %v", err)
+	}
+	two, err := engine.Advance(one.Game, map[int][]engine.Order{
+		1: {{Kind: engine.Move, Fleet: 15, System: 10}},
+		2: {{Kind: engine.Build, Colony: 13, Product: engine.Industry, Amount: 1}},
+	})
+	if err != nil {
+		t.Fatalf("turn 2: want nil, got %v", err)
+	}
+	v, err := two.Game.View(1)
+	if err != nil {
+		t.Fatalf("view: want nil, got %v", err)
+	}
+	if _, other := ids(v); !reflect.DeepEqual(other, []int{14, 22}) {
+		t.Errorf("current: want [14 22], got %v", other)
+	}
+	if len(v.Remembered) != 1 {
+		t.Fatalf("remembered: want Beta, got %+v", v.Remembered)
+	}
+	beta := v.Remembered[0]
+	if beta.System != 4 || beta.Turn != 1 {
+		t.Errorf("remembered: want system 4 on turn 1, got system %d on turn %d", beta.System, beta.Turn)
+	}
+	// the colony is as it was on turn 1, not as it is now
+	if len(beta.Colonies) != 1 || beta.Colonies[0].Industry != one.Game.Colony(13).Industry {
+		t.Errorf("remembered: want colony 13 as of turn 1, got %+v", beta.Colonies)
+	}
+	if len(beta.Fleets) != 1 || beta.Fleets[0].Id != 18 {
+		t.Errorf("remembered: want fleet 18, got %+v", beta.Fleets)
+	}
+}
@@ -0,0 +1,187 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package lockout throttles sign-in attempts.
+// Failed attempts are counted per account and per client address.
+// Once a counter passes its policy's threshold, every further failure
+// locks the key out for twice as long as the last one, up to a limit.
+package lockout
+
+import (
+	"strings"
+	"time"
+)
+
+// Account returns the key for counting failures against an account.
+func Account(id string) string {
+	return "account:" + id
+}
+
+// IP returns the key for counting failures from a client address.
+func IP(addr string) string {
+	return "ip:" + addr
+}
+
+// Counter is the failed attempts for a key.
+type Counter struct {
+	Key         string
+	Failures    int       // failures since the counter was last reset
+	LastFailure time.Time // time of the most recent failure
+	LockedUntil time.Time // zero if the key has never been locked
+}
+
+// IsLocked returns true if the key is locked out at the given time.
+func (c Counter) IsLocked(now time.Time) bool {
+	return now.Before(c.LockedUntil)
+}
+
+// Store is the interface for persisting counters.
+// The store must be safe to share between servers, so Fail
+// increments the counter and Lock sets the lock in single steps.
+type Store interface {
+	// Fail records a failure and returns the updated counter.
+	// Counters with no failure or lock since the start of the window are reset before counting.
+	Fail(key string, now, windowStart time.Time) (Counter, error)
+	// Lock locks the key out until the given time.
+	Lock(key string, until time.Time) error
+	// Get returns the counter for the key, or a zero counter if there isn't one.
+	Get(key string) (Counter, error)
+	// Clear deletes the counter for the key.
+	Clear(key string) error
+	// Counters returns the counters with a failure or a lock since the given time.
+	Counters(since time.Time) ([]Counter, error)
+}
+
+// Policy configures when a key is locked out.
+type Policy struct {
+	Threshold int           // failures allowed before the key is locked
+	Window    time.Duration // failures are forgotten this long after the last failure or lock
+	Base      time.Duration // length of the first lock
+	Max       time.Duration // longest lock
+}
+
+// lockFor returns how long to lock out a key with the given number of failures.
+// It is zero until the threshold is reached and doubles with each failure after that.
+func (p Policy) lockFor(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	d := p.Base
+	for n := p.Threshold; n < failures && d < p.Max; n++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// Limiter applies policies to the counters in a store.
+type Limiter struct {
+	store   Store
+	account Policy
+	ip      Policy
+}
+
+// DefaultAccountPolicy allows five failures against an account.
+var DefaultAccountPolicy = Policy{Threshold: 5, Window: 15 * time.Minute, Base: time.Minute, Max: time.Hour}
+
+// DefaultIPPolicy is more forgiving since many users may share an address.
+var DefaultIPPolicy = Policy{Threshold: 20, Window: 15 * time.Minute, Base: time.Minute, Max: time.Hour}
+
+// New returns a Limiter that applies the policies to the counters in the store.
+func New(store Store, account, ip Policy) *Limiter {
+	return &Limiter{store: store, account: account, ip: ip}
+}
+
+// policy returns the policy for the key.
+func (l *Limiter) policy(key string) Policy {
+	if strings.HasPrefix(key, "ip:") {
+		return l.ip
+	}
+	return l.account
+}
+
+// Check returns how long the caller must wait before trying again.
+// It is zero if none of the keys are locked out.
+func (l *Limiter) Check(now time.Time, keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range keys {
+		c, err := l.store.Get(key)
+		if err != nil {
+			return 0, err
+		} else if c.IsLocked(now) && c.LockedUntil.Sub(now) > wait {
+			wait = c.LockedUntil.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// Fail records a failed attempt against each key and returns
+// the longest lock that the failure caused. It is zero if no key was locked.
+func (l *Limiter) Fail(now time.Time, keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range keys {
+		p := l.policy(key)
+		c, err := l.store.Fail(key, now, now.Add(-p.Window))
+		if err != nil {
+			return 0, err
+		}
+		if d := p.lockFor(c.Failures); d > 0 {
+			if err := l.store.Lock(key, now.Add(d)); err != nil {
+				return 0, err
+			} else if d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, nil
+}
+
+// Succeed forgets the failures against the key.
+// Call it for the account after a successful sign-in; addresses are left to expire.
+func (l *Limiter) Succeed(key string) error {
+	return l.store.Clear(key)
+}
+
+// Clear removes the key's failures and any lock.
+func (l *Limiter) Clear(key string) error {
+	return l.store.Clear(key)
+}
+
+// Get returns the counter for the key.
+// It is a zero counter if the key isn't locked and the policy has forgotten its failures.
+func (l *Limiter) Get(now time.Time, key string) (Counter, error) {
+	c, err := l.store.Get(key)
+	if err != nil {
+		return Counter{}, err
+	} else if l.forgotten(now, c) {
+		return Counter{Key: key}, nil
+	}
+	return c, nil
+}
+
+// Counters returns the counters that are locked or that have
+// failures that the policies still remember, most recent first.
+func (l *Limiter) Counters(now time.Time) ([]Counter, error) {
+	window := l.account.Window
+	if l.ip.Window > window {
+		window = l.ip.Window
+	}
+	list, err := l.store.Counters(now.Add(-window))
+	if err != nil {
+		return nil, err
+	}
+	var counters []Counter
+	for _, c := range list {
+		if !l.forgotten(now, c) {
+			counters = append(counters, c)
+		}
+	}
+	return counters, nil
+}
+
+// forgotten returns true if the policy no longer remembers the counter's failures.
+func (l *Limiter) forgotten(now time.Time, c Counter) bool {
+	windowStart := now.Add(-l.policy(c.Key).Window)
+	return c.LastFailure.Before(windowStart) && c.LockedUntil.Before(windowStart)
+}
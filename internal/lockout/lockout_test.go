@@ -0,0 +1,80 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lockout_test
+
+import (
+	"github.com/mdhender/wraithi/internal/lockout"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := lockout.Policy{Threshold: 3, Window: 15 * time.Minute, Base: time.Minute, Max: 5 * time.Minute}
+	l := lockout.New(lockout.NewMemoryStore(), policy, policy)
+	key := lockout.Account("u1")
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		id   int
+		want time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 5 * time.Minute},
+		{7, 5 * time.Minute},
+	} {
+		got, err := l.Fail(now, key)
+		if err != nil {
+			t.Fatalf("%d: fail: want nil, got %v", tc.id, err)
+		} else if got != tc.want {
+			t.Errorf("%d: fail: want %v, got %v", tc.id, tc.want, got)
+		}
+		if wait, err := l.Check(now, key); err != nil {
+			t.Fatalf("%d: check: want nil, got %v", tc.id, err)
+		} else if wait != tc.want {
+			t.Errorf("%d: check: want %v, got %v", tc.id, tc.want, wait)
+		}
+		now = now.Add(time.Second)
+	}
+
+	// the counter is forgotten once the lock and the window have passed
+	now = now.Add(5*time.Minute + policy.Window)
+	if got, err := l.Fail(now, key); err != nil {
+		t.Fatalf("reset: want nil, got %v", err)
+	} else if got != 0 {
+		t.Errorf("reset: want 0, got %v", got)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	account := lockout.Policy{Threshold: 2, Window: time.Minute, Base: time.Minute, Max: time.Hour}
+	ip := lockout.Policy{Threshold: 4, Window: time.Minute, Base: time.Minute, Max: time.Hour}
+	l := lockout.New(lockout.NewMemoryStore(), account, ip)
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	// the account locks first; the address is still allowed for other accounts
+	for i := 0; i < 2; i++ {
+		if _, err := l.Fail(now, lockout.Account("u1"), lockout.IP("10.0.0.1")); err != nil {
+			t.Fatalf("fail: want nil, got %v", err)
+		}
+	}
+	if wait, _ := l.Check(now, lockout.Account("u1"), lockout.IP("10.0.0.1")); wait != time.Minute {
+		t.Errorf("u1: want %v, got %v", time.Minute, wait)
+	}
+	if wait, _ := l.Check(now, lockout.Account("u2"), lockout.IP("10.0.0.1")); wait != 0 {
+		t.Errorf("u2: want 0, got %v", wait)
+	}
+	if list, _ := l.Counters(now); len(list) != 2 {
+		t.Errorf("counters: want 2, got %d", len(list))
+	}
+
+	// clearing the account lifts its lock
+	if err := l.Clear(lockout.Account("u1")); err != nil {
+		t.Fatalf("clear: want nil, got %v", err)
+	} else if wait, _ := l.Check(now, lockout.Account("u1")); wait != 0 {
+		t.Errorf("cleared: want 0, got %v", wait)
+	}
+}
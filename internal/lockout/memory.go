@@ -0,0 +1,85 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lockout
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewMemoryStore returns a Store that keeps counters in memory.
+// Counters are lost when the server restarts and aren't shared between servers.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: make(map[string]Counter),
+	}
+}
+
+// MemoryStore implements an in-memory Store.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data map[string]Counter
+}
+
+// Fail implements the Store interface.
+func (ms *MemoryStore) Fail(key string, now, windowStart time.Time) (Counter, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	c, ok := ms.data[key]
+	if !ok || (c.LastFailure.Before(windowStart) && c.LockedUntil.Before(windowStart)) {
+		c = Counter{Key: key}
+	}
+	c.Failures, c.LastFailure = c.Failures+1, now
+	ms.data[key] = c
+	return c, nil
+}
+
+// Lock implements the Store interface.
+func (ms *MemoryStore) Lock(key string, until time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if c, ok := ms.data[key]; ok {
+		c.LockedUntil = until
+		ms.data[key] = c
+	}
+	return nil
+}
+
+// Get implements the Store interface.
+func (ms *MemoryStore) Get(key string) (Counter, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if c, ok := ms.data[key]; ok {
+		return c, nil
+	}
+	return Counter{Key: key}, nil
+}
+
+// Clear implements the Store interface.
+func (ms *MemoryStore) Clear(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.data, key)
+	return nil
+}
+
+// Counters implements the Store interface.
+// Older counters are deleted.
+func (ms *MemoryStore) Counters(since time.Time) ([]Counter, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var list []Counter
+	for k, c := range ms.data {
+		if !c.LastFailure.Before(since) || !c.LockedUntil.Before(since) {
+			list = append(list, c)
+		} else {
+			// purge stale counters while we hold the lock
+			delete(ms.data, k)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastFailure.After(list[j].LastFailure)
+	})
+	return list, nil
+}
@@ -0,0 +1,100 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// NewMySQLStore returns a Store that keeps counters in the `signin_failures` table.
+// Servers that share the database share the counters.
+func NewMySQLStore(ctx context.Context, db *sql.DB) *MySQLStore {
+	return &MySQLStore{
+		context: ctx,
+		db:      db,
+	}
+}
+
+// MySQLStore implements a Store backed by a MySQL database.
+type MySQLStore struct {
+	context context.Context
+	db      *sql.DB
+}
+
+// Fail implements the Store interface.
+// The counter is reset and incremented in one statement so that
+// concurrent failures from other servers aren't lost.
+func (ms *MySQLStore) Fail(key string, now, windowStart time.Time) (Counter, error) {
+	now, windowStart = now.UTC(), windowStart.UTC()
+	if _, err := ms.db.ExecContext(ms.context,
+		`insert into signin_failures (lockout_key, failures, last_failure) values (?, 1, ?)
+		 on duplicate key update
+		   failures = if(last_failure < ? and (locked_until is null or locked_until < ?), 1, failures + 1),
+		   locked_until = if(failures = 1, null, locked_until),
+		   last_failure = values(last_failure)`,
+		key, now, windowStart, windowStart); err != nil {
+		return Counter{}, err
+	}
+	return ms.Get(key)
+}
+
+// Lock implements the Store interface.
+func (ms *MySQLStore) Lock(key string, until time.Time) error {
+	_, err := ms.db.ExecContext(ms.context, "update signin_failures set locked_until = ? where lockout_key = ?", until.UTC(), key)
+	return err
+}
+
+// Get implements the Store interface.
+func (ms *MySQLStore) Get(key string) (Counter, error) {
+	c := Counter{Key: key}
+	var lockedUntil sql.NullTime
+	row := ms.db.QueryRowContext(ms.context, "select failures, last_failure, locked_until from signin_failures where lockout_key = ?", key)
+	if err := row.Scan(&c.Failures, &c.LastFailure, &lockedUntil); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Counter{Key: key}, nil
+		}
+		return Counter{}, err
+	}
+	c.LockedUntil = lockedUntil.Time
+	return c, nil
+}
+
+// Clear implements the Store interface.
+func (ms *MySQLStore) Clear(key string) error {
+	_, err := ms.db.ExecContext(ms.context, "delete from signin_failures where lockout_key = ?", key)
+	return err
+}
+
+// Counters implements the Store interface.
+// Older counters are deleted.
+func (ms *MySQLStore) Counters(since time.Time) ([]Counter, error) {
+	// purge stale counters so the table doesn't grow forever
+	if _, err := ms.db.ExecContext(ms.context,
+		"delete from signin_failures where last_failure < ? and (locked_until is null or locked_until < ?)",
+		since.UTC(), since.UTC()); err != nil {
+		return nil, err
+	}
+	rows, err := ms.db.QueryContext(ms.context,
+		"select lockout_key, failures, last_failure, locked_until from signin_failures where last_failure >= ? or locked_until >= ? order by last_failure desc",
+		since.UTC(), since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []Counter
+	for rows.Next() {
+		var c Counter
+		var lockedUntil sql.NullTime
+		if err := rows.Scan(&c.Key, &c.Failures, &c.LastFailure, &lockedUntil); err != nil {
+			return nil, err
+		}
+		c.LockedUntil = lockedUntil.Time
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
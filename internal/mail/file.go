@@ -0,0 +1,44 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// NewFileMailer returns a Mailer that writes each message as an .eml file in the path.
+// The path is created if it doesn't exist.
+func NewFileMailer(path string) (*FileMailer, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{path: path}, nil
+}
+
+// FileMailer implements a Mailer that drops messages into a directory.
+// It is meant for development, where opening the file is easier than running a mail server.
+type FileMailer struct {
+	path string
+}
+
+// Send implements the Mailer interface.
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(m.path, name), data, 0o600)
+}
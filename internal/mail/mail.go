@@ -0,0 +1,94 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package mail implements sending e-mail through pluggable transports.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Mailer is the interface for sending e-mail.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Message is a plain text e-mail message.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	Date    time.Time
+}
+
+// Errors used by the package.
+const (
+	ErrInvalidHeader = constError("invalid header")
+	ErrNoRecipients  = constError("no recipients")
+	ErrUnknownMailer = constError("unknown mailer")
+)
+
+// declarations to support constant errors
+type constError string
+
+func (ce constError) Error() string {
+	return string(ce)
+}
+
+// Bytes returns the message formatted per RFC 5322.
+func (m Message) Bytes() ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, ErrNoRecipients
+	}
+	// reject header injection
+	for _, v := range append([]string{m.From, m.Subject}, m.To...) {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if _, d, ok := strings.Cut(m.From, "@"); ok {
+		domain = strings.TrimSuffix(d, ">")
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(buf, "Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(buf, "\r\n")
+	for _, line := range strings.Split(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n") {
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// mailAddress returns the address part of a header value.
+func mailAddress(s string) (string, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
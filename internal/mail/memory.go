@@ -0,0 +1,40 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// NewMemoryMailer returns a Mailer that keeps messages in memory.
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// MemoryMailer implements a Mailer that saves messages instead of sending them.
+type MemoryMailer struct {
+	sync.Mutex
+	messages []Message
+}
+
+// Send implements the Mailer interface.
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := msg.Bytes(); err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the messages that have been sent.
+func (m *MemoryMailer) Messages() []Message {
+	m.Lock()
+	defer m.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,49 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// NewSMTPMailer returns a Mailer that delivers messages to an SMTP server.
+// If user is empty, no authentication is attempted, which is what a local
+// catcher like MailHog or Mailpit expects.
+func NewSMTPMailer(host string, port int, user, secret string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	if user != "" {
+		m.auth = smtp.PlainAuth("", user, secret, host)
+	}
+	return m
+}
+
+// SMTPMailer implements a Mailer using net/smtp.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+}
+
+// Send implements the Mailer interface.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, envelope(msg.From), msg.To, data)
+}
+
+// envelope returns the bare address from a From header like "Wraith <wraith@example.com>".
+func envelope(from string) string {
+	if addr, err := mailAddress(from); err == nil {
+		return addr
+	}
+	return from
+}
@@ -3,8 +3,9 @@
 package nonces
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"github.com/google/uuid"
-	"log"
 
 	"sync"
 	"time"
@@ -12,7 +13,7 @@ import (
 
 func NewFactory(ttl time.Duration) *Factory {
 	return &Factory{
-		data: make(map[string]time.Time),
+		data: make(map[string]entry),
 		ttl:  ttl,
 	}
 }
@@ -20,14 +21,45 @@ func NewFactory(ttl time.Duration) *Factory {
 // Factory implements a factory with caching, ttl, and cache-cleaning.
 type Factory struct {
 	sync.Mutex
-	data          map[string]time.Time
+	data          map[string]entry
 	ttl           time.Duration // time-to-live for each nonce
 	checkExpiryAt time.Time     // next time to check for expired data
 }
 
+// entry is the data cached for a nonce.
+type entry struct {
+	expires  time.Time
+	verifier string // PKCE code verifier bound to the nonce, if any
+	subject  string // subject bound to the nonce, if any
+}
+
 // Create creates a new nonce.
 // Should maybe panic since errors aren't recoverable.
 func (f *Factory) Create() (string, error) {
+	return f.create(entry{})
+}
+
+// CreateFor creates a new nonce bound to the subject.
+// This is used for single-use, time-limited links like password resets.
+func (f *Factory) CreateFor(subject string) (string, error) {
+	return f.create(entry{subject: subject})
+}
+
+// CreateWithVerifier creates a new nonce and a PKCE code verifier bound to it.
+// The verifier is a random 43 character string, per RFC 7636 section 4.1.
+func (f *Factory) CreateWithVerifier() (nonce, verifier string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	if nonce, err = f.create(entry{verifier: verifier}); err != nil {
+		return "", "", err
+	}
+	return nonce, verifier, nil
+}
+
+func (f *Factory) create(e entry) (string, error) {
 	f.Lock()
 	defer f.Unlock()
 
@@ -37,34 +69,53 @@ func (f *Factory) Create() (string, error) {
 	}
 	nonce := id.String()
 
-	f.data[nonce] = time.Now().Add(f.ttl)
+	e.expires = time.Now().Add(f.ttl)
+	f.data[nonce] = e
 
-	log.Printf("[nonce] created %q %v\n", nonce, f.data[nonce])
 	return nonce, nil
 }
 
 // Lookup returns true if it finds the nonce in the cache, and it hasn't expired.
 // Side effect: clears the cache every so often.
 func (f *Factory) Lookup(nonce string) bool {
+	_, ok := f.Verifier(nonce)
+	return ok
+}
+
+// Verifier returns the PKCE code verifier bound to the nonce.
+// It returns false if the nonce isn't in the cache or has expired.
+// The verifier is empty if the nonce was created without one.
+// Nonces are single use, so the nonce is removed from the cache.
+func (f *Factory) Verifier(nonce string) (string, bool) {
+	e, ok := f.consume(nonce)
+	return e.verifier, ok
+}
+
+// Subject returns the subject bound to the nonce.
+// It returns false if the nonce isn't in the cache or has expired.
+// Nonces are single use, so the nonce is removed from the cache.
+func (f *Factory) Subject(nonce string) (string, bool) {
+	e, ok := f.consume(nonce)
+	return e.subject, ok
+}
+
+// consume removes the nonce from the cache and returns its entry.
+// Side effect: clears the cache every so often.
+func (f *Factory) consume(nonce string) (entry, bool) {
 	f.Lock()
 	defer f.Unlock()
 
-	log.Printf("[nonce] lookup %q %v\n", nonce, time.Now())
-	for nonce, exp := range f.data {
-		log.Printf("[nonce] cached %q %v\n", nonce, exp)
-	}
-
 	now := time.Now()
-	exp, ok := f.data[nonce]
+	e, ok := f.data[nonce]
 	if ok {
-		ok = now.Before(exp)
+		ok = now.Before(e.expires)
 		delete(f.data, nonce)
 	}
 
 	// do we need to check for expired data?
 	if now.After(f.checkExpiryAt) {
-		for nonce, exp := range f.data {
-			if now.After(exp) {
+		for nonce, e := range f.data {
+			if now.After(e.expires) {
 				delete(f.data, nonce)
 				continue
 			}
@@ -73,5 +124,8 @@ func (f *Factory) Lookup(nonce string) bool {
 		f.checkExpiryAt = now.Add(15 * time.Minute)
 	}
 
-	return ok
+	if !ok {
+		return entry{}, false
+	}
+	return e, true
 }
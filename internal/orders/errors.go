@@ -0,0 +1,33 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package orders
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is a mistake in the orders at a line and column.
+type Error struct {
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+	Msg  string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Errors is every mistake found in the orders, in the order they appear.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	var sb strings.Builder
+	for i, err := range e {
+		if i != 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
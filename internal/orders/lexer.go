@@ -0,0 +1,134 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package orders
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenKind is the kind of a token.
+type TokenKind int
+
+// Kinds of tokens.
+const (
+	EOF     TokenKind = iota
+	EOL               // end of an order, which is a newline or a semicolon
+	Number            // a run of digits
+	Word              // a letter followed by letters, digits, or dashes
+	Illegal           // any other character
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case EOF:
+		return "end of input"
+	case EOL:
+		return "end of line"
+	case Number:
+		return "number"
+	case Word:
+		return "word"
+	}
+	return "illegal character"
+}
+
+// Token is a word, number, or separator in the input.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Line int // starts at 1
+	Col  int // starts at 1 and counts characters, not bytes
+}
+
+func (t Token) String() string {
+	switch t.Kind {
+	case EOF, EOL:
+		return t.Kind.String()
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// Lexer splits the orders language into tokens.
+// Comments start with a '#' and run to the end of the line.
+type Lexer struct {
+	src  string
+	pos  int // byte offset of the next character
+	line int
+	col  int
+}
+
+// NewLexer returns a lexer for the input.
+func NewLexer(src string) *Lexer {
+	return &Lexer{src: src, line: 1, col: 1}
+}
+
+// peek returns the next character without consuming it.
+func (l *Lexer) peek() rune {
+	if l.pos >= len(l.src) {
+		return -1
+	}
+	ch, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return ch
+}
+
+// advance consumes the next character.
+func (l *Lexer) advance() rune {
+	ch, size := utf8.DecodeRuneInString(l.src[l.pos:])
+	l.pos += size
+	if ch == '\n' {
+		l.line, l.col = l.line+1, 1
+	} else {
+		l.col++
+	}
+	return ch
+}
+
+// Next returns the next token. It returns EOF forever once the input is used up.
+func (l *Lexer) Next() Token {
+	for {
+		ch := l.peek()
+		if ch == '#' {
+			for ch != '\n' && ch != -1 {
+				l.advance()
+				ch = l.peek()
+			}
+		}
+		if ch == '\n' || ch == -1 || !unicode.IsSpace(ch) {
+			break
+		}
+		l.advance()
+	}
+
+	t := Token{Line: l.line, Col: l.col}
+	start := l.pos
+	switch ch := l.peek(); {
+	case ch == -1:
+		t.Kind = EOF
+		return t
+	case ch == '\n' || ch == ';':
+		l.advance()
+		t.Kind = EOL
+	case isDigit(ch):
+		for isDigit(l.peek()) {
+			l.advance()
+		}
+		t.Kind = Number
+	case unicode.IsLetter(ch):
+		for ch := l.peek(); unicode.IsLetter(ch) || isDigit(ch) || ch == '-'; ch = l.peek() {
+			l.advance()
+		}
+		t.Kind = Word
+	default:
+		l.advance()
+		t.Kind = Illegal
+	}
+	t.Text = l.src[start:l.pos]
+	return t
+}
+
+// isDigit returns true for the ASCII digits, which are the only ones numbers may use.
+func isDigit(ch rune) bool {
+	return '0' <= ch && ch <= '9'
+}
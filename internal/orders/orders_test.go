@@ -0,0 +1,102 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package orders_test
+
+import (
+	"errors"
+	"github.com/mdhender/wraithi/internal/engine"
+	"github.com/mdhender/wraithi/internal/orders"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		id   int
+		src  string
+		want []engine.Order
+	}{
+		{1, "", nil},
+		{2, "# just a comment\n\n", nil},
+		{3, "build 10 industry at colony 12", []engine.Order{{Kind: engine.Build, Amount: 10, Product: engine.Industry, Colony: 12, Line: 1, Col: 1}}},
+		{4, "BUILD 2 Warships AT Colony 12", []engine.Order{{Kind: engine.Build, Amount: 2, Product: "warship", Colony: 12, Line: 1, Col: 1}}},
+		{5, "colonize planet 34 with fleet 56", []engine.Order{{Kind: engine.Colonize, Planet: 34, Fleet: 56, Line: 1, Col: 1}}},
+		{6, "  hold fleet 56 # wait here", []engine.Order{{Kind: engine.Hold, Fleet: 56, Line: 1, Col: 3}}},
+		{7, "move fleet 56 to system 78\nresearch 100", []engine.Order{
+			{Kind: engine.Move, Fleet: 56, System: 78, Line: 1, Col: 1},
+			{Kind: engine.Research, Amount: 100, Line: 2, Col: 1},
+		}},
+		{8, "scrap ship 90; transfer 50 to nation 2", []engine.Order{
+			{Kind: engine.Scrap, Ship: 90, Line: 1, Col: 1},
+			{Kind: engine.Transfer, Amount: 50, Nation: 2, Line: 1, Col: 16},
+		}},
+	} {
+		got, err := orders.Parse(tc.src)
+		if err != nil {
+			t.Errorf("%d: want nil, got %v", tc.id, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d: want %+v, got %+v", tc.id, tc.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		id     int
+		src    string
+		orders int // orders read from the lines without mistakes
+		want   []orders.Error
+	}{
+		{1, "fly fleet 1 to system 2", 0, []orders.Error{{Line: 1, Col: 1, Msg: `unknown order "fly"`}}},
+		{2, "move fleet x to system 2", 0, []orders.Error{{Line: 1, Col: 12, Msg: `want a number, got "x"`}}},
+		{3, "move fleet 1 system 2", 0, []orders.Error{{Line: 1, Col: 14, Msg: `want "to", got "system"`}}},
+		{4, "research 100\nbuild 3 frigates at colony 4\nhold fleet 9", 2, []orders.Error{{Line: 2, Col: 9, Msg: `can't build "frigates"; want one of industry, scout, transport, colony, warship`}}},
+		{5, "hold fleet 9 now", 0, []orders.Error{{Line: 1, Col: 14, Msg: `want end of line, got "now"`}}},
+		{6, "research 99999999999999999999", 0, []orders.Error{{Line: 1, Col: 10, Msg: "number 99999999999999999999 is too large"}}},
+		{7, "research\nscrap ship\n", 0, []orders.Error{
+			{Line: 1, Col: 9, Msg: "want a number, got end of line"},
+			{Line: 2, Col: 11, Msg: "want a number, got end of line"},
+		}},
+		{8, "hold fleet 1 @", 0, []orders.Error{{Line: 1, Col: 14, Msg: `want end of line, got "@"`}}},
+	} {
+		got, err := orders.Parse(tc.src)
+		var errs orders.Errors
+		if !errors.As(err, &errs) {
+			t.Errorf("%d: want Errors, got %v", tc.id, err)
+			continue
+		}
+		if len(got) != tc.orders {
+			t.Errorf("%d: orders: want %d, got %d", tc.id, tc.orders, len(got))
+		}
+		if len(errs) != len(tc.want) {
+			t.Errorf("%d: want %d errors, got %v", tc.id, len(tc.want), err)
+			continue
+		}
+		for i := range errs {
+			if *errs[i] != tc.want[i] {
+				t.Errorf("%d: want %v, got %v", tc.id, &tc.want[i], errs[i])
+			}
+		}
+	}
+}
+
+// orders written by String must read back as the same order
+func TestRoundTrip(t *testing.T) {
+	for _, o := range []engine.Order{
+		{Kind: engine.Build, Amount: 3, Product: string(engine.ColonyShip), Colony: 4},
+		{Kind: engine.Colonize, Planet: 5, Fleet: 6},
+		{Kind: engine.Hold, Fleet: 7},
+		{Kind: engine.Move, Fleet: 8, System: 9},
+		{Kind: engine.Research, Amount: 10},
+		{Kind: engine.Scrap, Ship: 11},
+		{Kind: engine.Transfer, Amount: 12, Nation: 13},
+	} {
+		got, err := orders.Parse(o.String())
+		o.Line, o.Col = 1, 1
+		if err != nil {
+			t.Errorf("%s: want nil, got %v", o, err)
+		} else if len(got) != 1 || got[0] != o {
+			t.Errorf("%s: want %+v, got %+v", o, o, got)
+		}
+	}
+}
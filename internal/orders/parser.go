@@ -0,0 +1,188 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package orders reads the orders language.
+//
+// Each order is on a line of its own, or separated from the next by a semicolon.
+// Words may be in any case, and comments start with a '#'.
+//
+//	build 10 industry at colony 12
+//	build 2 warships at colony 12
+//	colonize planet 34 with fleet 56
+//	hold fleet 56
+//	move fleet 56 to system 78
+//	research 100
+//	scrap ship 90
+//	transfer 50 to nation 2
+//
+// The parser only checks the form of the orders.
+// The engine checks them against the state of the game.
+package orders
+
+import (
+	"fmt"
+	"github.com/mdhender/wraithi/internal/engine"
+	"strconv"
+	"strings"
+)
+
+// Parse reads the orders in the text.
+// Lines with mistakes are skipped, so the orders on the other lines are always returned.
+// The error is an Errors with one entry for each line that couldn't be read.
+func Parse(src string) ([]engine.Order, error) {
+	p := &parser{lexer: NewLexer(src)}
+	p.next()
+	var orders []engine.Order
+	var errs Errors
+	for p.tok.Kind != EOF {
+		if p.tok.Kind == EOL {
+			p.next()
+			continue
+		}
+		o, err := p.order()
+		if err != nil {
+			errs = append(errs, err)
+			// skip the rest of the line
+			for p.tok.Kind != EOL && p.tok.Kind != EOF {
+				p.next()
+			}
+			continue
+		}
+		orders = append(orders, o)
+	}
+	if len(errs) != 0 {
+		return orders, errs
+	}
+	return orders, nil
+}
+
+type parser struct {
+	lexer *Lexer
+	tok   Token
+}
+
+func (p *parser) next() {
+	p.tok = p.lexer.Next()
+}
+
+// errorf returns an error at the current token.
+func (p *parser) errorf(format string, args ...any) *Error {
+	return &Error{Line: p.tok.Line, Col: p.tok.Col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// order reads one order and the separator after it.
+func (p *parser) order() (engine.Order, *Error) {
+	o := engine.Order{Line: p.tok.Line, Col: p.tok.Col}
+	if p.tok.Kind != Word {
+		return o, p.errorf("want an order, got %s", p.tok)
+	}
+	var err *Error
+	switch verb := strings.ToLower(p.tok.Text); verb {
+	case "build":
+		// build 10 industry at colony 12
+		o.Kind = engine.Build
+		p.next()
+		if o.Amount, err = p.number(); err == nil {
+			if o.Product, err = p.product(); err == nil {
+				if err = p.keyword("at"); err == nil {
+					o.Colony, err = p.reference("colony")
+				}
+			}
+		}
+	case "colonize":
+		// colonize planet 34 with fleet 56
+		o.Kind = engine.Colonize
+		p.next()
+		if o.Planet, err = p.reference("planet"); err == nil {
+			if err = p.keyword("with"); err == nil {
+				o.Fleet, err = p.reference("fleet")
+			}
+		}
+	case "hold":
+		// hold fleet 56
+		o.Kind = engine.Hold
+		p.next()
+		o.Fleet, err = p.reference("fleet")
+	case "move":
+		// move fleet 56 to system 78
+		o.Kind = engine.Move
+		p.next()
+		if o.Fleet, err = p.reference("fleet"); err == nil {
+			if err = p.keyword("to"); err == nil {
+				o.System, err = p.reference("system")
+			}
+		}
+	case "research":
+		// research 100
+		o.Kind = engine.Research
+		p.next()
+		o.Amount, err = p.number()
+	case "scrap":
+		// scrap ship 90
+		o.Kind = engine.Scrap
+		p.next()
+		o.Ship, err = p.reference("ship")
+	case "transfer":
+		// transfer 50 to nation 2
+		o.Kind = engine.Transfer
+		p.next()
+		if o.Amount, err = p.number(); err == nil {
+			if err = p.keyword("to"); err == nil {
+				o.Nation, err = p.reference("nation")
+			}
+		}
+	default:
+		return o, p.errorf("unknown order %q", p.tok.Text)
+	}
+	if err != nil {
+		return o, err
+	} else if p.tok.Kind != EOL && p.tok.Kind != EOF {
+		return o, p.errorf("want end of line, got %s", p.tok)
+	}
+	return o, nil
+}
+
+// keyword reads the word, which may be in any case.
+func (p *parser) keyword(word string) *Error {
+	if p.tok.Kind != Word || !strings.EqualFold(p.tok.Text, word) {
+		return p.errorf("want %q, got %s", word, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+// number reads a number.
+func (p *parser) number() (int, *Error) {
+	if p.tok.Kind != Number {
+		return 0, p.errorf("want a number, got %s", p.tok)
+	}
+	n, err := strconv.Atoi(p.tok.Text)
+	if err != nil || n > 1_000_000_000 {
+		return 0, p.errorf("number %s is too large", p.tok.Text)
+	}
+	p.next()
+	return n, nil
+}
+
+// reference reads a keyword followed by an id, like "fleet 12".
+func (p *parser) reference(kind string) (int, *Error) {
+	if err := p.keyword(kind); err != nil {
+		return 0, err
+	}
+	return p.number()
+}
+
+// product reads something that can be built.
+// Ships may be plural, like "2 warships".
+func (p *parser) product() (string, *Error) {
+	if p.tok.Kind != Word {
+		return "", p.errorf("want something to build, got %s", p.tok)
+	}
+	word := strings.ToLower(p.tok.Text)
+	for _, product := range engine.Products() {
+		if word == product || (product != engine.Industry && word == product+"s") {
+			p.next()
+			return product, nil
+		}
+	}
+	return "", p.errorf("can't build %q; want one of %s", p.tok.Text, strings.Join(engine.Products(), ", "))
+}
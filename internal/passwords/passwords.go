@@ -0,0 +1,53 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package passwords implements hashing and checking of passwords using BCrypt.
+package passwords
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors used by the package.
+const (
+	ErrEmptyPassword = constError("empty password")
+)
+
+// declarations to support constant errors
+type constError string
+
+func (ce constError) Error() string {
+	return string(ce)
+}
+
+// Hash returns the BCrypt hash of the password.
+// The password is first run through an HMAC keyed with the salt
+// so that the hashes in the database are useless without the server's salt.
+// This also keeps us under BCrypt's 72 byte limit on input length.
+func Hash(password, salt string) (string, error) {
+	if password == "" {
+		return "", ErrEmptyPassword
+	}
+	hashed, err := bcrypt.GenerateFromPassword(pepper(password, salt), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Match returns true if the password matches the hashed value.
+func Match(hashed, password, salt string) bool {
+	if hashed == "" || password == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hashed), pepper(password, salt)) == nil
+}
+
+// pepper returns the hex encoded HMAC of the password.
+func pepper(password, salt string) []byte {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(password))
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,83 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package rbac implements role based access control.
+// Users are granted roles, roles grant permissions,
+// and handlers check permissions, never roles.
+package rbac
+
+import (
+	"sort"
+	"sync"
+)
+
+// Permissions checked by the application.
+const (
+	AccountManage   = "account.manage"   // change your own account; never granted to API tokens
+	AuditRead       = "audit.read"       // read the audit log
+	GameAdmin       = "game.admin"       // administer any game
+	GameCreate      = "game.create"      // create new games
+	GamesRead       = "games.read"       // list and view games
+	InviteCreate    = "invite.create"    // create invitations to sign up
+	OrdersSubmit    = "orders.submit"    // submit orders for your own nations
+	ProfileRead     = "profile.read"     // view user profiles
+	UserImpersonate = "user.impersonate" // act as another user
+	UserManage      = "user.manage"      // manage other users' accounts
+)
+
+// Authenticated is the role every signed-in user has.
+// It isn't stored with the user's other roles.
+const Authenticated = "authenticated"
+
+// Policy maps roles to the permissions they grant.
+// It is safe for concurrent use.
+type Policy struct {
+	sync.RWMutex
+	grants map[string]map[string]bool
+}
+
+// New returns a policy with the given grants, which map a role to its permissions.
+func New(grants map[string][]string) *Policy {
+	p := &Policy{}
+	p.Replace(grants)
+	return p
+}
+
+// Replace replaces all the grants in the policy.
+func (p *Policy) Replace(grants map[string][]string) {
+	m := make(map[string]map[string]bool)
+	for role, perms := range grants {
+		m[role] = make(map[string]bool)
+		for _, perm := range perms {
+			m[role][perm] = true
+		}
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.grants = m
+}
+
+// Permissions returns the set of permissions granted by the roles.
+// Unknown roles grant nothing.
+func (p *Policy) Permissions(roles ...string) map[string]bool {
+	p.RLock()
+	defer p.RUnlock()
+	perms := make(map[string]bool)
+	for _, role := range roles {
+		for perm := range p.grants[role] {
+			perms[perm] = true
+		}
+	}
+	return perms
+}
+
+// Roles returns the names of the roles in the policy, sorted.
+func (p *Policy) Roles() []string {
+	p.RLock()
+	defer p.RUnlock()
+	var roles []string
+	for role := range p.grants {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,40 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package rbac_test
+
+import (
+	"github.com/mdhender/wraithi/internal/rbac"
+	"reflect"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	p := rbac.New(map[string][]string{
+		rbac.Authenticated: {rbac.AccountManage, rbac.GamesRead},
+		"gm":               {rbac.GameCreate},
+		"admin":            {rbac.GameAdmin, rbac.GameCreate, rbac.UserManage},
+	})
+	for _, tc := range []struct {
+		id    int
+		roles []string
+		want  map[string]bool
+	}{
+		{1, nil, map[string]bool{}},
+		{2, []string{"unknown"}, map[string]bool{}},
+		{3, []string{rbac.Authenticated}, map[string]bool{rbac.AccountManage: true, rbac.GamesRead: true}},
+		{4, []string{rbac.Authenticated, "gm"}, map[string]bool{rbac.AccountManage: true, rbac.GamesRead: true, rbac.GameCreate: true}},
+		{5, []string{"gm", "admin"}, map[string]bool{rbac.GameAdmin: true, rbac.GameCreate: true, rbac.UserManage: true}},
+	} {
+		if got := p.Permissions(tc.roles...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d: want %v, got %v", tc.id, tc.want, got)
+		}
+	}
+
+	p.Replace(map[string][]string{"gm": {rbac.GamesRead}})
+	if got := p.Permissions("gm", "admin"); !reflect.DeepEqual(got, map[string]bool{rbac.GamesRead: true}) {
+		t.Errorf("replace: got %v", got)
+	}
+	if got := p.Roles(); !reflect.DeepEqual(got, []string{"gm"}) {
+		t.Errorf("roles: got %v", got)
+	}
+}
@@ -0,0 +1,309 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package reports builds each nation's report for a turn from the nation's
+// view of the game at the end of the turn and the events of the turn.
+// Reports never see the complete state, so they can't show a nation
+// anything that it couldn't see.
+//
+// A Report is plain data: the web pages render it with templates,
+// tools read it as JSON, and WriteText formats it for e-mail and printing.
+package reports
+
+import (
+	"fmt"
+	"github.com/mdhender/wraithi/internal/engine"
+	"sort"
+)
+
+// Report is what a nation knows at the end of a turn.
+type Report struct {
+	GameId     string           `json:"game_id"`
+	GameName   string           `json:"game_name"`
+	Turn       int              `json:"turn"`
+	Nation     NationReport     `json:"nation"`
+	Colonies   []ColonyReport   `json:"colonies"`
+	Fleets     []FleetReport    `json:"fleets"`
+	Production ProductionReport `json:"production"`
+	Research   ResearchReport   `json:"research"`
+	Battles    []BattleReport   `json:"battles"`
+	Contacts   []ContactReport  `json:"contacts"` // other nations' colonies and fleets that the nation sees or remembers
+	Notices    []string         `json:"notices"`  // everything else that happened, in words
+	Events     []engine.Event   `json:"events"`   // the events that the report was made from
+}
+
+// NationReport is the nation's own standing.
+type NationReport struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Stockpile int    `json:"stockpile"`
+}
+
+// ColonyReport is one of the nation's colonies.
+type ColonyReport struct {
+	Id           int               `json:"id"`
+	Planet       int               `json:"planet"`
+	System       SystemRef         `json:"system"`
+	Kind         engine.PlanetKind `json:"kind"`
+	Habitability int               `json:"habitability"`
+	Resources    int               `json:"resources"`
+	Population   int               `json:"population"`
+	Capacity     int               `json:"capacity"`
+	Industry     int               `json:"industry"`
+	Output       int               `json:"output"` // production added this turn
+}
+
+// FleetReport is one of the nation's fleets.
+type FleetReport struct {
+	Id     int          `json:"id"`
+	System SystemRef    `json:"system"`
+	Ships  []ShipReport `json:"ships"`
+}
+
+// ShipReport is a ship in one of the nation's fleets.
+type ShipReport struct {
+	Id    int              `json:"id"`
+	Class engine.ShipClass `json:"class"`
+	Hull  int              `json:"hull"`
+}
+
+// SystemRef names a star system.
+type SystemRef struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (s SystemRef) String() string {
+	return fmt.Sprintf("%s (%d)", s.Name, s.Id)
+}
+
+// ProductionReport is how the nation's production was earned and spent.
+type ProductionReport struct {
+	Produced int              `json:"produced"`
+	Built    []BuildReport    `json:"built"`
+	Sent     []TransferReport `json:"sent"`
+	Received []TransferReport `json:"received"`
+}
+
+// BuildReport is something a colony built.
+type BuildReport struct {
+	Colony  int    `json:"colony"`
+	Product string `json:"product"`
+	Amount  int    `json:"amount"`
+	Fleet   int    `json:"fleet,omitempty"` // the fleet that new ships joined
+}
+
+// TransferReport is production given to or received from another nation.
+type TransferReport struct {
+	Nation int `json:"nation"`
+	Amount int `json:"amount"`
+}
+
+// ResearchReport is the nation's research progress.
+type ResearchReport struct {
+	Spent int `json:"spent"` // this turn
+	Total int `json:"total"`
+}
+
+// BattleReport is a battle that the nation fought in or saw.
+type BattleReport struct {
+	System  SystemRef    `json:"system"`
+	Nations []int        `json:"nations"`
+	Losses  []LossReport `json:"losses"`
+}
+
+// LossReport is a ship destroyed in a battle.
+type LossReport struct {
+	Nation int              `json:"nation"`
+	Ship   int              `json:"ship"`
+	Class  engine.ShipClass `json:"class"`
+}
+
+// ContactReport is another nation's colony or fleet.
+type ContactReport struct {
+	Nation     int                      `json:"nation"`
+	Seen       int                      `json:"seen"` // the turn it was last seen; before the report's turn if it is remembered
+	System     SystemRef                `json:"system"`
+	Colony     int                      `json:"colony,omitempty"`
+	Planet     int                      `json:"planet,omitempty"`
+	Population int                      `json:"population,omitempty"`
+	Fleet      int                      `json:"fleet,omitempty"`
+	Ships      map[engine.ShipClass]int `json:"ships,omitempty"` // number of ships of each class
+}
+
+// New returns the nation's report for the turn that ended with the view.
+// The events are those of the same turn; they're empty for turn zero.
+func New(v *engine.View, events []engine.Event) (*Report, error) {
+	g, nation := v.Game, v.Nation
+	n := g.Nation(nation)
+	if n == nil {
+		return nil, fmt.Errorf("nation %d: %w", nation, engine.ErrUnknownNation)
+	}
+	r := &Report{
+		GameId:   g.Id,
+		GameName: g.Name,
+		Turn:     g.Turn,
+		Nation:   NationReport{Id: n.Id, Name: n.Name, Stockpile: n.Stockpile},
+		Research: ResearchReport{Total: n.Research},
+		Events:   eventsFor(v, events),
+	}
+	system := func(id int) SystemRef {
+		if s := g.System(id); s != nil {
+			return SystemRef{Id: s.Id, Name: s.Name}
+		}
+		return SystemRef{Id: id}
+	}
+
+	output := map[int]int{}  // colony id to production added
+	battles := map[int]int{} // system id to index in Battles
+	for _, e := range r.Events {
+		switch e.Kind {
+		case engine.Battle:
+			// every nation in the battle logs one, and the nation sees them all
+			i, ok := battles[e.System]
+			if !ok {
+				i = len(r.Battles)
+				battles[e.System] = i
+				r.Battles = append(r.Battles, BattleReport{System: system(e.System)})
+			}
+			r.Battles[i].Nations = append(r.Battles[i].Nations, e.Nation)
+		case engine.Built:
+			r.Production.Built = append(r.Production.Built, BuildReport{Colony: e.Colony, Product: e.Product, Amount: e.Amount, Fleet: e.Fleet})
+		case engine.ColonizeFailed:
+			r.Notices = append(r.Notices, fmt.Sprintf("Fleet %d couldn't settle planet %d in %s: %s.", e.Fleet, e.Planet, system(e.System), e.Reason))
+		case engine.ColonyFounded:
+			if e.Nation == nation {
+				r.Notices = append(r.Notices, fmt.Sprintf("Fleet %d founded colony %d on planet %d in %s.", e.Fleet, e.Colony, e.Planet, system(e.System)))
+			} else {
+				r.Notices = append(r.Notices, fmt.Sprintf("Nation %d founded a colony on planet %d in %s.", e.Nation, e.Planet, system(e.System)))
+			}
+		case engine.FleetMoved:
+			if e.Nation == nation {
+				r.Notices = append(r.Notices, fmt.Sprintf("Fleet %d moved from %s to %s.", e.Fleet, system(e.From), system(e.System)))
+			} else {
+				r.Notices = append(r.Notices, fmt.Sprintf("A fleet of nation %d arrived in %s.", e.Nation, system(e.System)))
+			}
+		case engine.OrderRejected:
+			r.Notices = append(r.Notices, fmt.Sprintf("Line %d, %q, wasn't carried out: %s.", e.Line, e.Order, e.Reason))
+		case engine.Produced:
+			output[e.Colony] += e.Amount
+			r.Production.Produced += e.Amount
+		case engine.Researched:
+			r.Research.Spent += e.Amount
+		case engine.ShipDestroyed:
+			if i, ok := battles[e.System]; ok {
+				r.Battles[i].Losses = append(r.Battles[i].Losses, LossReport{Nation: e.Nation, Ship: e.Ship, Class: engine.ShipClass(e.Product)})
+			}
+		case engine.ShipScrapped:
+			r.Notices = append(r.Notices, fmt.Sprintf("Ship %d (%s) in fleet %d was scrapped.", e.Ship, e.Product, e.Fleet))
+		case engine.Transferred:
+			if e.Nation == nation {
+				r.Production.Sent = append(r.Production.Sent, TransferReport{Nation: e.Other, Amount: e.Amount})
+			} else {
+				r.Production.Received = append(r.Production.Received, TransferReport{Nation: e.Nation, Amount: e.Amount})
+			}
+		}
+	}
+
+	for _, b := range r.Battles {
+		sort.Ints(b.Nations)
+	}
+
+	for _, c := range g.Colonies {
+		if c.Nation != nation {
+			continue
+		}
+		p, s := g.Planet(c.Planet)
+		r.Colonies = append(r.Colonies, ColonyReport{
+			Id:           c.Id,
+			Planet:       p.Id,
+			System:       system(s.Id),
+			Kind:         p.Kind,
+			Habitability: p.Habitability,
+			Resources:    p.Resources,
+			Population:   c.Population,
+			Capacity:     p.Capacity(),
+			Industry:     c.Industry,
+			Output:       output[c.Id],
+		})
+	}
+	for _, f := range g.Fleets {
+		if f.Nation != nation {
+			continue
+		}
+		fr := FleetReport{Id: f.Id, System: system(f.System)}
+		for _, s := range f.Ships {
+			fr.Ships = append(fr.Ships, ShipReport{Id: s.Id, Class: s.Class, Hull: s.Hull})
+		}
+		r.Fleets = append(r.Fleets, fr)
+	}
+
+	// the view only has the other nations' colonies and fleets that the nation sees now
+	for _, c := range g.Colonies {
+		if c.Nation != nation {
+			r.Contacts = append(r.Contacts, colonyContact(g, c, g.Turn))
+		}
+	}
+	for _, f := range g.Fleets {
+		if f.Nation != nation {
+			r.Contacts = append(r.Contacts, fleetContact(g, f, g.Turn))
+		}
+	}
+	for _, s := range v.Remembered {
+		for _, c := range s.Colonies {
+			r.Contacts = append(r.Contacts, colonyContact(g, c, s.Turn))
+		}
+		for _, f := range s.Fleets {
+			r.Contacts = append(r.Contacts, fleetContact(g, f, s.Turn))
+		}
+	}
+	sort.SliceStable(r.Contacts, func(i, j int) bool {
+		if r.Contacts[i].System.Id != r.Contacts[j].System.Id {
+			return r.Contacts[i].System.Id < r.Contacts[j].System.Id
+		}
+		return r.Contacts[i].Nation < r.Contacts[j].Nation
+	})
+	return r, nil
+}
+
+// eventsFor returns the events that the nation knows about, without what it
+// doesn't: the system a fleet left is cleared unless the nation can see it,
+// and the only observers kept are the nation and the allies it shares with.
+func eventsFor(v *engine.View, events []engine.Event) []engine.Event {
+	nation := v.Nation
+	visible := v.Visible(nation)
+	shared := map[int]bool{nation: true}
+	for _, ally := range v.Game.Nation(nation).Allies {
+		shared[ally] = true
+	}
+	list := engine.EventsFor(events, nation)
+	for i := range list {
+		e := &list[i]
+		if e.From != 0 && e.Nation != nation && !visible[e.From] {
+			e.From = 0
+		}
+		var observers []int
+		for _, n := range e.Observers {
+			if shared[n] {
+				observers = append(observers, n)
+			}
+		}
+		e.Observers = observers
+	}
+	return list
+}
+
+// colonyContact describes another nation's colony as it was seen on the turn.
+func colonyContact(g *engine.Game, c *engine.Colony, seen int) ContactReport {
+	_, s := g.Planet(c.Planet)
+	return ContactReport{Nation: c.Nation, Seen: seen, System: SystemRef{Id: s.Id, Name: s.Name}, Colony: c.Id, Planet: c.Planet, Population: c.Population}
+}
+
+// fleetContact describes another nation's fleet as it was seen on the turn.
+func fleetContact(g *engine.Game, f *engine.Fleet, seen int) ContactReport {
+	ships := map[engine.ShipClass]int{}
+	for _, s := range f.Ships {
+		ships[s.Class]++
+	}
+	s := g.System(f.System)
+	return ContactReport{Nation: f.Nation, Seen: seen, System: SystemRef{Id: s.Id, Name: s.Name}, Fleet: f.Id, Ships: ships}
+}
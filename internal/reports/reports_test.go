@@ -0,0 +1,213 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package reports_test
+
+import (
+	"bytes"
+	"github.com/mdhender/wraithi/internal/engine"
+	"github.com/mdhender/wraithi/internal/reports"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// example loads turn 1 of the example game in testdata.
+func example(t *testing.T) (*engine.Game, []engine.Event) {
+	t.Helper()
+	fp, err := os.Open("../../testdata/games/example/turn-0001.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+	g, err := engine.Load(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fe, err := os.Open("../../testdata/games/example/events-0001.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fe.Close()
+	}()
+	events, err := engine.LoadEvents(fe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, events
+}
+
+func TestNew(t *testing.T) {
+	g, events := example(t)
+	for _, tc := range []struct {
+		id       int
+		nation   int
+		colonies []int
+		fleets   []int
+		produced int
+		research reports.ResearchReport
+		losses   int
+		contacts int
+	}{
+		{1, 1, []int{12, 27}, []int{29}, 260, reports.ResearchReport{Spent: 50, Total: 50}, 4, 0},
+		{2, 2, []int{13, 28}, []int{18, 31}, 250, reports.ResearchReport{}, 4, 0},
+		{3, 3, []int{14}, nil, 250, reports.ResearchReport{Spent: 25, Total: 25}, 4, 0},
+	} {
+		v, err := g.View(tc.nation)
+		if err != nil {
+			t.Fatalf("%d: view: want nil, got %v", tc.id, err)
+		}
+		r, err := reports.New(v, events)
+		if err != nil {
+			t.Fatalf("%d: want nil, got %v", tc.id, err)
+		}
+		var colonies, fleets []int
+		for _, c := range r.Colonies {
+			colonies = append(colonies, c.Id)
+		}
+		for _, f := range r.Fleets {
+			fleets = append(fleets, f.Id)
+		}
+		if !reflect.DeepEqual(colonies, tc.colonies) {
+			t.Errorf("%d: colonies: want %v, got %v", tc.id, tc.colonies, colonies)
+		}
+		if !reflect.DeepEqual(fleets, tc.fleets) {
+			t.Errorf("%d: fleets: want %v, got %v", tc.id, tc.fleets, fleets)
+		}
+		if r.Production.Produced != tc.produced {
+			t.Errorf("%d: produced: want %d, got %d", tc.id, tc.produced, r.Production.Produced)
+		}
+		if r.Research != tc.research {
+			t.Errorf("%d: research: want %+v, got %+v", tc.id, tc.research, r.Research)
+		}
+		if len(r.Battles) != 1 || len(r.Battles[0].Losses) != tc.losses {
+			t.Errorf("%d: battles: want one with %d losses, got %+v", tc.id, tc.losses, r.Battles)
+		}
+		if len(r.Contacts) != tc.contacts {
+			t.Errorf("%d: contacts: want %d, got %+v", tc.id, tc.contacts, r.Contacts)
+		}
+		for _, e := range r.Events {
+			if !e.SeenBy(tc.nation) {
+				t.Errorf("%d: event %+v: not seen by nation %d", tc.id, e, tc.nation)
+			}
+		}
+	}
+
+	if _, err := reports.New(&engine.View{Game: g, Nation: 9}, events); err == nil {
+		t.Errorf("unknown nation: want error, got nil")
+	}
+}
+
+func TestNewEvents(t *testing.T) {
+	g, events := example(t)
+	for _, tc := range []struct {
+		id        int
+		allies    []int // the nations that Blue shares with
+		kind      engine.EventKind
+		nation    int
+		from      int
+		observers []int
+	}{
+		{1, nil, engine.FleetMoved, 2, 4, nil},      // Blue's own fleet
+		{2, nil, engine.FleetMoved, 3, 0, []int{2}}, // Blue can't see Delta
+		{3, nil, engine.FleetMoved, 1, 0, []int{2}}, // or Alpha
+		{4, nil, engine.Battle, 1, 0, []int{2}},     // Green saw it, but Blue doesn't know that
+		{5, []int{3}, engine.Battle, 1, 0, []int{2, 3}},
+	} {
+		g.Nations[1].Allies = tc.allies
+		v, err := g.View(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := reports.New(v, events)
+		if err != nil {
+			t.Fatalf("%d: want nil, got %v", tc.id, err)
+		}
+		var found bool
+		for _, e := range r.Events {
+			if e.Kind != tc.kind || e.Nation != tc.nation {
+				continue
+			}
+			found = true
+			if e.From != tc.from {
+				t.Errorf("%d: %s %d: from: want %d, got %d", tc.id, tc.kind, tc.nation, tc.from, e.From)
+			}
+			if !reflect.DeepEqual(e.Observers, tc.observers) {
+				t.Errorf("%d: %s %d: observers: want %v, got %v", tc.id, tc.kind, tc.nation, tc.observers, e.Observers)
+			}
+			break
+		}
+		if !found {
+			t.Errorf("%d: %s %d: want event, got none", tc.id, tc.kind, tc.nation)
+		}
+		// the battle still shows every nation that fought in it
+		if len(r.Battles) != 1 || !reflect.DeepEqual(r.Battles[0].Nations, []int{1, 2, 3}) {
+			t.Errorf("%d: battles: want nations [1 2 3], got %+v", tc.id, r.Battles)
+		}
+	}
+	// the events of the turn are not changed
+	if e := events[3]; e.From != 10 || !reflect.DeepEqual(e.Observers, []int{2}) {
+		t.Errorf("events: want unchanged, got %+v", e)
+	}
+}
+
+func TestNewContacts(t *testing.T) {
+	g, events := example(t)
+	v, err := g.View(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Red has a fleet in Blue's home system and remembers Green's
+	v.Fleets = append(v.Fleets, &engine.Fleet{Id: 90, Nation: 1, System: 4})
+	v.Colonies = append(v.Colonies, g.Colony(13))
+	v.Remembered = append(v.Remembered, &engine.Sighting{System: 10, Turn: 0, Colonies: []*engine.Colony{g.Colony(14)}})
+	r, err := reports.New(v, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []reports.ContactReport{
+		{Nation: 2, Seen: 1, System: reports.SystemRef{Id: 4, Name: "Beta"}, Colony: 13, Planet: 5, Population: g.Colony(13).Population},
+		{Nation: 3, Seen: 0, System: reports.SystemRef{Id: 10, Name: "Delta"}, Colony: 14, Planet: 11, Population: g.Colony(14).Population},
+	}
+	if !reflect.DeepEqual(r.Contacts, want) {
+		t.Errorf("contacts: want %+v, got %+v", want, r.Contacts)
+	}
+	buf := &bytes.Buffer{}
+	if err := r.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "colony 14 on planet 11, population 5500 (last seen on turn 0)"; !strings.Contains(buf.String(), want) {
+		t.Errorf("want %q in\n%s", want, buf.String())
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	g, events := example(t)
+	v, err := g.View(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := reports.New(v, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := r.WriteText(buf); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	for _, want := range []string{
+		"Example: turn 1 report for Red (nation 1)\n",
+		"Stockpile: 280\n",
+		"Colony 12 built 1 warship for fleet 29.\n",
+		"Sent 20 to nation 3.\n",
+		"    nation 1 lost ship 26 (warship)\n",
+		`Line 10, "research 500", wasn't carried out: only 20 production is left.` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q in\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package reports
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteText writes the report as plain text, for e-mail and printing.
+func (r *Report) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	tw := tabwriter.NewWriter(bw, 0, 4, 2, ' ', 0)
+	heading := func(title string) {
+		_ = tw.Flush()
+		_, _ = fmt.Fprintf(bw, "\n%s\n%s\n", title, strings.Repeat("-", len(title)))
+	}
+
+	title := fmt.Sprintf("%s: turn %d report for %s (nation %d)", r.GameName, r.Turn, r.Nation.Name, r.Nation.Id)
+	_, _ = fmt.Fprintf(bw, "%s\n%s\n", title, strings.Repeat("=", len(title)))
+	_, _ = fmt.Fprintf(bw, "Stockpile: %d\nResearch: %d (%d this turn)\n", r.Nation.Stockpile, r.Research.Total, r.Research.Spent)
+
+	heading("Colonies")
+	if len(r.Colonies) == 0 {
+		_, _ = fmt.Fprintln(bw, "None.")
+	} else {
+		_, _ = fmt.Fprintln(tw, "Colony\tPlanet\tSystem\tKind\tHab\tRes\tPopulation\tIndustry\tOutput")
+		for _, c := range r.Colonies {
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%d\t%d\t%d/%d\t%d\t%d\n", c.Id, c.Planet, c.System, c.Kind, c.Habitability, c.Resources, c.Population, c.Capacity, c.Industry, c.Output)
+		}
+	}
+
+	heading("Fleets")
+	if len(r.Fleets) == 0 {
+		_, _ = fmt.Fprintln(bw, "None.")
+	} else {
+		_, _ = fmt.Fprintln(tw, "Fleet\tSystem\tShips")
+		for _, f := range r.Fleets {
+			var ships []string
+			for _, s := range f.Ships {
+				ships = append(ships, fmt.Sprintf("%s %d (hull %d)", s.Class, s.Id, s.Hull))
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\n", f.Id, f.System, strings.Join(ships, ", "))
+		}
+	}
+
+	heading("Production")
+	_, _ = fmt.Fprintf(bw, "Produced: %d\n", r.Production.Produced)
+	for _, b := range r.Production.Built {
+		if b.Fleet != 0 {
+			_, _ = fmt.Fprintf(bw, "Colony %d built %d %s for fleet %d.\n", b.Colony, b.Amount, b.Product, b.Fleet)
+		} else {
+			_, _ = fmt.Fprintf(bw, "Colony %d built %d %s.\n", b.Colony, b.Amount, b.Product)
+		}
+	}
+	for _, t := range r.Production.Sent {
+		_, _ = fmt.Fprintf(bw, "Sent %d to nation %d.\n", t.Amount, t.Nation)
+	}
+	for _, t := range r.Production.Received {
+		_, _ = fmt.Fprintf(bw, "Received %d from nation %d.\n", t.Amount, t.Nation)
+	}
+
+	if len(r.Battles) != 0 {
+		heading("Battles")
+		for _, b := range r.Battles {
+			_, _ = fmt.Fprintf(bw, "%s: nations %s fought.\n", b.System, joinInts(b.Nations))
+			for _, l := range b.Losses {
+				_, _ = fmt.Fprintf(bw, "    nation %d lost ship %d (%s)\n", l.Nation, l.Ship, l.Class)
+			}
+		}
+	}
+
+	if len(r.Contacts) != 0 {
+		heading("Other Nations")
+		_, _ = fmt.Fprintln(tw, "Nation\tSystem\tSeen")
+		for _, c := range r.Contacts {
+			seen := c.seen()
+			if c.Seen < r.Turn {
+				seen += fmt.Sprintf(" (last seen on turn %d)", c.Seen)
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\n", c.Nation, c.System, seen)
+		}
+	}
+
+	if len(r.Notices) != 0 {
+		heading("Notices")
+		for _, n := range r.Notices {
+			_, _ = fmt.Fprintln(bw, n)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// seen describes the colony or fleet.
+func (c ContactReport) seen() string {
+	if c.Fleet == 0 {
+		return fmt.Sprintf("colony %d on planet %d, population %d", c.Colony, c.Planet, c.Population)
+	}
+	var classes []string
+	for class, n := range c.Ships {
+		classes = append(classes, fmt.Sprintf("%d %s", n, class))
+	}
+	sort.Strings(classes)
+	return fmt.Sprintf("fleet %d: %s", c.Fleet, strings.Join(classes, ", "))
+}
+
+func joinInts(list []int) string {
+	var s []string
+	for _, n := range list {
+		s = append(s, fmt.Sprint(n))
+	}
+	return strings.Join(s, ", ")
+}
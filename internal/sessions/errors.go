@@ -0,0 +1,19 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package sessions
+
+// Errors used by the package.
+const (
+	ErrExpiredToken     = constError("expired token")
+	ErrInvalidSignature = constError("invalid signature")
+	ErrInvalidToken     = constError("invalid token")
+	ErrMissingUser      = constError("missing user")
+	ErrSessionNotFound  = constError("session not found")
+)
+
+// declarations to support constant errors
+type constError string
+
+func (ce constError) Error() string {
+	return string(ce)
+}
@@ -0,0 +1,120 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package sessions
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewMemoryStore returns a Store that keeps sessions in memory.
+// Sessions are lost when the server restarts.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: make(map[string]Session),
+	}
+}
+
+// MemoryStore implements an in-memory Store.
+type MemoryStore struct {
+	sync.Mutex
+	data map[string]Session
+}
+
+// Create implements the Store interface.
+func (ms *MemoryStore) Create(userId, device, ip, state string, ttl time.Duration) (Session, error) {
+	s, err := newSession(userId, device, ip, state, ttl)
+	if err != nil {
+		return Session{}, err
+	}
+	return ms.save(s), nil
+}
+
+// Impersonate implements the Store interface.
+func (ms *MemoryStore) Impersonate(impersonatorId, userId, device, ip string, ttl time.Duration) (Session, error) {
+	s, err := newSession(userId, device, ip, StateActive, ttl)
+	if err != nil {
+		return Session{}, err
+	}
+	s.ImpersonatorId = impersonatorId
+	return ms.save(s), nil
+}
+
+func (ms *MemoryStore) save(s Session) Session {
+	ms.Lock()
+	defer ms.Unlock()
+	// purge expired sessions while we hold the lock
+	for k, v := range ms.data {
+		if s.CreatedAt.After(v.ExpiresAt) {
+			delete(ms.data, k)
+		}
+	}
+	ms.data[s.Id] = s
+	return s
+}
+
+// Lookup implements the Store interface.
+func (ms *MemoryStore) Lookup(id string) (Session, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	s, ok := ms.data[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	} else if time.Now().After(s.ExpiresAt) {
+		delete(ms.data, id)
+		return Session{}, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+// Touch implements the Store interface.
+func (ms *MemoryStore) Touch(id, ip string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	s, ok := ms.data[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.IP, s.LastSeenAt = truncate(ip, 64), time.Now().UTC()
+	ms.data[id] = s
+	return nil
+}
+
+// Revoke implements the Store interface.
+func (ms *MemoryStore) Revoke(id string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.data, id)
+	return nil
+}
+
+// RevokeUser implements the Store interface.
+func (ms *MemoryStore) RevokeUser(userId string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for k, v := range ms.data {
+		if v.UserId == userId {
+			delete(ms.data, k)
+		}
+	}
+	return nil
+}
+
+// UserSessions implements the Store interface.
+// Sessions are returned with the most recently seen first.
+func (ms *MemoryStore) UserSessions(userId string) ([]Session, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	now := time.Now()
+	var list []Session
+	for _, v := range ms.data {
+		if v.UserId == userId && now.Before(v.ExpiresAt) {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeenAt.After(list[j].LastSeenAt)
+	})
+	return list, nil
+}
@@ -0,0 +1,94 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package sessions_test
+
+import (
+	"errors"
+	"github.com/mdhender/wraithi/internal/sessions"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ms := sessions.NewMemoryStore()
+	s1, err := ms.Create("u1", "browser", "10.0.0.1", sessions.StateActive, time.Hour)
+	if err != nil {
+		t.Fatalf("create: want nil, got %v", err)
+	}
+	s2, err := ms.Create("u1", "phone", "10.0.0.2", sessions.StatePendingMFA, time.Hour)
+	if err != nil {
+		t.Fatalf("create: want nil, got %v", err)
+	}
+	s3, err := ms.Impersonate("u9", "u2", "browser", "10.0.0.9", time.Hour)
+	if err != nil {
+		t.Fatalf("impersonate: want nil, got %v", err)
+	}
+	if s1.Id == s2.Id || s1.Id == s3.Id {
+		t.Fatalf("create: want distinct ids, got %q, %q, %q", s1.Id, s2.Id, s3.Id)
+	}
+
+	for _, tc := range []struct {
+		id             int
+		sessionId      string
+		userId         string
+		state          string
+		impersonatorId string
+	}{
+		{1, s1.Id, "u1", sessions.StateActive, ""},
+		{2, s2.Id, "u1", sessions.StatePendingMFA, ""},
+		{3, s3.Id, "u2", sessions.StateActive, "u9"},
+	} {
+		got, err := ms.Lookup(tc.sessionId)
+		if err != nil {
+			t.Fatalf("%d: lookup: want nil, got %v", tc.id, err)
+		} else if got.UserId != tc.userId || got.State != tc.state || got.ImpersonatorId != tc.impersonatorId {
+			t.Errorf("%d: lookup: want %s/%s/%q, got %s/%s/%q", tc.id, tc.userId, tc.state, tc.impersonatorId, got.UserId, got.State, got.ImpersonatorId)
+		}
+	}
+	if _, err := ms.Lookup("no-such-session"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("lookup: unknown: want %v, got %v", sessions.ErrSessionNotFound, err)
+	}
+
+	// revoking one session leaves the user's other sessions alone
+	if err := ms.Revoke(s1.Id); err != nil {
+		t.Fatalf("revoke: want nil, got %v", err)
+	}
+	if _, err := ms.Lookup(s1.Id); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("revoke: lookup: want %v, got %v", sessions.ErrSessionNotFound, err)
+	}
+	if _, err := ms.Lookup(s2.Id); err != nil {
+		t.Errorf("revoke: other session: want nil, got %v", err)
+	}
+
+	// revoking a user ends all of their sessions and nobody else's
+	if _, err := ms.Create("u1", "tablet", "10.0.0.3", sessions.StateActive, time.Hour); err != nil {
+		t.Fatalf("create: want nil, got %v", err)
+	}
+	if err := ms.RevokeUser("u1"); err != nil {
+		t.Fatalf("revoke user: want nil, got %v", err)
+	}
+	if list, err := ms.UserSessions("u1"); err != nil {
+		t.Fatalf("revoke user: sessions: want nil, got %v", err)
+	} else if len(list) != 0 {
+		t.Errorf("revoke user: sessions: want 0, got %d", len(list))
+	}
+	if _, err := ms.Lookup(s3.Id); err != nil {
+		t.Errorf("revoke user: other user: want nil, got %v", err)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ms := sessions.NewMemoryStore()
+	s, err := ms.Create("u1", "browser", "10.0.0.1", sessions.StateActive, -time.Minute)
+	if err != nil {
+		t.Fatalf("create: want nil, got %v", err)
+	}
+	if _, err := ms.Lookup(s.Id); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("lookup: want %v, got %v", sessions.ErrSessionNotFound, err)
+	}
+	if list, err := ms.UserSessions("u1"); err != nil {
+		t.Fatalf("sessions: want nil, got %v", err)
+	} else if len(list) != 0 {
+		t.Errorf("sessions: want 0, got %d", len(list))
+	}
+}
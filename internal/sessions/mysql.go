@@ -0,0 +1,116 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// NewMySQLStore returns a Store that keeps sessions in the `sessions` table.
+func NewMySQLStore(ctx context.Context, db *sql.DB) *MySQLStore {
+	return &MySQLStore{
+		context: ctx,
+		db:      db,
+	}
+}
+
+// MySQLStore implements a Store backed by a MySQL database.
+type MySQLStore struct {
+	context context.Context
+	db      *sql.DB
+}
+
+// Create implements the Store interface.
+func (ms *MySQLStore) Create(userId, device, ip, state string, ttl time.Duration) (Session, error) {
+	s, err := newSession(userId, device, ip, state, ttl)
+	if err != nil {
+		return Session{}, err
+	}
+	return s, ms.save(s)
+}
+
+// Impersonate implements the Store interface.
+func (ms *MySQLStore) Impersonate(impersonatorId, userId, device, ip string, ttl time.Duration) (Session, error) {
+	s, err := newSession(userId, device, ip, StateActive, ttl)
+	if err != nil {
+		return Session{}, err
+	}
+	s.ImpersonatorId = impersonatorId
+	return s, ms.save(s)
+}
+
+func (ms *MySQLStore) save(s Session) error {
+	// purge expired sessions so the table doesn't grow forever
+	if _, err := ms.db.ExecContext(ms.context, "delete from sessions where expires_at < ?", s.CreatedAt); err != nil {
+		return err
+	}
+	_, err := ms.db.ExecContext(ms.context,
+		"insert into sessions (id, user_id, state, device, ip, created_at, last_seen_at, expires_at, impersonator_id) values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.Id, s.UserId, s.State, s.Device, s.IP, s.CreatedAt, s.LastSeenAt, s.ExpiresAt, sql.NullString{String: s.ImpersonatorId, Valid: s.ImpersonatorId != ""})
+	return err
+}
+
+// Lookup implements the Store interface.
+func (ms *MySQLStore) Lookup(id string) (Session, error) {
+	var s Session
+	var impersonatorId sql.NullString
+	row := ms.db.QueryRowContext(ms.context,
+		"select id, user_id, state, device, ip, created_at, last_seen_at, expires_at, impersonator_id from sessions where id = ? and expires_at > ?",
+		id, time.Now().UTC())
+	if err := row.Scan(&s.Id, &s.UserId, &s.State, &s.Device, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &impersonatorId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, err
+	}
+	s.ImpersonatorId = impersonatorId.String
+	return s, nil
+}
+
+// Touch implements the Store interface.
+func (ms *MySQLStore) Touch(id, ip string) error {
+	_, err := ms.db.ExecContext(ms.context,
+		"update sessions set ip = ?, last_seen_at = ? where id = ?",
+		truncate(ip, 64), time.Now().UTC(), id)
+	return err
+}
+
+// Revoke implements the Store interface.
+func (ms *MySQLStore) Revoke(id string) error {
+	_, err := ms.db.ExecContext(ms.context, "delete from sessions where id = ?", id)
+	return err
+}
+
+// RevokeUser implements the Store interface.
+func (ms *MySQLStore) RevokeUser(userId string) error {
+	_, err := ms.db.ExecContext(ms.context, "delete from sessions where user_id = ?", userId)
+	return err
+}
+
+// UserSessions implements the Store interface.
+// Sessions are returned with the most recently seen first.
+func (ms *MySQLStore) UserSessions(userId string) ([]Session, error) {
+	rows, err := ms.db.QueryContext(ms.context,
+		"select id, user_id, state, device, ip, created_at, last_seen_at, expires_at, impersonator_id from sessions where user_id = ? and expires_at > ? order by last_seen_at desc",
+		userId, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []Session
+	for rows.Next() {
+		var s Session
+		var impersonatorId sql.NullString
+		if err := rows.Scan(&s.Id, &s.UserId, &s.State, &s.Device, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &impersonatorId); err != nil {
+			return nil, err
+		}
+		s.ImpersonatorId = impersonatorId.String
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,127 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package sessions
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Token is the verified contents of a session token.
+type Token struct {
+	SessionId string
+	UserId    string
+	Handle    string
+	Roles     []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// claims is the wire format of a Token.
+type claims struct {
+	SessionId string   `json:"sid"`
+	UserId    string   `json:"uid"`
+	Handle    string   `json:"hdl,omitempty"`
+	Roles     []string `json:"rol,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// NewSigner returns a Signer that issues tokens that are valid for the given ttl.
+// The key is used to sign and verify tokens; changing it invalidates every token.
+func NewSigner(key string, ttl time.Duration) *Signer {
+	return &Signer{
+		key: []byte(key),
+		ttl: ttl,
+	}
+}
+
+// Signer issues and verifies tamper-proof session tokens.
+// A token is the base64 encoded claims followed by a dot and the
+// base64 encoded HMAC-SHA256 signature of the encoded claims.
+type Signer struct {
+	key []byte
+	ttl time.Duration // time-to-live for each token
+}
+
+// Issue returns a signed token for the user's session.
+func (s *Signer) Issue(sessionId, userId, handle string, roles []string) (string, Token, error) {
+	if userId == "" {
+		return "", Token{}, ErrMissingUser
+	}
+	now := time.Now().UTC()
+	t := Token{
+		SessionId: sessionId,
+		UserId:    userId,
+		Handle:    handle,
+		Roles:     roles,
+		IssuedAt:  now.Truncate(time.Second),
+		ExpiresAt: now.Add(s.ttl).Truncate(time.Second),
+	}
+	data, err := json.Marshal(claims{
+		SessionId: t.SessionId,
+		UserId:    t.UserId,
+		Handle:    t.Handle,
+		Roles:     t.Roles,
+		IssuedAt:  t.IssuedAt.Unix(),
+		ExpiresAt: t.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", Token{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload)), t, nil
+}
+
+// Verify checks the signature and expiration of the token and returns its contents.
+func (s *Signer) Verify(token string) (Token, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || payload == "" || signature == "" {
+		return Token{}, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	} else if !hmac.Equal(sig, s.sign(payload)) {
+		return Token{}, ErrInvalidSignature
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+	var c claims
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil || c.UserId == "" {
+		return Token{}, ErrInvalidToken
+	}
+	t := Token{
+		SessionId: c.SessionId,
+		UserId:    c.UserId,
+		Handle:    c.Handle,
+		Roles:     c.Roles,
+		IssuedAt:  time.Unix(c.IssuedAt, 0).UTC(),
+		ExpiresAt: time.Unix(c.ExpiresAt, 0).UTC(),
+	}
+	if !time.Now().Before(t.ExpiresAt) {
+		return Token{}, ErrExpiredToken
+	}
+	return t, nil
+}
+
+// TTL returns the time-to-live for tokens issued by the Signer.
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// sign returns the HMAC-SHA256 signature of the payload.
+func (s *Signer) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,85 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package sessions_test
+
+import (
+	"errors"
+	"github.com/mdhender/wraithi/internal/sessions"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := sessions.NewSigner("the-key", time.Hour)
+	token, issued, err := s.Issue("s1", "u1", "alice", []string{"admin", "player"})
+	if err != nil {
+		t.Fatalf("issue: want nil, got %v", err)
+	}
+	got, err := s.Verify(token)
+	if err != nil {
+		t.Fatalf("verify: want nil, got %v", err)
+	}
+	if got.SessionId != "s1" || got.UserId != "u1" || got.Handle != "alice" {
+		t.Errorf("verify: want s1/u1/alice, got %s/%s/%s", got.SessionId, got.UserId, got.Handle)
+	}
+	if strings.Join(got.Roles, ",") != "admin,player" {
+		t.Errorf("verify: roles: want admin,player, got %v", got.Roles)
+	}
+	if !got.IssuedAt.Equal(issued.IssuedAt) || !got.ExpiresAt.Equal(issued.ExpiresAt) {
+		t.Errorf("verify: want %v..%v, got %v..%v", issued.IssuedAt, issued.ExpiresAt, got.IssuedAt, got.ExpiresAt)
+	}
+
+	if _, _, err := s.Issue("s1", "", "alice", nil); !errors.Is(err, sessions.ErrMissingUser) {
+		t.Errorf("issue: no user: want %v, got %v", sessions.ErrMissingUser, err)
+	}
+}
+
+func TestSignerRejects(t *testing.T) {
+	s := sessions.NewSigner("the-key", time.Hour)
+	token, _, err := s.Issue("s1", "u1", "alice", []string{"player"})
+	if err != nil {
+		t.Fatalf("issue: want nil, got %v", err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+
+	// a token for another user, signed with the same key
+	other, _, err := s.Issue("s2", "u2", "bob", []string{"admin"})
+	if err != nil {
+		t.Fatalf("issue: want nil, got %v", err)
+	}
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	// a token that has already expired
+	expired, _, err := sessions.NewSigner("the-key", -time.Minute).Issue("s1", "u1", "alice", nil)
+	if err != nil {
+		t.Fatalf("issue: want nil, got %v", err)
+	}
+
+	// a token signed with another key
+	wrongKey, _, err := sessions.NewSigner("not-the-key", time.Hour).Issue("s1", "u1", "alice", nil)
+	if err != nil {
+		t.Fatalf("issue: want nil, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		id    int
+		token string
+		want  error
+	}{
+		{1, "", sessions.ErrInvalidToken},
+		{2, payload, sessions.ErrInvalidToken},
+		{3, payload + ".", sessions.ErrInvalidToken},
+		{4, "." + signature, sessions.ErrInvalidToken},
+		{5, payload + ".!!!", sessions.ErrInvalidToken},
+		{6, otherPayload + "." + signature, sessions.ErrInvalidSignature},
+		{7, payload + "x." + signature, sessions.ErrInvalidSignature},
+		{8, payload + "." + signature[1:], sessions.ErrInvalidSignature},
+		{9, expired, sessions.ErrExpiredToken},
+		{10, wrongKey, sessions.ErrInvalidSignature},
+	} {
+		if _, err := s.Verify(tc.token); !errors.Is(err, tc.want) {
+			t.Errorf("%d: want %v, got %v", tc.id, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Session states.
+const (
+	// StateActive is a fully authenticated session.
+	StateActive = "active"
+	// StatePendingMFA is a session that has passed the first factor (a password
+	// or a provider) but must still present a second factor before it is active.
+	StatePendingMFA = "pending-mfa"
+)
+
+// Session is a server-side record of a signed-in browser or client.
+type Session struct {
+	Id         string // opaque identifier, carried in the session token
+	UserId     string
+	State      string // one of the State constants
+	Device     string // user agent that created the session
+	IP         string // address of the most recent request
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// ImpersonatorId is the real user when an administrator is acting as UserId.
+	// It is empty for normal sessions.
+	ImpersonatorId string
+}
+
+// Store is the interface for persisting sessions.
+// Lookup must not return sessions that have been revoked or have expired.
+type Store interface {
+	Create(userId, device, ip, state string, ttl time.Duration) (Session, error)
+	Impersonate(impersonatorId, userId, device, ip string, ttl time.Duration) (Session, error)
+	Lookup(id string) (Session, error)
+	Touch(id, ip string) error
+	Revoke(id string) error
+	RevokeUser(userId string) error
+	UserSessions(userId string) ([]Session, error)
+}
+
+// newSession returns a new session that hasn't been saved.
+func newSession(userId, device, ip, state string, ttl time.Duration) (Session, error) {
+	id, err := newSessionId()
+	if err != nil {
+		return Session{}, err
+	}
+	now := time.Now().UTC()
+	return Session{
+		Id:         id,
+		UserId:     userId,
+		State:      state,
+		Device:     truncate(device, 255),
+		IP:         truncate(ip, 64),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}, nil
+}
+
+// newSessionId returns a new opaque session id.
+func newSessionId() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// truncate limits the length of strings saved in the store.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
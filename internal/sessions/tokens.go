@@ -1,5 +1,6 @@
 // wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
 
+// Package sessions implements session tokens for authenticated users.
 package sessions
 
 import (
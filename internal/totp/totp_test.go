@@ -0,0 +1,58 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// TestCode uses the SHA1 test vectors from RFC 6238 appendix B,
+// truncated to six digits.
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		got, err := Code(secret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("%d: %v", tc.unix, err)
+		} else if got != tc.want {
+			t.Errorf("%d: want %q, got %q", tc.unix, tc.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := Code(secret, Step(now))
+
+	step, ok := Validate(secret, code, now, 0)
+	if !ok || step != Step(now) {
+		t.Fatalf("validate: want step %d, got %d %v", Step(now), step, ok)
+	}
+	// the same code can't be used twice
+	if _, ok := Validate(secret, code, now, step); ok {
+		t.Errorf("validate: accepted a replayed code")
+	}
+	// codes from one period ago are accepted, but not from two
+	if _, ok := Validate(secret, code, now.Add(Period*time.Second), 0); !ok {
+		t.Errorf("validate: rejected code within skew")
+	}
+	if _, ok := Validate(secret, code, now.Add(2*Period*time.Second), 0); ok {
+		t.Errorf("validate: accepted code outside skew")
+	}
+}
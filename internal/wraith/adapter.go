@@ -4,12 +4,15 @@ package wraith
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/mdhender/wraithi/internal/rbac"
 	"github.com/mdhender/wraithi/internal/sessions"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // PlainOldHandler is a simple http.Handler function.
@@ -75,37 +78,64 @@ func anExample() {
 // userContextKey is the context key type for storing User in context.Context.
 type userContextKey string
 
-func (a *App) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+func (a *App) mustAuth() Adapter {
 	nfh := a.notFound()
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !a.currentUser(r).IsAdmin() {
-			nfh(w, r)
-			return
-		}
-		h.ServeHTTP(w, r)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.currentUser(r).IsAuthenticated() {
+				nfh(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
 	}
 }
 
-func (a *App) authOnly(h http.HandlerFunc) http.HandlerFunc {
+// requirePermission allows users who have been granted the permission.
+// Everyone else gets the not found page, so we don't leak which routes exist.
+func (a *App) requirePermission(perm string) Adapter {
 	nfh := a.notFound()
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !a.currentUser(r).IsAuthenticated() {
-			nfh(w, r)
-			return
-		}
-		h.ServeHTTP(w, r)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u := a.currentUser(r); !u.Can(perm) {
+				log.Printf("%s %s: %q: missing permission %q\n", r.Method, r.URL, u.Id(), perm)
+				nfh(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
 	}
 }
 
-func (a *App) mustAuth() Adapter {
+// requireGameRole loads the game named by the :game route parameter
+// and allows users who have one of the roles in that game.
+// Users with the game.admin permission are allowed into every game.
+// The game and the user's membership are saved in the request's Context.
+func (a *App) requireGameRole(roles ...string) Adapter {
 	nfh := a.notFound()
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !a.currentUser(r).IsAuthenticated() {
+			u := a.currentUser(r)
+			game, err := a.db.GameById(gameParam(r))
+			if errors.Is(err, ErrNotFound) {
 				nfh(w, r)
 				return
+			} else if err != nil {
+				a.internalError(w, r, err)
+				return
 			}
-			h.ServeHTTP(w, r)
+			member, err := a.db.GameMember(game.Id, u.Id())
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				a.internalError(w, r, err)
+				return
+			}
+			if !member.HasRole(roles...) && !u.Can(rbac.GameAdmin) {
+				log.Printf("%s %s: %q: missing game role %v\n", r.Method, r.URL, u.Id(), roles)
+				nfh(w, r)
+				return
+			}
+			ctx := context.WithValue(r.Context(), gameContextKey("game"), gameContext{game: game, member: member})
+			h.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
@@ -125,15 +155,40 @@ func withNoop(h http.Handler) http.Handler {
 // and then in a session cookie. If a valid token is found, the User
 // returned will have the appropriated roles added. If not, then the
 // "guest" User and its roles are used.
+// The User's permissions come from its roles. A personal API token
+// in the Bearer Token header gives a User with only the permissions
+// that are both held by the owner and granted to the token.
 func (a *App) withUser(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var u User
 		// try to fetch the user from the request
 		if token := sessions.FromRequest(r, a.cookies.name); token == "" {
 			// the anonymous user
-		} else {
-			u.id, u.handle = "admin", "admin"
-			u.roles = []string{"authenticated", "admin"}
+		} else if pat, ok := bearerAPIToken(r); ok {
+			var err error
+			if u, err = a.userForAPIToken(r, pat); err != nil {
+				// the token is unknown, revoked, or expired, or its owner is disabled
+				log.Printf("%s %s: withUser: api token: %v\n", r.Method, r.URL, err)
+			}
+		} else if t, err := a.sessions.signer.Verify(token); err != nil {
+			log.Printf("%s %s: withUser: %v\n", r.Method, r.URL, err)
+		} else if session, err := a.sessions.store.Lookup(t.SessionId); err != nil {
+			// the session has been revoked or has expired
+			log.Printf("%s %s: withUser: %v\n", r.Method, r.URL, err)
+		} else if session.UserId == t.UserId && session.State == sessions.StatePendingMFA {
+			// still anonymous until the second factor is presented
+			u.pendingUserId, u.sessionId = t.UserId, session.Id
+		} else if session.UserId == t.UserId && session.State == sessions.StateActive {
+			u.id, u.handle, u.sessionId = t.UserId, t.Handle, session.Id
+			u.roles = append([]string{rbac.Authenticated}, t.Roles...)
+			u.perms = a.rbac.Permissions(u.roles...)
+			u.impersonatorId = session.ImpersonatorId
+			// only update the last-seen time every so often to limit writes to the store
+			if ip := clientIP(r); ip != session.IP || time.Since(session.LastSeenAt) > time.Minute {
+				if err := a.sessions.store.Touch(session.Id, ip); err != nil {
+					log.Printf("%s %s: withUser: touch: %v\n", r.Method, r.URL, err)
+				}
+			}
 		}
 		// log.Printf("%s %s: user %q\n", r.Method, r.URL, u.handle)
 		ctx := context.WithValue(r.Context(), userContextKey("user"), u)
@@ -142,6 +197,16 @@ func (a *App) withUser(h http.Handler) http.Handler {
 	})
 }
 
+// bearerAPIToken returns the personal API token in the Bearer Token header.
+// It returns false if the header is missing or holds some other kind of token.
+func bearerAPIToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token = strings.TrimSpace(token); !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+		return "", false
+	}
+	return token, true
+}
+
 //// withUserFunc injects a User into the request's Context.
 //// It searches for a session token in the Bearer Token header first,
 //// and then in a session cookie. If a valid token is found, the User
@@ -165,28 +230,34 @@ func (a *App) withUser(h http.Handler) http.Handler {
 //}
 
 type User struct {
-	id     string
-	handle string
-	roles  []string
+	id        string
+	handle    string
+	roles     []string
+	perms     map[string]bool // permissions granted by the roles
+	sessionId string          // the session the user authenticated with
+	// impersonatorId is set when an administrator is acting as the user.
+	// The user is the effective user; the impersonator is the real one.
+	impersonatorId string
+	// pendingUserId is set when the session has passed the first factor
+	// but not the second. The user is anonymous until then.
+	pendingUserId string
+	// tokenId is set when the user authenticated with a
+	// personal API token instead of a session.
+	tokenId string
 }
 
-func (u User) HasRole(roles ...string) bool {
-	for _, want := range roles {
-		for _, got := range u.roles {
-			if got == want {
-				return true
-			}
-		}
-	}
-	return false
+// Can returns true if the user has been granted the permission.
+func (u User) Can(perm string) bool {
+	return u.perms[perm]
 }
 
 func (u User) Id() string {
 	return u.id
 }
 
-func (u User) IsAdmin() bool {
-	return u.HasRole("admin")
+// IsImpersonated returns true if an administrator is acting as the user.
+func (u User) IsImpersonated() bool {
+	return u.impersonatorId != ""
 }
 
 func (u User) IsAnonymous() bool {
@@ -194,11 +265,7 @@ func (u User) IsAnonymous() bool {
 }
 
 func (u User) IsAuthenticated() bool {
-	return u.HasRole("authenticated")
-}
-
-func (u User) IsGuest() bool {
-	return u.HasRole("guest")
+	return u.id != ""
 }
 
 // currentUser returns the User from the request's Context.
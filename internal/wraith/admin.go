@@ -0,0 +1,452 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/lockout"
+	"github.com/mdhender/wraithi/internal/rbac"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usersPerPage is the number of users on each page of the console.
+const usersPerPage = 25
+
+// SearchUsers returns a page of users whose handle or e-mail contains the query,
+// along with the number of users that match.
+func (db *DB) SearchUsers(query string, offset, limit int) ([]UserRecord, int, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSpace(query)) + "%"
+	var total int
+	if err := db.db.QueryRowContext(db.context,
+		"select count(*) from users where handle like ? or email like ?",
+		pattern, pattern).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.db.QueryContext(db.context,
+		"select id, handle, email, email_verified, totp_enabled, disabled_at, created_at from users where handle like ? or email like ? order by handle limit ? offset ?",
+		pattern, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []UserRecord
+	for rows.Next() {
+		var u UserRecord
+		var email sql.NullString
+		var disabledAt sql.NullTime
+		if err := rows.Scan(&u.Id, &u.Handle, &email, &u.EmailVerified, &u.TOTPEnabled, &disabledAt, &u.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		u.Email, u.Disabled = email.String, disabledAt.Valid
+		list = append(list, u)
+	}
+	return list, total, rows.Err()
+}
+
+// SetUserDisabled disables or re-enables the user's account.
+// Disabled users can't sign in and their API tokens stop working.
+func (db *DB) SetUserDisabled(id string, disabled bool) error {
+	disabledAt := sql.NullTime{Time: time.Now().UTC(), Valid: disabled}
+	_, err := db.db.ExecContext(db.context, "update users set disabled_at = ? where id = ?", disabledAt, id)
+	return err
+}
+
+// SetUserRoles replaces the roles granted to the user.
+func (db *DB) SetUserRoles(id string, roles []string) error {
+	tx, err := db.db.BeginTx(db.context, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.ExecContext(db.context, "delete from user_roles where user_id = ?", id); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if _, err := tx.ExecContext(db.context, "insert into user_roles (user_id, role) values (?, ?)", id, role); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteUser deletes the user.
+// Identities, tokens, roles, and game memberships are deleted with it.
+func (db *DB) DeleteUser(id string) error {
+	result, err := db.db.ExecContext(db.context, "delete from users where id = ?", id)
+	if err != nil {
+		return err
+	} else if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UsersData is the data for the user management console.
+type UsersData struct {
+	Query string
+	Users []UserRowData
+	Total int
+	Page  int
+	Pages int
+	Prev  int // zero if there is no previous page
+	Next  int // zero if there is no next page
+}
+
+// UserRowData is the data for one user in the console's list.
+type UserRowData struct {
+	Id          string
+	Handle      string
+	Email       string
+	Disabled    bool
+	TOTPEnabled bool
+	CreatedAt   string
+}
+
+// UserAdminData is the data for the console's page for a single user.
+type UserAdminData struct {
+	Id            string
+	Handle        string
+	Email         string
+	EmailVerified bool
+	Disabled      bool
+	TOTPEnabled   bool
+	CreatedAt     string
+	IsSelf        bool   // administrators can't disable, delete, or change the roles of their own account
+	Failures      int    // recent failed sign-ins
+	LockedUntil   string // empty if the account isn't locked out
+	Roles         []RoleData
+	Identities    []IdentityData
+	Sessions      []SessionData
+	Games         []GameData
+	Message       string
+	Error         string
+}
+
+// RoleData is the data for a role that can be granted to a user.
+type RoleData struct {
+	Name    string
+	Granted bool
+}
+
+// getUsers is the user management console.
+// Searches from the console are htmx requests that only replace the list.
+func (a *App) getUsers() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "users")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getUsers: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		content := UsersData{Query: strings.TrimSpace(r.FormValue("q")), Page: 1}
+		if n, err := strconv.Atoi(r.FormValue("page")); err == nil && n > 1 {
+			content.Page = n
+		}
+		list, total, err := a.db.SearchUsers(content.Query, (content.Page-1)*usersPerPage, usersPerPage)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		content.Total, content.Pages = total, (total+usersPerPage-1)/usersPerPage
+		if content.Page > 1 {
+			content.Prev = content.Page - 1
+		}
+		if content.Page < content.Pages {
+			content.Next = content.Page + 1
+		}
+		for _, u := range list {
+			content.Users = append(content.Users, UserRowData{
+				Id:          u.Id,
+				Handle:      u.Handle,
+				Email:       u.Email,
+				Disabled:    u.Disabled,
+				TOTPEnabled: u.TOTPEnabled,
+				CreatedAt:   u.CreatedAt.Format(a.timestampFormat),
+			})
+		}
+		if r.Header.Get("Hx-Target") == "user-list" {
+			t.renderPartial(w, r, "user_list", content)
+			return
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = "Users"
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Lockouts", Url: "/lockouts"},
+			{Text: "Profile", Url: fmt.Sprintf("/users/%s", a.currentUser(r).Id())},
+			{Text: "Documentation", Url: "/docs"},
+			{Text: "Sign Out", Url: "/signout"},
+		}}
+		if a.currentUser(r).Can(rbac.InviteCreate) {
+			payload.Site.NavBar.Links = append([]LinkData{{Text: "Invitations", Url: "/invites"}}, payload.Site.NavBar.Links...)
+		}
+		if a.currentUser(r).Can(rbac.AuditRead) {
+			payload.Site.NavBar.Links = append([]LinkData{{Text: "Audit log", Url: "/audit"}}, payload.Site.NavBar.Links...)
+		}
+		t.render(w, r, payload)
+	}
+}
+
+// getUsersIdAdmin is the console's page for a single user.
+func (a *App) getUsersIdAdmin() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "user_admin")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getUsersIdAdmin: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, err := a.userAdminData(r, way.Param(r.Context(), "id"))
+		if errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = content.Handle
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Users", Url: "/users"},
+			{Text: "Profile", Url: fmt.Sprintf("/users/%s", a.currentUser(r).Id())},
+			{Text: "Sign Out", Url: "/signout"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// postUsersIdAdminDelete deletes the user.
+func (a *App) postUsersIdAdminDelete() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if id == a.currentUser(r).Id() {
+			a.adminDone(w, r, id, "", "You can't delete your own account.")
+			return
+		}
+		// the session store may not be in the database, so revoke sessions explicitly
+		if err := a.sessions.store.RevokeUser(id); err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err := a.db.DeleteUser(id); errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q deleted user %q\n", r.Method, r.URL, a.currentUser(r).Id(), id)
+		a.audit(r, audit.AdminDelete, audit.TargetUser, id, "")
+		if r.Header.Get("Hx-Request") == "true" {
+			w.Header().Set("HX-Redirect", "/users")
+			return
+		}
+		http.Redirect(w, r, "/users", http.StatusSeeOther)
+	}
+}
+
+// postUsersIdAdminDisable disables the user's account and signs them out everywhere.
+func (a *App) postUsersIdAdminDisable() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if id == a.currentUser(r).Id() {
+			a.adminDone(w, r, id, "", "You can't disable your own account.")
+			return
+		}
+		if _, err := a.db.UserById(id); errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		if err := a.db.SetUserDisabled(id, true); err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err := a.sessions.store.RevokeUser(id); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q disabled user %q\n", r.Method, r.URL, a.currentUser(r).Id(), id)
+		a.audit(r, audit.AdminDisable, audit.TargetUser, id, "")
+		a.adminDone(w, r, id, "The account is disabled.", "")
+	}
+}
+
+// postUsersIdAdminEnable re-enables the user's account.
+func (a *App) postUsersIdAdminEnable() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if _, err := a.db.UserById(id); errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		if err := a.db.SetUserDisabled(id, false); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q enabled user %q\n", r.Method, r.URL, a.currentUser(r).Id(), id)
+		a.audit(r, audit.AdminEnable, audit.TargetUser, id, "")
+		a.adminDone(w, r, id, "The account is enabled.", "")
+	}
+}
+
+// postUsersIdAdminRoles replaces the user's roles.
+// Roles are copied into session tokens, so the user's sessions are revoked
+// to make the change take effect.
+func (a *App) postUsersIdAdminRoles() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if id == a.currentUser(r).Id() {
+			a.adminDone(w, r, id, "", "You can't change your own roles.")
+			return
+		}
+		if _, err := a.db.UserById(id); errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		var roles []string
+		for _, role := range r.PostForm["role"] {
+			if !a.grantableRole(role) {
+				a.adminDone(w, r, id, "", fmt.Sprintf("%q is not a role.", role))
+				return
+			}
+			roles = append(roles, role)
+		}
+		if err := a.db.SetUserRoles(id, roles); err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err := a.sessions.store.RevokeUser(id); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q set roles of %q to %v\n", r.Method, r.URL, a.currentUser(r).Id(), id, roles)
+		a.audit(r, audit.RoleChange, audit.TargetUser, id, strings.Join(roles, ","))
+		a.adminDone(w, r, id, "The roles are saved. The user must sign in again.", "")
+	}
+}
+
+// adminDone finishes a console action.
+// htmx requests get the user's section of the page; others are sent back to the page.
+func (a *App) adminDone(w http.ResponseWriter, r *http.Request, id, message, problem string) {
+	if r.Header.Get("Hx-Request") != "true" {
+		http.Redirect(w, r, fmt.Sprintf("/users/%s/admin", id), http.StatusSeeOther)
+		return
+	}
+	t, err := a.newTemplate("user_admin")
+	if err != nil {
+		a.internalError(w, r, err)
+		return
+	}
+	content, err := a.userAdminData(r, id)
+	if err != nil {
+		a.internalError(w, r, err)
+		return
+	}
+	content.Message, content.Error = message, problem
+	t.renderPartial(w, r, "user_admin", content)
+}
+
+// grantableRole returns true if the role can be granted to a user.
+// The authenticated role is implied and can't be granted.
+func (a *App) grantableRole(role string) bool {
+	if role == rbac.Authenticated {
+		return false
+	}
+	for _, r := range a.rbac.Roles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) userAdminData(r *http.Request, id string) (UserAdminData, error) {
+	rec, err := a.db.UserById(id)
+	if err != nil {
+		return UserAdminData{}, err
+	}
+	content := UserAdminData{
+		Id:            rec.Id,
+		Handle:        rec.Handle,
+		Email:         rec.Email,
+		EmailVerified: rec.EmailVerified,
+		Disabled:      rec.Disabled,
+		TOTPEnabled:   rec.TOTPEnabled,
+		CreatedAt:     rec.CreatedAt.Format(a.timestampFormat),
+		IsSelf:        rec.Id == a.currentUser(r).Id(),
+	}
+	now := time.Now()
+	if c, err := a.lockout.Get(now, lockout.Account(rec.Id)); err != nil {
+		return content, err
+	} else if content.Failures = c.Failures; c.IsLocked(now) {
+		content.LockedUntil = c.LockedUntil.UTC().Format(a.timestampFormat)
+	}
+	granted := make(map[string]bool)
+	for _, role := range rec.Roles {
+		granted[role] = true
+	}
+	for _, role := range a.rbac.Roles() {
+		if role != rbac.Authenticated {
+			content.Roles = append(content.Roles, RoleData{Name: role, Granted: granted[role]})
+		}
+	}
+	identities, err := a.db.UserIdentities(rec.Id)
+	if err != nil {
+		return content, err
+	}
+	for _, i := range identities {
+		content.Identities = append(content.Identities, IdentityData{
+			Provider: i.Provider,
+			Name:     i.Name,
+			Email:    i.Email,
+			LinkedAt: i.CreatedAt.Format(a.timestampFormat),
+		})
+	}
+	list, err := a.sessions.store.UserSessions(rec.Id)
+	if err != nil {
+		return content, err
+	}
+	for _, s := range list {
+		content.Sessions = append(content.Sessions, SessionData{
+			Id:         s.Id,
+			Device:     s.Device,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt.Format(a.timestampFormat),
+			LastSeenAt: s.LastSeenAt.Format(a.timestampFormat),
+		})
+	}
+	games, members, err := a.db.UserGames(rec.Id)
+	if err != nil {
+		return content, err
+	}
+	for i, g := range games {
+		content.Games = append(content.Games, a.userGameData(g, members[i]))
+	}
+	return content, nil
+}
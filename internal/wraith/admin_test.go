@@ -0,0 +1,58 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAdminConsole(t *testing.T) {
+	a, db := newTestApp(t)
+	now := time.Now().UTC()
+	answerUsers(db,
+		UserRecord{Id: "u-admin", Handle: "admin", Roles: []string{"admin"}, CreatedAt: now},
+		UserRecord{Id: "u-bob", Handle: "bob", Disabled: true, CreatedAt: now},
+	)
+	admin := signIn(t, a, "u-admin", "admin", "admin")
+	bob := signIn(t, a, "u-bob", "bob")
+
+	for _, tc := range []struct {
+		id      int
+		method  string
+		target  string
+		form    url.Values
+		session *testSession
+		want    int
+	}{
+		{1, http.MethodGet, "/users", nil, admin, http.StatusOK},
+		{2, http.MethodGet, "/users", nil, bob, http.StatusNotFound},
+		{3, http.MethodGet, "/users", nil, nil, http.StatusNotFound},
+		{4, http.MethodGet, "/users/u-bob/admin", nil, admin, http.StatusOK},
+		{5, http.MethodGet, "/users/u-bob/admin", nil, bob, http.StatusNotFound},
+		{6, http.MethodGet, "/users/nobody/admin", nil, admin, http.StatusNotFound},
+		{7, http.MethodPost, "/users/u-bob/admin/enable", nil, bob, http.StatusNotFound},
+		{8, http.MethodPost, "/users/u-admin/admin/disable", nil, bob, http.StatusNotFound},
+		{9, http.MethodPost, "/users/nobody/admin/enable", nil, admin, http.StatusNotFound},
+		{10, http.MethodPost, "/users/nobody/admin/disable", nil, admin, http.StatusNotFound},
+		{11, http.MethodPost, "/users/nobody/admin/roles", url.Values{"role": {"gm"}}, admin, http.StatusNotFound},
+		{12, http.MethodPost, "/users/u-bob/admin/enable", nil, admin, http.StatusSeeOther},
+	} {
+		r := newTestRequest(a, tc.method, tc.target, tc.form, tc.session)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s %s: want %d, got %d", tc.id, tc.method, tc.target, tc.want, w.Code)
+		}
+	}
+
+	// only the known user was changed
+	if list := db.executed("update users set disabled_at"); len(list) != 1 {
+		t.Fatalf("enable: want 1 update, got %d", len(list))
+	} else if list[0].args[1] != "u-bob" {
+		t.Errorf("enable: want u-bob, got %v", list[0].args[1])
+	}
+	if list := db.executed("insert into user_roles"); len(list) != 0 {
+		t.Errorf("roles: want no changes, got %d", len(list))
+	}
+}
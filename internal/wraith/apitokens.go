@@ -0,0 +1,379 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mdhender/wraithi/internal/rbac"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix starts every personal API token.
+// It lets withUser tell them apart from session tokens
+// and makes leaked tokens easy for secret scanners to find.
+const apiTokenPrefix = "wraith_pat_"
+
+// apiTokenScopes are the permissions that can be granted to a token,
+// in the order they're shown on the page.
+// Account management is deliberately left out so that a leaked token
+// can't be used to take over the account.
+var apiTokenScopes = []string{rbac.GamesRead, rbac.OrdersSubmit, rbac.ProfileRead}
+
+// apiTokenLifetimes are the choices for how long a token lasts, in days.
+var apiTokenLifetimes = []int{7, 30, 90, 365}
+
+// APITokenRecord is a personal API token as stored in the database.
+// Only the hash of the token is stored.
+type APITokenRecord struct {
+	Id         string
+	UserId     string
+	Handle     string // handle of the owner, set by APITokenByHash
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time // zero if never used
+	RevokedAt  time.Time // zero if not revoked
+}
+
+// CreateAPIToken saves a new token for the user.
+func (db *DB) CreateAPIToken(userId, name, hashedToken string, scopes []string, ttl time.Duration) (APITokenRecord, error) {
+	now := time.Now().UTC()
+	t := APITokenRecord{
+		Id:        uuid.NewString(),
+		UserId:    userId,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	_, err := db.db.ExecContext(db.context,
+		"insert into api_tokens (id, user_id, name, hashed_token, scopes, created_at, expires_at) values (?, ?, ?, ?, ?, ?, ?)",
+		t.Id, t.UserId, t.Name, hashedToken, strings.Join(t.Scopes, " "), t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return APITokenRecord{}, err
+	}
+	return t, nil
+}
+
+// APITokenByHash returns the unrevoked, unexpired token with the given hash.
+// Tokens owned by disabled users are ignored.
+// Returns ErrNotFound if there is no such token.
+func (db *DB) APITokenByHash(hashedToken string) (APITokenRecord, error) {
+	var t APITokenRecord
+	var scopes string
+	var lastUsedAt sql.NullTime
+	row := db.db.QueryRowContext(db.context,
+		`select t.id, t.user_id, u.handle, t.name, t.scopes, t.created_at, t.expires_at, t.last_used_at
+		 from api_tokens t join users u on u.id = t.user_id
+		 where t.hashed_token = ? and t.revoked_at is null and t.expires_at > ? and u.disabled_at is null`,
+		hashedToken, time.Now().UTC())
+	if err := row.Scan(&t.Id, &t.UserId, &t.Handle, &t.Name, &scopes, &t.CreatedAt, &t.ExpiresAt, &lastUsedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APITokenRecord{}, ErrNotFound
+		}
+		return APITokenRecord{}, err
+	}
+	t.Scopes, t.LastUsedAt = strings.Fields(scopes), lastUsedAt.Time
+	return t, nil
+}
+
+// UserAPITokens returns all the user's tokens, including expired and revoked ones.
+func (db *DB) UserAPITokens(userId string) ([]APITokenRecord, error) {
+	rows, err := db.db.QueryContext(db.context,
+		"select id, user_id, name, scopes, created_at, expires_at, last_used_at, revoked_at from api_tokens where user_id = ? order by created_at desc",
+		userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []APITokenRecord
+	for rows.Next() {
+		var t APITokenRecord
+		var scopes string
+		var lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&t.Id, &t.UserId, &t.Name, &scopes, &t.CreatedAt, &t.ExpiresAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes, t.LastUsedAt, t.RevokedAt = strings.Fields(scopes), lastUsedAt.Time, revokedAt.Time
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// RevokeAPIToken revokes one of the user's tokens.
+// Returns ErrNotFound if the user doesn't have an unrevoked token with that id.
+func (db *DB) RevokeAPIToken(userId, id string) error {
+	result, err := db.db.ExecContext(db.context,
+		"update api_tokens set revoked_at = ? where id = ? and user_id = ? and revoked_at is null",
+		time.Now().UTC(), id, userId)
+	if err != nil {
+		return err
+	} else if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchAPIToken updates the last-used time of the token.
+func (db *DB) TouchAPIToken(id string) error {
+	_, err := db.db.ExecContext(db.context,
+		"update api_tokens set last_used_at = ? where id = ?",
+		time.Now().UTC(), id)
+	return err
+}
+
+// newAPIToken returns a new token and its hash.
+func (a *App) newAPIToken() (token, hashed string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, a.hashAPIToken(token), nil
+}
+
+// hashAPIToken returns the keyed hash of a token.
+// Tokens are random, so a fast hash is safe and lets us look them up by hash.
+func (a *App) hashAPIToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(a.salt))
+	mac.Write([]byte("api-token:" + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// userForAPIToken returns the User for a personal API token.
+// The User has only the permissions that the owner holds and the token was granted.
+func (a *App) userForAPIToken(r *http.Request, token string) (User, error) {
+	t, err := a.db.APITokenByHash(a.hashAPIToken(token))
+	if err != nil {
+		return User{}, err
+	}
+	roles, err := a.db.userRoles(t.UserId)
+	if err != nil {
+		return User{}, err
+	}
+	roles = append([]string{rbac.Authenticated}, roles...)
+	held, perms := a.rbac.Permissions(roles...), make(map[string]bool)
+	for _, scope := range t.Scopes {
+		if held[scope] {
+			perms[scope] = true
+		}
+	}
+	// only update the last-used time every so often to limit writes to the database
+	if time.Since(t.LastUsedAt) > time.Minute {
+		if err := a.db.TouchAPIToken(t.Id); err != nil {
+			log.Printf("%s %s: api token: touch: %v\n", r.Method, r.URL, err)
+		}
+	}
+	return User{
+		id:      t.UserId,
+		handle:  t.Handle,
+		roles:   roles,
+		perms:   perms,
+		tokenId: t.Id,
+	}, nil
+}
+
+// APITokensData is the data for the list of a user's API tokens.
+type APITokensData struct {
+	Id        string
+	Tokens    []APITokenData
+	Scopes    []string // scopes that can be granted
+	Lifetimes []int    // choices for the lifetime, in days
+	NewToken  string   // only set right after a token is created
+	Error     string
+}
+
+// APITokenData is the data for a single API token.
+type APITokenData struct {
+	Id         string
+	Name       string
+	Scopes     string
+	CreatedAt  string
+	ExpiresAt  string
+	LastUsedAt string
+	Status     string // "active", "expired", or "revoked"
+}
+
+// getUsersIdTokens lists the user's tokens and has the form to create a new one.
+func (a *App) getUsersIdTokens() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "api_tokens")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getUsersIdTokens: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		content, err := a.apiTokensData(user.Id())
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.renderAPITokens(w, r, t, user, content)
+	}
+}
+
+// postUsersIdTokens creates a new token.
+// The token is shown once; after that only its name and scopes are.
+func (a *App) postUsersIdTokens() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "api_tokens")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postUsersIdTokens: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		name, scopes, days, err := validateAPIToken(r.PostForm.Get("name"), r.PostForm["scope"], r.PostForm.Get("days"))
+		if err != nil {
+			content, lerr := a.apiTokensData(user.Id())
+			if lerr != nil {
+				a.internalError(w, r, lerr)
+				return
+			}
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			a.renderAPITokens(w, r, t, user, content)
+			return
+		}
+		token, hashed, err := a.newAPIToken()
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		rec, err := a.db.CreateAPIToken(user.Id(), name, hashed, scopes, time.Duration(days)*24*time.Hour)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q created api token %q with scopes %v\n", r.Method, r.URL, user.Id(), rec.Id, scopes)
+		content, err := a.apiTokensData(user.Id())
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		content.NewToken = token
+		a.renderAPITokens(w, r, t, user, content)
+	}
+}
+
+// postUsersIdTokensRevoke revokes one of the user's tokens.
+func (a *App) postUsersIdTokensRevoke() http.HandlerFunc {
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		if err := a.db.RevokeAPIToken(user.Id(), way.Param(r.Context(), "tid")); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				nfh(w, r)
+				return
+			}
+			a.internalError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/users/%s/tokens", user.Id()), http.StatusSeeOther)
+	}
+}
+
+func (a *App) apiTokensData(userId string) (APITokensData, error) {
+	content := APITokensData{Id: userId, Scopes: apiTokenScopes, Lifetimes: apiTokenLifetimes}
+	list, err := a.db.UserAPITokens(userId)
+	if err != nil {
+		return content, err
+	}
+	now := time.Now()
+	for _, t := range list {
+		td := APITokenData{
+			Id:        t.Id,
+			Name:      t.Name,
+			Scopes:    strings.Join(t.Scopes, ", "),
+			CreatedAt: t.CreatedAt.Format(a.timestampFormat),
+			ExpiresAt: t.ExpiresAt.Format(a.timestampFormat),
+			Status:    "active",
+		}
+		if !t.LastUsedAt.IsZero() {
+			td.LastUsedAt = t.LastUsedAt.Format(a.timestampFormat)
+		}
+		if !t.RevokedAt.IsZero() {
+			td.Status = "revoked"
+		} else if !t.ExpiresAt.After(now) {
+			td.Status = "expired"
+		}
+		content.Tokens = append(content.Tokens, td)
+	}
+	return content, nil
+}
+
+func (a *App) renderAPITokens(w http.ResponseWriter, r *http.Request, t *templateHandler, user User, content APITokensData) {
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = "API Tokens"
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	t.render(w, r, payload)
+}
+
+// validateAPIToken checks the form for a new token.
+func validateAPIToken(name string, scopes []string, days string) (string, []string, int, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return "", nil, 0, ErrInvalidTokenName
+	}
+	var granted []string
+	for _, scope := range apiTokenScopes {
+		for _, s := range scopes {
+			if s == scope {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+	if len(granted) == 0 || len(granted) != len(scopes) {
+		return "", nil, 0, ErrInvalidScope
+	}
+	n, err := strconv.Atoi(days)
+	if err != nil {
+		return "", nil, 0, ErrInvalidLifetime
+	}
+	for _, d := range apiTokenLifetimes {
+		if n == d {
+			return name, granted, n, nil
+		}
+	}
+	return "", nil, 0, ErrInvalidLifetime
+}
@@ -0,0 +1,65 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPITokenScopes(t *testing.T) {
+	a, db := newTestApp(t)
+	now := time.Now().UTC()
+	tokens := map[string]string{ // hash to scopes
+		a.hashAPIToken(apiTokenPrefix + "read"):   "games.read",
+		a.hashAPIToken(apiTokenPrefix + "orders"): "orders.submit",
+	}
+	db.answerFunc("from api_tokens t join users u", func(args []driver.Value) [][]driver.Value {
+		scopes, ok := tokens[args[0].(string)]
+		if !ok {
+			return nil
+		}
+		return [][]driver.Value{{"t1", "u1", "alice", "test", scopes, now, now.Add(time.Hour), nil}}
+	})
+	// the owner may manage the account, which is never granted to a token
+	db.answer("select role from user_roles")
+
+	for _, tc := range []struct {
+		id     int
+		token  string
+		method string
+		target string
+		want   int
+	}{
+		{1, apiTokenPrefix + "read", http.MethodGet, "/games", http.StatusOK},
+		{2, apiTokenPrefix + "orders", http.MethodGet, "/games", http.StatusNotFound},
+		{3, apiTokenPrefix + "read", http.MethodGet, "/users/u1/tokens", http.StatusNotFound},
+		{4, apiTokenPrefix + "read", http.MethodGet, "/sessions", http.StatusNotFound},
+		{5, apiTokenPrefix + "unknown", http.MethodGet, "/games", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s %s: want %d, got %d", tc.id, tc.method, tc.target, tc.want, w.Code)
+		}
+	}
+
+	// the token's user is the owner, limited to the token's scopes
+	r := httptest.NewRequest(http.MethodGet, "/games", nil)
+	r.Header.Set("Authorization", "Bearer "+apiTokenPrefix+"read")
+	var got User
+	a.withUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = a.currentUser(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if got.Id() != "u1" || got.tokenId != "t1" {
+		t.Errorf("user: want u1/t1, got %q/%q", got.Id(), got.tokenId)
+	} else if !got.Can("games.read") || got.Can("orders.submit") || got.Can("account.manage") {
+		t.Errorf("user: want only games.read, got %v", got.perms)
+	}
+	if list := db.executed("update api_tokens set last_used_at"); len(list) == 0 {
+		t.Errorf("touch: want last used time updated, got none")
+	}
+}
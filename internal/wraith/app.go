@@ -9,15 +9,22 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mdhender/wraithi/internal/authn"
+	"github.com/mdhender/wraithi/internal/authn/github"
 	"github.com/mdhender/wraithi/internal/authn/google"
+	"github.com/mdhender/wraithi/internal/authn/oidc"
 	"github.com/mdhender/wraithi/internal/config"
+	"github.com/mdhender/wraithi/internal/lockout"
+	"github.com/mdhender/wraithi/internal/mail"
 	"github.com/mdhender/wraithi/internal/nonces"
+	"github.com/mdhender/wraithi/internal/rbac"
 	"github.com/mdhender/wraithi/internal/semver"
+	"github.com/mdhender/wraithi/internal/sessions"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -41,7 +48,40 @@ func NewApp(cfg *config.Config, db *sql.DB, options ...Option) (*App, error) {
 		},
 	}
 	a.cookies.name = "wraith-session"
+	a.cookies.impersonator = "wraith-impersonator"
+	a.cookies.csrf = "wraith-csrf"
+	a.cookies.invite = "wraith-invite"
+	a.cookies.httpOnly = cfg.Cookies.HttpOnly
+	a.cookies.secure = cfg.Cookies.Secure
+	if cfg.Server.Key == "" {
+		return nil, fmt.Errorf("key: %w", ErrMissingKey)
+	}
+	a.key = []byte(cfg.Server.Key)
+	a.sessions.signer = sessions.NewSigner(cfg.Server.Key, cfg.Sessions.TTL)
+	switch cfg.Sessions.Store {
+	case "memory":
+		a.sessions.store = sessions.NewMemoryStore()
+	case "mysql":
+		a.sessions.store = sessions.NewMySQLStore(ctx, db)
+	default:
+		return nil, fmt.Errorf("session store: %q: %w", cfg.Sessions.Store, ErrUnknownStore)
+	}
+	switch cfg.Lockout.Store {
+	case "memory":
+		a.lockout = lockout.New(lockout.NewMemoryStore(), lockout.DefaultAccountPolicy, lockout.DefaultIPPolicy)
+	case "mysql":
+		a.lockout = lockout.New(lockout.NewMySQLStore(ctx, db), lockout.DefaultAccountPolicy, lockout.DefaultIPPolicy)
+	default:
+		return nil, fmt.Errorf("lockout store: %q: %w", cfg.Lockout.Store, ErrUnknownStore)
+	}
+	grants, err := a.db.Grants()
+	if err != nil {
+		return nil, fmt.Errorf("roles: %w", err)
+	}
+	a.rbac = rbac.New(grants)
+	a.salt = cfg.Server.Salt
 	a.templates.path = cfg.App.Templates
+	a.timestampFormat = cfg.App.TimestampFormat
 	a.templates.site.Copyright.Year = "2023"
 	a.templates.site.Copyright.Author = "Michael D Henderson"
 	a.templates.site.NavBar = NavBarData{Links: []LinkData{{Text: "Home", Url: "/"}}}
@@ -50,22 +90,60 @@ func NewApp(cfg *config.Config, db *sql.DB, options ...Option) (*App, error) {
 	a.templates.site.Version = semver.Version{Major: 0, Minor: 1, Patch: 0}.String()
 
 	nonceTTL := 5 * time.Minute
+	a.cookies.preauth.name, a.cookies.preauth.ttl = "wraith-preauth", nonceTTL
+	a.inviteOnly = cfg.Auth.InviteOnly
 	for _, id := range strings.Split(cfg.Auth.Providers, ",") {
-		switch strings.TrimSpace(id) {
+		id = strings.TrimSpace(id)
+		// generic OpenID Connect providers are declared as "oidc:Name" and configured from the environment.
+		if name, ok := strings.CutPrefix(id, "oidc:"); ok {
+			p, err := oidcProvider(name, cfg.Auth.CallbackURL, nonces.NewFactory(nonceTTL))
+			if err != nil {
+				return nil, fmt.Errorf("provider: %q: %w", id, err)
+			}
+			a.authn = append(a.authn, p)
+			continue
+		}
+		switch id {
 		case "Facebook":
 			return nil, fmt.Errorf("provider: %q: %w", id, authn.ErrUnknownProvider)
 		case "GitHub":
-			return nil, fmt.Errorf("provider: %q: %w", id, authn.ErrUnknownProvider)
+			clientId := os.Getenv("WRAITH_GITHUB_CLIENT_ID")
+			clientSecret := os.Getenv("WRAITH_GITHUB_CLIENT_SECRET")
+			nf := nonces.NewFactory(nonceTTL)
+			a.authn = append(a.authn, github.GitHub(clientId, clientSecret, cfg.Auth.CallbackURL+"/github", nf))
 		case "Google":
 			clientId := os.Getenv("WRAITH_GOOGLE_CLIENT_ID")
 			clientSecret := os.Getenv("WRAITH_GOOGLE_CLIENT_SECRET")
 			nf := nonces.NewFactory(nonceTTL)
-			a.authn = append(a.authn, google.Google(clientId, clientSecret, "http://localhost:8080/auth/callback/google", nf))
+			a.authn = append(a.authn, google.Google(clientId, clientSecret, cfg.Auth.CallbackURL+"/google", nf))
 		default:
 			return nil, fmt.Errorf("provider: %q: %w", id, authn.ErrUnknownProvider)
 		}
 	}
 
+	// links in e-mail must point at the public address of the server
+	a.baseURL = strings.TrimSuffix(cfg.Server.BaseURL, "/")
+	if a.baseURL == "" {
+		a.baseURL = fmt.Sprintf("%s://%s", cfg.Server.Scheme, net.JoinHostPort(cfg.Server.Host, cfg.Server.Port))
+	}
+	a.mail.from = cfg.Mail.From
+	switch cfg.Mail.Transport {
+	case "file":
+		m, err := mail.NewFileMailer(filepath.Join(cfg.App.Data, "mail"))
+		if err != nil {
+			return nil, fmt.Errorf("mail: %w", err)
+		}
+		a.mail.mailer = m
+	case "memory":
+		a.mail.mailer = mail.NewMemoryMailer()
+	case "smtp":
+		a.mail.mailer = mail.NewSMTPMailer(cfg.Mail.SMTP.Host, cfg.Mail.SMTP.Port, cfg.Mail.SMTP.User, cfg.Mail.SMTP.Secret)
+	default:
+		return nil, fmt.Errorf("mail: %q: %w", cfg.Mail.Transport, mail.ErrUnknownMailer)
+	}
+	a.tokens.verify = nonces.NewFactory(24 * time.Hour)
+	a.tokens.reset = nonces.NewFactory(time.Hour)
+
 	// options override default values, so apply them now
 	for _, option := range options {
 		if err := option(a); err != nil {
@@ -92,6 +170,8 @@ func NewApp(cfg *config.Config, db *sql.DB, options ...Option) (*App, error) {
 	// create a handler for all the routes
 	h := a.routes()
 	// wrap it with some middleware
+	h = a.withImpersonationAudit(h)
+	h = a.csrfProtect()(h)
 	h = a.withUser(h)
 	// and save the handler
 	a.server.Handler = h
@@ -99,32 +179,78 @@ func NewApp(cfg *config.Config, db *sql.DB, options ...Option) (*App, error) {
 	return a, nil
 }
 
+// oidcProvider returns an OpenID Connect provider configured from the environment.
+// For a provider named "Keycloak" the variables are WRAITH_OIDC_KEYCLOAK_ISSUER,
+// WRAITH_OIDC_KEYCLOAK_CLIENT_ID, and WRAITH_OIDC_KEYCLOAK_CLIENT_SECRET.
+func oidcProvider(name, callbackURL string, nf *nonces.Factory) (*oidc.Provider, error) {
+	code := strings.ToLower(name)
+	if code == "" || strings.Trim(code, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+		return nil, authn.ErrUnknownProvider
+	}
+	for _, p := range []string{"facebook", "github", "google"} {
+		if code == p {
+			return nil, authn.ErrDuplicateProvider
+		}
+	}
+	prefix := "WRAITH_OIDC_" + strings.ToUpper(code) + "_"
+	issuer := os.Getenv(prefix + "ISSUER")
+	if issuer == "" {
+		return nil, fmt.Errorf("%sISSUER: %w", prefix, authn.ErrMissingIssuer)
+	}
+	return oidc.OIDC(code, name, issuer, os.Getenv(prefix+"CLIENT_ID"), os.Getenv(prefix+"CLIENT_SECRET"), callbackURL+"/"+code, nf), nil
+}
+
 type App struct {
 	cookies struct {
 		name     string
 		httpOnly bool
 		secure   bool
+		preauth  struct { // binds an OAuth login attempt to the browser
+			name string
+			ttl  time.Duration
+		}
+		impersonator string // holds the administrator's own session while impersonating
+		csrf         string // holds the secret that CSRF tokens are derived from
+		invite       string // holds an invitation code while signing up with a provider
 	}
-	assets  string // path to public assets
-	authn   []authn.Provider
-	context context.Context
-	db      *DB
-	flags   struct {
+	assets     string // path to public assets
+	authn      []authn.Provider
+	baseURL    string // public url of the server
+	context    context.Context
+	db         *DB
+	inviteOnly bool   // new accounts need an invitation
+	key        []byte // key for signing cookies
+	flags      struct {
 		log struct {
 			assets bool
 		}
 		spa bool
 	}
-	root      string
-	data      string // path to data files
-	server    http.Server
+	lockout *lockout.Limiter // throttles sign-in attempts
+	mail    struct {
+		from   string
+		mailer mail.Mailer
+	}
+	rbac     *rbac.Policy // permissions granted by each role
+	root     string
+	data     string // path to data files
+	salt     string // salt for hashing passwords
+	server   http.Server
+	sessions struct {
+		signer *sessions.Signer
+		store  sessions.Store
+	}
 	templates struct {
 		path   string // path to templates
 		site   SiteData
 		footer FooterData
 	}
 	timestampFormat string
-	tls             struct {
+	tokens          struct { // single-use tokens sent by e-mail
+		verify *nonces.Factory
+		reset  *nonces.Factory
+	}
+	tls struct {
 		enabled  bool
 		certFile string
 		keyFile  string
@@ -0,0 +1,141 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql/driver"
+	"github.com/mdhender/wraithi/internal/config"
+	"github.com/mdhender/wraithi/internal/sessions"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testGrants are the roles and permissions created by sql/schema.sql.
+var testGrants = [][]driver.Value{
+	{"admin", "audit.read"},
+	{"admin", "game.admin"},
+	{"admin", "game.create"},
+	{"admin", "invite.create"},
+	{"admin", "user.impersonate"},
+	{"admin", "user.manage"},
+	{"authenticated", "account.manage"},
+	{"authenticated", "games.read"},
+	{"authenticated", "orders.submit"},
+	{"authenticated", "profile.read"},
+	{"gm", "game.create"},
+}
+
+// newTestApp returns an App that keeps sessions, lockouts, and mail in memory
+// and answers its queries from the fake database.
+func newTestApp(t *testing.T) (*App, *fakeDB) {
+	t.Helper()
+	db := &fakeDB{}
+	db.answer("from roles r left join role_permissions", testGrants...)
+	cfg, err := config.Default()
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	cfg.App.Assets = "../../web"
+	cfg.App.Data = t.TempDir()
+	cfg.App.Templates = "../../templates"
+	cfg.Auth.Providers = "GitHub"
+	cfg.Lockout.Store = "memory"
+	cfg.Mail.Transport = "memory"
+	cfg.Sessions.Store = "memory"
+	a, err := NewApp(cfg, db.open())
+	if err != nil {
+		t.Fatalf("app: %v", err)
+	}
+	return a, db
+}
+
+// testSession is a browser that has signed in.
+type testSession struct {
+	id     string // the server-side session
+	cookie *http.Cookie
+}
+
+// signIn starts a session for the user.
+func signIn(t *testing.T, a *App, userId, handle string, roles ...string) *testSession {
+	t.Helper()
+	session, err := a.sessions.store.Create(userId, "test", "192.0.2.1", sessions.StateActive, a.sessions.signer.TTL())
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	token, _, err := a.sessions.signer.Issue(session.Id, userId, handle, roles)
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	return &testSession{id: session.Id, cookie: &http.Cookie{Name: a.cookies.name, Value: token}}
+}
+
+// newTestRequest returns a request from the browser, which is anonymous if the session is nil.
+// Requests that change state carry a valid CSRF token.
+func newTestRequest(a *App, method, target string, form url.Values, s *testSession) *http.Request {
+	var r *http.Request
+	if form == nil {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if s != nil {
+		r.AddCookie(s.cookie)
+	}
+	if method != http.MethodGet {
+		secret, sessionId := "csrf-secret", ""
+		if s != nil {
+			sessionId = s.id
+		}
+		r.AddCookie(&http.Cookie{Name: a.cookies.csrf, Value: secret})
+		r.Header.Set(csrfHeader, a.csrfToken(secret, sessionId))
+	}
+	return r
+}
+
+// serve runs the request through the App's middleware and routes.
+func serve(a *App, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.server.Handler.ServeHTTP(w, r)
+	return w
+}
+
+// answerUsers adds the users and their roles to the fake database.
+func answerUsers(db *fakeDB, users ...UserRecord) {
+	find := func(column string) func(args []driver.Value) [][]driver.Value {
+		return func(args []driver.Value) [][]driver.Value {
+			for _, u := range users {
+				key := map[string]string{"id": u.Id, "handle": u.Handle, "email": u.Email}[column]
+				if key == "" || args[0] != key {
+					continue
+				}
+				var email, disabledAt driver.Value
+				if u.Email != "" {
+					email = u.Email
+				}
+				if u.Disabled {
+					disabledAt = u.CreatedAt
+				}
+				return [][]driver.Value{{u.Id, u.Handle, email, u.EmailVerified, u.HashedPassword, u.TOTPEnabled, disabledAt, u.AvatarURL, u.Timezone, u.NotifyTurns, u.NotifyInvites, u.CreatedAt}}
+			}
+			return nil
+		}
+	}
+	for _, column := range []string{"id", "handle", "email"} {
+		db.answerFunc("from users where "+column+" = ?", find(column))
+	}
+	db.answerFunc("select role from user_roles where user_id = ?", func(args []driver.Value) [][]driver.Value {
+		var rows [][]driver.Value
+		for _, u := range users {
+			if args[0] == u.Id {
+				for _, role := range u.Roles {
+					rows = append(rows, []driver.Value{role})
+				}
+			}
+		}
+		return rows
+	})
+}
@@ -0,0 +1,275 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditEventsPerPage is the number of events on each page of the audit log.
+const auditEventsPerPage = 50
+
+// the database is the application's audit store
+var _ audit.Store = (*DB)(nil)
+
+// RecordAudit adds an event to the audit log.
+// The event's time is set to now if it is zero.
+func (db *DB) RecordAudit(e audit.Event) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if len(e.Detail) > 1024 {
+		e.Detail = e.Detail[:1024]
+	}
+	_, err := db.db.ExecContext(db.context,
+		"insert into audit_events (created_at, actor_id, impersonator_id, action, target_type, target_id, ip, detail) values (?, ?, ?, ?, ?, ?, ?, ?)",
+		e.At.UTC(), sql.NullString{String: e.ActorId, Valid: e.ActorId != ""}, sql.NullString{String: e.ImpersonatorId, Valid: e.ImpersonatorId != ""},
+		e.Action, e.TargetType, e.TargetId, e.IP, e.Detail)
+	return err
+}
+
+// AuditEvents returns the events that match the filter, newest first,
+// along with the number of events that match.
+func (db *DB) AuditEvents(f audit.Filter) ([]audit.Event, int, error) {
+	var where []string
+	var args []any
+	if f.Actor != "" {
+		where, args = append(where, "(e.actor_id = ? or u.handle = ?)"), append(args, f.Actor, f.Actor)
+	}
+	if prefix, ok := strings.CutSuffix(f.Action, "."); ok {
+		where, args = append(where, "e.action like ?"), append(args, strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)+".%")
+	} else if f.Action != "" {
+		where, args = append(where, "e.action = ?"), append(args, f.Action)
+	}
+	if f.Target != "" {
+		where, args = append(where, "e.target_id = ?"), append(args, f.Target)
+	}
+	if !f.Since.IsZero() {
+		where, args = append(where, "e.created_at >= ?"), append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		where, args = append(where, "e.created_at < ?"), append(args, f.Until.UTC())
+	}
+	query := "from audit_events e left join users u on u.id = e.actor_id"
+	if len(where) != 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+
+	var total int
+	if err := db.db.QueryRowContext(db.context, "select count(*) "+query, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query = "select e.id, e.created_at, e.actor_id, u.handle, e.impersonator_id, e.action, e.target_type, e.target_id, e.ip, e.detail " + query + " order by e.id desc"
+	if f.Limit > 0 {
+		query, args = query+" limit ? offset ?", append(args, f.Limit, f.Offset)
+	}
+	rows, err := db.db.QueryContext(db.context, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []audit.Event
+	for rows.Next() {
+		var e audit.Event
+		var actorId, actor, impersonatorId sql.NullString
+		if err := rows.Scan(&e.Id, &e.At, &actorId, &actor, &impersonatorId, &e.Action, &e.TargetType, &e.TargetId, &e.IP, &e.Detail); err != nil {
+			return nil, 0, err
+		}
+		e.ActorId, e.Actor, e.ImpersonatorId = actorId.String, actor.String, impersonatorId.String
+		list = append(list, e)
+	}
+	return list, total, rows.Err()
+}
+
+// audit records an action taken by the current user.
+// Failing to record an event is logged but doesn't fail the request,
+// since the action has already been taken.
+func (a *App) audit(r *http.Request, action, targetType, targetId, detail string) {
+	if err := a.recordAudit(r, action, targetType, targetId, detail); err != nil {
+		log.Printf("%s %s: audit: %s: %v\n", r.Method, r.URL, action, err)
+	}
+}
+
+// recordAudit is audit for callers that must not go on if the event isn't recorded.
+func (a *App) recordAudit(r *http.Request, action, targetType, targetId, detail string) error {
+	u := a.currentUser(r)
+	return a.db.RecordAudit(audit.Event{
+		ActorId:        u.Id(),
+		ImpersonatorId: u.impersonatorId,
+		Action:         action,
+		TargetType:     targetType,
+		TargetId:       targetId,
+		IP:             clientIP(r),
+		Detail:         detail,
+	})
+}
+
+// auditAs records an action taken by a user who isn't the current user yet,
+// such as a user who is signing in.
+func (a *App) auditAs(r *http.Request, actorId, action, targetType, targetId, detail string) {
+	e := audit.Event{
+		ActorId:    actorId,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		IP:         clientIP(r),
+		Detail:     detail,
+	}
+	if err := a.db.RecordAudit(e); err != nil {
+		log.Printf("%s %s: audit: %s: %v\n", r.Method, r.URL, action, err)
+	}
+}
+
+// AuditData is the data for the audit log page.
+type AuditData struct {
+	Actor   string
+	Action  string
+	Target  string
+	Since   string
+	Until   string
+	Actions []string
+	Events  []AuditEventData
+	Total   int
+	Page    int
+	Pages   int
+	Prev    string // empty if there is no previous page
+	Next    string // empty if there is no next page
+	Error   string
+}
+
+// AuditEventData is the data for one event in the audit log.
+type AuditEventData struct {
+	At             string
+	ActorId        string
+	Actor          string
+	ImpersonatorId string
+	Action         string
+	TargetType     string
+	TargetId       string
+	IP             string
+	Detail         string
+}
+
+// auditActions are the choices for the action filter.
+// The entries ending with "." match every action with that prefix.
+var auditActions = []string{
+	"admin.",
+	audit.AdminClearLockout,
+	audit.AdminDelete,
+	audit.AdminDisable,
+	audit.AdminEnable,
+	audit.AdminImpersonate,
+	audit.AdminImpersonateStop,
+	audit.AdminImpersonated,
+	audit.AdminRevokeSession,
+	audit.AdminTwoFactor,
+	"game.",
+	audit.GameCreate,
+	audit.GameFinish,
+	audit.GameMember,
+	audit.GameStart,
+	"invite.",
+	audit.InviteCreate,
+	audit.InviteDelete,
+	audit.InviteRedeem,
+	audit.OrdersSubmit,
+	audit.RoleChange,
+	audit.SignIn,
+	audit.SignInFailed,
+	audit.SignInLocked,
+	audit.SignUp,
+	audit.TurnRun,
+}
+
+// getAudit is the audit log.
+// The filters are query parameters, so a filtered view can be bookmarked.
+// Changes to the filters from the page are htmx requests that only replace the list.
+func (a *App) getAudit() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "audit")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getAudit: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		content := AuditData{
+			Actor:   strings.TrimSpace(r.FormValue("actor")),
+			Action:  strings.TrimSpace(r.FormValue("action")),
+			Target:  strings.TrimSpace(r.FormValue("target")),
+			Since:   strings.TrimSpace(r.FormValue("since")),
+			Until:   strings.TrimSpace(r.FormValue("until")),
+			Actions: auditActions,
+			Page:    1,
+		}
+		if n, err := strconv.Atoi(r.FormValue("page")); err == nil && n > 1 {
+			content.Page = n
+		}
+		f := audit.Filter{
+			Actor:  content.Actor,
+			Action: content.Action,
+			Target: content.Target,
+			Offset: (content.Page - 1) * auditEventsPerPage,
+			Limit:  auditEventsPerPage,
+		}
+		var since, until error
+		f.Since, since = audit.ParseTime(content.Since)
+		f.Until, until = audit.ParseTime(content.Until)
+		if since != nil || until != nil {
+			content.Error = "Times must be dates (2006-01-02) or timestamps (2006-01-02T15:04:05Z)."
+		} else if list, total, err := a.db.AuditEvents(f); err != nil {
+			a.internalError(w, r, err)
+			return
+		} else {
+			content.Total, content.Pages = total, (total+auditEventsPerPage-1)/auditEventsPerPage
+			for _, e := range list {
+				content.Events = append(content.Events, AuditEventData{
+					At:             e.At.Format(a.timestampFormat),
+					ActorId:        e.ActorId,
+					Actor:          e.Actor,
+					ImpersonatorId: e.ImpersonatorId,
+					Action:         e.Action,
+					TargetType:     e.TargetType,
+					TargetId:       e.TargetId,
+					IP:             e.IP,
+					Detail:         e.Detail,
+				})
+			}
+		}
+		query := url.Values{}
+		for k, v := range map[string]string{"actor": content.Actor, "action": content.Action, "target": content.Target, "since": content.Since, "until": content.Until} {
+			if v != "" {
+				query.Set(k, v)
+			}
+		}
+		if content.Page > 1 {
+			query.Set("page", strconv.Itoa(content.Page-1))
+			content.Prev = "/audit?" + query.Encode()
+		}
+		if content.Page < content.Pages {
+			query.Set("page", strconv.Itoa(content.Page+1))
+			content.Next = "/audit?" + query.Encode()
+		}
+
+		if r.Header.Get("Hx-Target") == "audit-list" {
+			t.renderPartial(w, r, "audit_list", content)
+			return
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = "Audit log"
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Users", Url: "/users"},
+			{Text: "Profile", Url: fmt.Sprintf("/users/%s", a.currentUser(r).Id())},
+			{Text: "Sign Out", Url: "/signout"},
+		}}
+		t.render(w, r, payload)
+	}
+}
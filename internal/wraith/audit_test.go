@@ -0,0 +1,54 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAuditPage(t *testing.T) {
+	a, _ := newTestApp(t)
+	admin := signIn(t, a, "u-admin", "admin", "admin")
+	gm := signIn(t, a, "u-gm", "gm", "gm")
+
+	for _, tc := range []struct {
+		id      int
+		session *testSession
+		want    int
+	}{
+		{1, admin, http.StatusOK},
+		{2, gm, http.StatusNotFound},
+		{3, nil, http.StatusNotFound},
+	} {
+		r := newTestRequest(a, http.MethodGet, "/audit", nil, tc.session)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: want %d, got %d", tc.id, tc.want, w.Code)
+		}
+	}
+}
+
+func TestAuditUnknownEmail(t *testing.T) {
+	a, db := newTestApp(t)
+	email := "someone@example.com"
+	r := newTestRequest(a, http.MethodPost, "/signin", url.Values{"email": {email}, "password": {"secret"}}, nil)
+	if w := serve(a, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("signin: want %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	list := db.executed("insert into audit_events")
+	if len(list) != 1 {
+		t.Fatalf("audit: want 1 event, got %d", len(list))
+	} else if list[0].args[3] != audit.SignInFailed {
+		t.Errorf("audit: want %q, got %v", audit.SignInFailed, list[0].args[3])
+	}
+	// the address might be someone's password typed into the wrong field
+	for i, arg := range list[0].args {
+		if strings.Contains(fmt.Sprint(arg), "someone") {
+			t.Errorf("audit: %d: want no e-mail, got %v", i, arg)
+		}
+	}
+}
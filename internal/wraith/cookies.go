@@ -0,0 +1,137 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"fmt"
+	"github.com/mdhender/wraithi/internal/authn"
+	"github.com/mdhender/wraithi/internal/sessions"
+	"net"
+	"net/http"
+	"time"
+)
+
+// clientIP returns the address of the client that sent the request.
+// We don't trust forwarding headers since we can't know who set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clearInviteCookie tells the browser to delete the invitation cookie.
+func (a *App) clearInviteCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookies.invite,
+		Path:     "/auth/callback/",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   a.cookies.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// setInviteCookie carries an invitation code through a provider's login,
+// which takes the same path and lasts as long as the pre-auth cookie.
+func (a *App) setInviteCookie(w http.ResponseWriter, code string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookies.invite,
+		Path:     "/auth/callback/",
+		Value:    code,
+		MaxAge:   int(a.cookies.preauth.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   a.cookies.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearPreAuthCookie tells the browser to delete the pre-auth cookie.
+func (a *App) clearPreAuthCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookies.preauth.name,
+		Path:     "/auth/callback/",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   a.cookies.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// setPreAuthCookie binds the state nonce of an OAuth login attempt to the browser.
+// The cookie must be Lax, not Strict, since the provider redirects back to us.
+func (a *App) setPreAuthCookie(w http.ResponseWriter, provider, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookies.preauth.name,
+		Path:     "/auth/callback/",
+		Value:    authn.BindState(a.key, provider, state, a.cookies.preauth.ttl),
+		MaxAge:   int(a.cookies.preauth.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   a.cookies.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie tells the browser to delete the session cookie.
+func (a *App) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookies.name,
+		Path:     "/",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		HttpOnly: a.cookies.httpOnly,
+		Secure:   a.cookies.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// setSessionCookie creates a new active session for the user, issues a
+// signed token for it, and saves the token in the session cookie.
+func (a *App) setSessionCookie(w http.ResponseWriter, r *http.Request, user UserRecord) error {
+	return a.setSessionCookieState(w, r, user, sessions.StateActive, a.sessions.signer.TTL())
+}
+
+// signInUser starts a session for a user who has passed the first factor.
+// Users with two-factor authentication get a short-lived session that is
+// pending the second factor. It returns the page to send the user to next.
+func (a *App) signInUser(w http.ResponseWriter, r *http.Request, user UserRecord) (string, error) {
+	if user.TOTPEnabled {
+		if err := a.setSessionCookieState(w, r, user, sessions.StatePendingMFA, pendingMFATTL); err != nil {
+			return "", err
+		}
+		return "/signin/2fa", nil
+	}
+	if err := a.setSessionCookie(w, r, user); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/users/%s", user.Id), nil
+}
+
+// setSessionCookieState creates a new session in the given state.
+// Returns ErrAccountDisabled if the user's account is disabled.
+func (a *App) setSessionCookieState(w http.ResponseWriter, r *http.Request, user UserRecord, state string, ttl time.Duration) error {
+	if user.Disabled {
+		return ErrAccountDisabled
+	}
+	session, err := a.sessions.store.Create(user.Id, r.UserAgent(), clientIP(r), state, ttl)
+	if err != nil {
+		return err
+	}
+	token, t, err := a.sessions.signer.Issue(session.Id, user.Id, user.Handle, user.Roles)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookies.name,
+		Path:     "/",
+		Value:    token,
+		Expires:  t.ExpiresAt,
+		HttpOnly: a.cookies.httpOnly,
+		Secure:   a.cookies.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
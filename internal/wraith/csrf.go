@@ -0,0 +1,91 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+)
+
+// CSRF tokens are sent in this header by htmx, which reads them from the
+// hx-headers attribute in the layout, or in this field by forms that aren't boosted.
+const (
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+// csrfContextKey is the context key type for storing the CSRF token in context.Context.
+type csrfContextKey string
+
+// csrfProtect rejects state-changing requests that don't carry the token for the browser's session.
+// The token is a signature of a random secret kept in a cookie that lasts until the browser closes,
+// so a page on another site can't read it or forge it, and of the id of the session that the
+// browser is signed in with, so signing in or out makes every earlier token useless.
+// Requests authenticated with a personal API token aren't sent by a browser and aren't checked.
+// It must run after withUser.
+func (a *App) csrfProtect() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, secret := a.currentUser(r), ""
+			if c, err := r.Cookie(a.cookies.csrf); err == nil && c.Value != "" {
+				secret = c.Value
+			}
+
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if u.tokenId != "" {
+					break
+				}
+				token := r.Header.Get(csrfHeader)
+				if token == "" {
+					token = r.PostFormValue(csrfField)
+				}
+				if secret == "" || !hmac.Equal([]byte(token), []byte(a.csrfToken(secret, u.sessionId))) {
+					log.Printf("%s %s: csrf: missing or invalid token\n", r.Method, r.URL)
+					http.Error(w, "The form has expired. Reload the page and try again.", http.StatusForbidden)
+					return
+				}
+			}
+
+			if secret == "" {
+				b := make([]byte, 32)
+				if _, err := rand.Read(b); err != nil {
+					a.internalError(w, r, err)
+					return
+				}
+				secret = base64.RawURLEncoding.EncodeToString(b)
+				http.SetCookie(w, &http.Cookie{
+					Name:     a.cookies.csrf,
+					Path:     "/",
+					Value:    secret,
+					HttpOnly: true,
+					Secure:   a.cookies.secure,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			ctx := context.WithValue(r.Context(), csrfContextKey("csrf"), a.csrfToken(secret, u.sessionId))
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// csrfToken returns the token for the secret and the session.
+// The session id is empty for anonymous users.
+func (a *App) csrfToken(secret, sessionId string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte("csrf:" + secret + ":" + sessionId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfTokenFor returns the CSRF token for the request.
+// It is empty if the request didn't pass through csrfProtect.
+func csrfTokenFor(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey("csrf")).(string)
+	return token
+}
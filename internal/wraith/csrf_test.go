@@ -0,0 +1,81 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSRF(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-gm", Handle: "gm", Role: GameRoleGM},
+		GameMemberRecord{UserId: "u-player", Handle: "player", Role: GameRolePlayer, Nation: 1},
+	)
+	gm := signIn(t, a, "u-gm", "gm")
+	other := signIn(t, a, "u-gm", "gm")
+	secret := "csrf-secret"
+	finish := func(token string, s *testSession) int {
+		r := httptest.NewRequest(http.MethodPost, "/games/g1/finish", nil)
+		r.AddCookie(&http.Cookie{Name: a.cookies.csrf, Value: secret})
+		r.AddCookie(s.cookie)
+		if token != "" {
+			r.Header.Set(csrfHeader, token)
+		}
+		return serve(a, r).Code
+	}
+
+	for _, tc := range []struct {
+		id    int
+		token string
+		want  int
+	}{
+		{1, "", http.StatusForbidden},
+		{2, "not-a-token", http.StatusForbidden},
+		// a token from before signing in, or from another session, isn't accepted
+		{3, a.csrfToken(secret, ""), http.StatusForbidden},
+		{4, a.csrfToken(secret, other.id), http.StatusForbidden},
+		{5, a.csrfToken("another-secret", gm.id), http.StatusForbidden},
+		{6, a.csrfToken(secret, gm.id), http.StatusSeeOther},
+	} {
+		if got := finish(tc.token, gm); got != tc.want {
+			t.Errorf("%d: want %d, got %d", tc.id, tc.want, got)
+		}
+	}
+	if got := len(db.executed("update games set finished_at")); got != 1 {
+		t.Errorf("finish: want 1 update, got %d", got)
+	}
+
+	// the page carries the token for the session
+	r := newTestRequest(a, http.MethodGet, "/games/g1", nil, gm)
+	r.AddCookie(&http.Cookie{Name: a.cookies.csrf, Value: secret})
+	if w := serve(a, r); w.Code != http.StatusOK {
+		t.Fatalf("page: want %d, got %d", http.StatusOK, w.Code)
+	} else if !strings.Contains(w.Body.String(), a.csrfToken(secret, gm.id)) {
+		t.Errorf("page: want the session's token, got none")
+	}
+}
+
+func TestCSRFAPIToken(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-player", Handle: "player", Role: GameRolePlayer, Nation: 1},
+	)
+	now := time.Now().UTC()
+	db.answer("from api_tokens t join users u",
+		[]driver.Value{"t1", "u-player", "player", "test", "orders.submit", now, now.Add(time.Hour), now})
+
+	// requests with a personal API token don't come from a browser and carry no CSRF token
+	r := httptest.NewRequest(http.MethodPost, "/games/g1/orders", nil)
+	r.Header.Set("Authorization", "Bearer "+apiTokenPrefix+"orders")
+	r.Header.Set("Content-Type", "text/plain")
+	// the game hasn't started, so the orders are refused by the handler and not by csrfProtect
+	if w := serve(a, r); w.Code != http.StatusConflict {
+		t.Errorf("orders: want %d, got %d", http.StatusConflict, w.Code)
+	}
+}
@@ -13,3 +13,8 @@ type DB struct {
 	context context.Context
 	db      *sql.DB
 }
+
+// NewDB returns a DB for tools that use the database without running the server.
+func NewDB(ctx context.Context, db *sql.DB) *DB {
+	return &DB{context: ctx, db: db}
+}
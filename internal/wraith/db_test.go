@@ -0,0 +1,178 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeDB is a database/sql driver that answers queries with canned rows,
+// so that handlers can be tested without a MySQL server.
+// A query is answered by the first rule whose text is part of the query;
+// queries without a rule return no rows. Statements always succeed
+// and are saved so that tests can check what was written.
+type fakeDB struct {
+	sync.Mutex
+	rules []fakeRule
+	execs []fakeExec
+}
+
+// fakeRule answers the queries that contain the text.
+// The rows may depend on the query's arguments.
+type fakeRule struct {
+	text string
+	rows func(args []driver.Value) [][]driver.Value
+}
+
+// fakeExec is a statement that was run.
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+// open returns a *sql.DB that uses the fake.
+func (f *fakeDB) open() *sql.DB {
+	return sql.OpenDB(f)
+}
+
+// answer adds a rule that always returns the same rows.
+func (f *fakeDB) answer(text string, rows ...[]driver.Value) {
+	f.answerFunc(text, func([]driver.Value) [][]driver.Value {
+		return rows
+	})
+}
+
+// answerFunc adds a rule that returns rows that depend on the arguments.
+func (f *fakeDB) answerFunc(text string, rows func(args []driver.Value) [][]driver.Value) {
+	f.Lock()
+	defer f.Unlock()
+	f.rules = append(f.rules, fakeRule{text: text, rows: rows})
+}
+
+// executed returns the statements that contain the text.
+func (f *fakeDB) executed(text string) []fakeExec {
+	f.Lock()
+	defer f.Unlock()
+	var list []fakeExec
+	for _, e := range f.execs {
+		if strings.Contains(e.query, text) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// Connect implements the driver.Connector interface.
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{f}, nil
+}
+
+// Driver implements the driver.Connector interface.
+func (f *fakeDB) Driver() driver.Driver {
+	return fakeDriver{f}
+}
+
+type fakeDriver struct {
+	db *fakeDB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{d.db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (tx fakeTx) Commit() error {
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+	s.db.execs = append(s.db.execs, fakeExec{query: s.query, args: args})
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.Lock()
+	var rule *fakeRule
+	for i := range s.db.rules {
+		if strings.Contains(s.query, s.db.rules[i].text) {
+			rule = &s.db.rules[i]
+			break
+		}
+	}
+	s.db.Unlock()
+	if rule == nil {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{rows: rule.rows(args)}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	var columns []string
+	for i := range r.rows[0] {
+		columns = append(columns, fmt.Sprintf("c%d", i))
+	}
+	return columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,255 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/mail"
+	"github.com/mdhender/wraithi/internal/passwords"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MessageData is the data for a page that just shows a message.
+type MessageData struct {
+	Title   string
+	Message string
+	Link    LinkData
+}
+
+// ResetData is the data for the password reset forms.
+type ResetData struct {
+	Token string
+	Error string
+}
+
+// MarkEmailVerified marks the user's address as verified.
+// It does nothing if the address has changed since the link was sent.
+func (db *DB) MarkEmailVerified(userId, email string) error {
+	result, err := db.db.ExecContext(db.context,
+		"update users set email_verified = true where id = ? and email = ?",
+		userId, email)
+	if err != nil {
+		return err
+	} else if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetPassword replaces the user's password hash.
+func (db *DB) SetPassword(userId, hashedPassword string) error {
+	_, err := db.db.ExecContext(db.context,
+		"update users set hashed_password = ? where id = ?",
+		hashedPassword, userId)
+	return err
+}
+
+// sendVerification e-mails the user a single-use link to verify their address.
+func (a *App) sendVerification(r *http.Request, user UserRecord) error {
+	if user.Email == "" || user.EmailVerified {
+		return nil
+	}
+	token, err := a.tokens.verify.CreateFor(user.Id + " " + user.Email)
+	if err != nil {
+		return err
+	}
+	return a.mail.mailer.Send(r.Context(), mail.Message{
+		From:    a.mail.from,
+		To:      []string{user.Email},
+		Subject: "Verify your Wraith e-mail address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease verify your e-mail address by opening this link:\n\n    %s/verify?token=%s\n\nThe link expires in 24 hours.\nIf you didn't create a Wraith account, you can ignore this message.\n",
+			user.Handle, a.baseURL, url.QueryEscape(token)),
+		Date: time.Now(),
+	})
+}
+
+// sendReset e-mails the user a single-use link to reset their password.
+func (a *App) sendReset(r *http.Request, user UserRecord) error {
+	token, err := a.tokens.reset.CreateFor(user.Id + " " + user.Email)
+	if err != nil {
+		return err
+	}
+	return a.mail.mailer.Send(r.Context(), mail.Message{
+		From:    a.mail.from,
+		To:      []string{user.Email},
+		Subject: "Reset your Wraith password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account.\nTo choose a new password, open this link:\n\n    %s/reset/confirm?token=%s\n\nThe link expires in one hour and can only be used once.\nIf you didn't ask for this, you can ignore this message.\n",
+			user.Handle, a.baseURL, url.QueryEscape(token)),
+		Date: time.Now(),
+	})
+}
+
+// getReset shows the form to request a password reset link.
+func (a *App) getReset() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "reset")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getReset: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload := Payload{Site: a.templates.site, Content: ResetData{}}
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Home", Url: "/"},
+			{Text: "Sign In", Url: "/signin"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// getResetConfirm shows the form to choose a new password.
+// The token isn't checked until the form is posted, since checking consumes it.
+func (a *App) getResetConfirm() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "reset_confirm")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getResetConfirm: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload := Payload{Site: a.templates.site, Content: ResetData{Token: r.FormValue("token")}}
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Home", Url: "/"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// getVerify consumes an e-mail verification link.
+func (a *App) getVerify() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "message")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getVerify: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		content := MessageData{
+			Title:   "E-mail Verified",
+			Message: "Thank you! Your e-mail address has been verified.",
+			Link:    LinkData{Text: "Continue", Url: "/"},
+		}
+		subject, ok := a.tokens.verify.Subject(r.FormValue("token"))
+		userId, email, _ := strings.Cut(subject, " ")
+		if !ok {
+			content.Title, content.Message = "Link Expired", "That verification link is invalid or has expired. You can request a new one from your profile page."
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err := a.db.MarkEmailVerified(userId, email); err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				a.internalError(w, r, err)
+				return
+			}
+			content.Title, content.Message = "Link Expired", "Your e-mail address has changed since that link was sent."
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Home", Url: "/"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// postReset sends a password reset link.
+// The response is the same whether the account exists so that the form
+// can't be used to find out who has an account.
+func (a *App) postReset() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "message")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postReset: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if user, err := a.db.UserByEmail(r.FormValue("email")); err == nil {
+			if err := a.sendReset(r, user); err != nil {
+				log.Printf("%s %s: reset: %v\n", r.Method, r.URL, err)
+			}
+		} else if !errors.Is(err, ErrNotFound) {
+			log.Printf("%s %s: reset: %v\n", r.Method, r.URL, err)
+		}
+		payload := Payload{Site: a.templates.site, Content: MessageData{
+			Title:   "Check Your E-mail",
+			Message: "If there is an account for that address, we've sent it a link to reset the password.",
+			Link:    LinkData{Text: "Sign In", Url: "/signin"},
+		}}
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Home", Url: "/"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// postResetConfirm consumes a password reset link and sets the new password.
+// Every session for the user is revoked since the old password may have been compromised.
+func (a *App) postResetConfirm() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "reset_confirm")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postResetConfirm: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, password, confirm := r.FormValue("token"), r.FormValue("password"), r.FormValue("confirm")
+		fail := func(status int, msg string) {
+			payload := Payload{Site: a.templates.site, Content: ResetData{Token: token, Error: msg}}
+			payload.Site.NavBar = NavBarData{Links: []LinkData{
+				{Text: "Home", Url: "/"},
+			}}
+			w.WriteHeader(status)
+			t.render(w, r, payload)
+		}
+		// check the password first so that a typo doesn't burn the token
+		if len(password) < 8 || password != confirm {
+			fail(http.StatusUnprocessableEntity, ErrInvalidPassword.Error())
+			return
+		}
+		subject, ok := a.tokens.reset.Subject(token)
+		if !ok {
+			fail(http.StatusBadRequest, "That reset link is invalid or has expired. Please request a new one.")
+			return
+		}
+		userId, email, _ := strings.Cut(subject, " ")
+		hashed, err := passwords.Hash(password, a.salt)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err = a.db.SetPassword(userId, hashed); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		// following the link proves they own the address
+		if err := a.db.MarkEmailVerified(userId, email); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("%s %s: reset: %v\n", r.Method, r.URL, err)
+		}
+		if err := a.sessions.store.RevokeUser(userId); err != nil {
+			log.Printf("%s %s: reset: %v\n", r.Method, r.URL, err)
+		}
+		a.clearSessionCookie(w)
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+	}
+}
+
+// postUsersIdVerify sends the current user a new verification link.
+func (a *App) postUsersIdVerify() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		rec, err := a.db.UserById(user.Id())
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err = a.sendVerification(r, rec); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/users/%s", user.Id()), http.StatusSeeOther)
+	}
+}
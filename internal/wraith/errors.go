@@ -0,0 +1,49 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+// Errors used by the package.
+const (
+	ErrAccountDisabled  = constError("account is disabled")
+	ErrDuplicateEmail   = constError("duplicate email")
+	ErrDuplicateGame    = constError("there is already a game with that name")
+	ErrDuplicateHandle  = constError("duplicate handle")
+	ErrGameFinished     = constError("the game has finished")
+	ErrGameNotStarted   = constError("the game hasn't started")
+	ErrGameStarted      = constError("the game has already started")
+	ErrIdentityLinked   = constError("identity is linked to another account")
+	ErrInvalidEmail     = constError("invalid email")
+	ErrInvalidGameName  = constError("game name must be 1 to 64 characters")
+	ErrInvalidGameRole  = constError("invalid game role")
+	ErrInvalidHandle    = constError("invalid handle")
+	ErrInvalidInvite    = constError("the invitation is unknown, expired, or used up")
+	ErrInvalidLifetime  = constError("invalid lifetime")
+	ErrInvalidMaxUses   = constError("uses must be 0 to 1000")
+	ErrInvalidNation    = constError("players must have a nation and other roles must not")
+	ErrInvalidAvatar    = constError("invalid avatar")
+	ErrInvalidPassword  = constError("invalid password")
+	ErrInvalidResult    = constError("result must be at most 64 characters")
+	ErrInvalidScope     = constError("invalid scope")
+	ErrInvalidSeed      = constError("seed must be a number from 0 to 18446744073709551615")
+	ErrInvalidTimezone  = constError("invalid timezone")
+	ErrInvalidTokenName = constError("token name must be 1 to 64 characters")
+	ErrInviteRequired   = constError("an invitation is required to sign up")
+	ErrLastCredential   = constError("can't remove the only way to sign in")
+	ErrLastGameMaster   = constError("the game must have a game master")
+	ErrLongOrders       = constError("orders must be at most 64 KB")
+	ErrMissingKey       = constError("missing signing key")
+	ErrNationTaken      = constError("another player has that nation")
+	ErrNoPlayers        = constError("the game has no players")
+	ErrNotFound         = constError("not found")
+	ErrTurnExists       = constError("the turn has already been run")
+	ErrUnknownGame      = constError("there is no game with that name")
+	ErrUnknownHandle    = constError("there is no user with that handle")
+	ErrUnknownStore     = constError("unknown store")
+)
+
+// declarations to support constant errors
+type constError string
+
+func (ce constError) Error() string {
+	return string(ce)
+}
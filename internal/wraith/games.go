@@ -0,0 +1,540 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/rbac"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Per-game roles. A user has at most one role in each game.
+const (
+	GameRoleGM         = "gm"
+	GameRolePlayer     = "player" // bound to a nation
+	GameRoleObserver   = "observer"
+	GameRoleEliminated = "eliminated" // a player whose nation is out of the game
+)
+
+// gameRoles is the list of per-game roles, in the order they're shown on the page.
+var gameRoles = []string{GameRoleGM, GameRolePlayer, GameRoleObserver, GameRoleEliminated}
+
+// GameRecord is a game as stored in the database.
+type GameRecord struct {
+	Id         string
+	Name       string
+	CreatedBy  string // id of the user who created the game; empty if they were deleted
+	CreatedAt  time.Time
+	FinishedAt time.Time // zero while the game is active
+}
+
+// GameMemberRecord links a user to a game with a per-game role.
+type GameMemberRecord struct {
+	GameId   string
+	UserId   string
+	Handle   string // handle of the user
+	Role     string
+	Nation   int    // the player's nation; zero for other roles
+	Result   string // recorded by the game master
+	JoinedAt time.Time
+}
+
+// HasRole returns true if the member has any of the roles.
+func (m GameMemberRecord) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if m.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateGame creates a new game with the user as its game master.
+// Returns ErrDuplicateGame if there is already a game with the name.
+func (db *DB) CreateGame(name, userId string) (GameRecord, error) {
+	name = strings.TrimSpace(name)
+	if _, err := db.GameByName(name); err == nil {
+		return GameRecord{}, ErrDuplicateGame
+	} else if !errors.Is(err, ErrNotFound) {
+		return GameRecord{}, err
+	}
+	g := GameRecord{
+		Id:        uuid.NewString(),
+		Name:      name,
+		CreatedBy: userId,
+		CreatedAt: time.Now().UTC(),
+	}
+	tx, err := db.db.BeginTx(db.context, nil)
+	if err != nil {
+		return GameRecord{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.ExecContext(db.context,
+		"insert into games (id, name, created_by, created_at) values (?, ?, ?, ?)",
+		g.Id, g.Name, g.CreatedBy, g.CreatedAt); err != nil {
+		return GameRecord{}, err
+	} else if _, err := tx.ExecContext(db.context,
+		"insert into game_members (game_id, user_id, role, nation, joined_at) values (?, ?, ?, null, ?)",
+		g.Id, userId, GameRoleGM, g.CreatedAt); err != nil {
+		return GameRecord{}, err
+	}
+	return g, tx.Commit()
+}
+
+// GameById returns the game with the given id.
+// Returns ErrNotFound if there is no such game.
+func (db *DB) GameById(id string) (GameRecord, error) {
+	return db.fetchGame("id", id)
+}
+
+// GameByName returns the game with the given name.
+// Returns ErrNotFound if there is no such game.
+func (db *DB) GameByName(name string) (GameRecord, error) {
+	return db.fetchGame("name", strings.TrimSpace(name))
+}
+
+func (db *DB) fetchGame(column, value string) (GameRecord, error) {
+	var g GameRecord
+	var createdBy sql.NullString
+	var finishedAt sql.NullTime
+	row := db.db.QueryRowContext(db.context,
+		"select id, name, created_by, created_at, finished_at from games where "+column+" = ?",
+		value)
+	if err := row.Scan(&g.Id, &g.Name, &createdBy, &g.CreatedAt, &finishedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return GameRecord{}, ErrNotFound
+		}
+		return GameRecord{}, err
+	}
+	g.CreatedBy, g.FinishedAt = createdBy.String, finishedAt.Time
+	return g, nil
+}
+
+// GameMember returns the user's membership in the game.
+// Returns ErrNotFound if the user isn't a member.
+func (db *DB) GameMember(gameId, userId string) (GameMemberRecord, error) {
+	var m GameMemberRecord
+	var nation sql.NullInt64
+	row := db.db.QueryRowContext(db.context,
+		`select m.game_id, m.user_id, u.handle, m.role, m.nation, m.result, m.joined_at
+		 from game_members m join users u on u.id = m.user_id
+		 where m.game_id = ? and m.user_id = ?`,
+		gameId, userId)
+	if err := row.Scan(&m.GameId, &m.UserId, &m.Handle, &m.Role, &nation, &m.Result, &m.JoinedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return GameMemberRecord{}, ErrNotFound
+		}
+		return GameMemberRecord{}, err
+	}
+	m.Nation = int(nation.Int64)
+	return m, nil
+}
+
+// GameMembers returns all the members of the game.
+func (db *DB) GameMembers(gameId string) ([]GameMemberRecord, error) {
+	rows, err := db.db.QueryContext(db.context,
+		`select m.game_id, m.user_id, u.handle, m.role, m.nation, m.result, m.joined_at
+		 from game_members m join users u on u.id = m.user_id
+		 where m.game_id = ? order by m.role, m.nation, u.handle`,
+		gameId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []GameMemberRecord
+	for rows.Next() {
+		var m GameMemberRecord
+		var nation sql.NullInt64
+		if err := rows.Scan(&m.GameId, &m.UserId, &m.Handle, &m.Role, &nation, &m.Result, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		m.Nation = int(nation.Int64)
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+// UserGames returns the games the user is a member of, along with the user's membership.
+func (db *DB) UserGames(userId string) ([]GameRecord, []GameMemberRecord, error) {
+	rows, err := db.db.QueryContext(db.context,
+		`select g.id, g.name, g.created_by, g.created_at, g.finished_at, m.role, m.nation, m.result, m.joined_at
+		 from game_members m join games g on g.id = m.game_id
+		 where m.user_id = ? order by g.name`,
+		userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var games []GameRecord
+	var members []GameMemberRecord
+	for rows.Next() {
+		var g GameRecord
+		m := GameMemberRecord{UserId: userId}
+		var createdBy sql.NullString
+		var finishedAt sql.NullTime
+		var nation sql.NullInt64
+		if err := rows.Scan(&g.Id, &g.Name, &createdBy, &g.CreatedAt, &finishedAt, &m.Role, &nation, &m.Result, &m.JoinedAt); err != nil {
+			return nil, nil, err
+		}
+		g.CreatedBy, g.FinishedAt = createdBy.String, finishedAt.Time
+		m.GameId, m.Nation = g.Id, int(nation.Int64)
+		games, members = append(games, g), append(members, m)
+	}
+	return games, members, rows.Err()
+}
+
+// SetGameMember adds the user to the game or changes their role.
+// Players must be bound to a nation; eliminated players may keep theirs
+// and other roles must not have one.
+// Returns ErrNationTaken if another player has the nation
+// and ErrLastGameMaster if the change would leave the game without one.
+func (db *DB) SetGameMember(gameId, userId, role string, nation int, result string) error {
+	if nation < 0 || (role == GameRolePlayer && nation == 0) {
+		return ErrInvalidNation
+	} else if role != GameRolePlayer && role != GameRoleEliminated && nation != 0 {
+		return ErrInvalidNation
+	}
+	tx, err := db.db.BeginTx(db.context, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if role != GameRoleGM {
+		var current string
+		err := tx.QueryRowContext(db.context, "select role from game_members where game_id = ? and user_id = ? for update", gameId, userId).Scan(&current)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		} else if current == GameRoleGM {
+			var gms int
+			if err := tx.QueryRowContext(db.context, "select count(*) from game_members where game_id = ? and role = ?", gameId, GameRoleGM).Scan(&gms); err != nil {
+				return err
+			} else if gms < 2 {
+				return ErrLastGameMaster
+			}
+		}
+	}
+	if nation != 0 {
+		var holder string
+		err := tx.QueryRowContext(db.context, "select user_id from game_members where game_id = ? and nation = ? for update", gameId, nation).Scan(&holder)
+		if err == nil && holder != userId {
+			return ErrNationTaken
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(db.context,
+		`insert into game_members (game_id, user_id, role, nation, result, joined_at) values (?, ?, ?, ?, ?, ?)
+		 on duplicate key update role = values(role), nation = values(nation), result = values(result)`,
+		gameId, userId, role, sql.NullInt64{Int64: int64(nation), Valid: nation != 0}, result, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishGame marks the game as finished.
+func (db *DB) FinishGame(gameId string) error {
+	_, err := db.db.ExecContext(db.context,
+		"update games set finished_at = ? where id = ? and finished_at is null",
+		time.Now().UTC(), gameId)
+	return err
+}
+
+// GamesData is the data for the list of the user's games.
+type GamesData struct {
+	CanCreate bool // true if the user may create games
+	Games     []GameData
+	Error     string
+}
+
+// GameData is the data for a game and the viewer's role in it.
+type GameData struct {
+	Id       string
+	Name     string
+	Role     string // the viewer's role; empty if the viewer isn't a member
+	Nation   int
+	IsGM     bool   // true if the viewer may manage the game
+	Finished string // when the game finished; empty while it is active
+	Started  bool   // true once the galaxy has been generated
+	Turn     int    // the latest turn that has been run
+	NextTurn int
+	Result   string // the viewer's result
+	Members  []GameMemberData
+	Roles    []string    // roles that can be assigned
+	Invites  InvitesData // invitations to the game; only loaded for game masters
+	Error    string
+}
+
+// GameMemberData is the data for a member of a game.
+type GameMemberData struct {
+	Id     string
+	Handle string
+	Role   string
+	Nation int
+	Result string
+}
+
+func (a *App) getGames() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "games")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getGames: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		content, err := a.gamesData(user)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.renderGames(w, r, t, user, content)
+	}
+}
+
+// getGamesGame shows a game to its members.
+func (a *App) getGamesGame() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "game")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getGamesGame: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		content, err := a.gameData(r)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.renderGame(w, r, t, user, content)
+	}
+}
+
+// postGames creates a new game with the user as its game master.
+func (a *App) postGames() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "games")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postGames: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		var game GameRecord
+		var err error
+		if name := strings.TrimSpace(r.FormValue("name")); name == "" || len(name) > 64 {
+			err = ErrInvalidGameName
+		} else {
+			game, err = a.db.CreateGame(name, user.Id())
+		}
+		if errors.Is(err, ErrInvalidGameName) || errors.Is(err, ErrDuplicateGame) {
+			content, lerr := a.gamesData(user)
+			if lerr != nil {
+				a.internalError(w, r, lerr)
+				return
+			}
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			a.renderGames(w, r, t, user, content)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q created game %q\n", r.Method, r.URL, user.Id(), game.Id)
+		a.audit(r, audit.GameCreate, audit.TargetGame, game.Id, game.Name)
+		http.Redirect(w, r, fmt.Sprintf("/games/%s", game.Id), http.StatusSeeOther)
+	}
+}
+
+// postGamesGameMembers adds a user to the game or changes their role.
+func (a *App) postGamesGameMembers() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "game")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postGamesGameMembers: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game := a.currentUser(r), a.currentGame(r)
+		role, nation, result := r.FormValue("role"), 0, strings.TrimSpace(r.FormValue("result"))
+		if s := strings.TrimSpace(r.FormValue("nation")); s != "" {
+			var err error
+			if nation, err = strconv.Atoi(s); err != nil {
+				nation = -1
+			}
+		}
+		var target UserRecord
+		var err error = ErrInvalidGameRole
+		for _, gr := range gameRoles {
+			if role == gr {
+				err = nil
+				break
+			}
+		}
+		if err == nil {
+			if target, err = a.db.UserByHandle(r.FormValue("handle")); errors.Is(err, ErrNotFound) {
+				err = ErrUnknownHandle
+			}
+		}
+		if err == nil && len(result) > 64 {
+			err = ErrInvalidResult
+		}
+		if err == nil {
+			err = a.db.SetGameMember(game.Id, target.Id, role, nation, result)
+		}
+		if errors.Is(err, ErrInvalidGameRole) || errors.Is(err, ErrInvalidResult) || errors.Is(err, ErrUnknownHandle) || errors.Is(err, ErrInvalidNation) || errors.Is(err, ErrNationTaken) || errors.Is(err, ErrLastGameMaster) {
+			content, lerr := a.gameData(r)
+			if lerr != nil {
+				a.internalError(w, r, lerr)
+				return
+			}
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			a.renderGame(w, r, t, user, content)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q set %q to %q (nation %d) in game %q\n", r.Method, r.URL, user.Id(), target.Id, role, nation, game.Id)
+		a.audit(r, audit.GameMember, audit.TargetGame, game.Id, fmt.Sprintf("%s: %s (nation %d)", target.Id, role, nation))
+		http.Redirect(w, r, fmt.Sprintf("/games/%s", game.Id), http.StatusSeeOther)
+	}
+}
+
+// postGamesGameFinish ends the game.
+func (a *App) postGamesGameFinish() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		game := a.currentGame(r)
+		if err := a.db.FinishGame(game.Id); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q finished game %q\n", r.Method, r.URL, a.currentUser(r).Id(), game.Id)
+		a.audit(r, audit.GameFinish, audit.TargetGame, game.Id, "")
+		http.Redirect(w, r, fmt.Sprintf("/games/%s", game.Id), http.StatusSeeOther)
+	}
+}
+
+func (a *App) gamesData(user User) (GamesData, error) {
+	content := GamesData{CanCreate: user.Can(rbac.GameCreate)}
+	games, members, err := a.db.UserGames(user.Id())
+	if err != nil {
+		return content, err
+	}
+	for i, g := range games {
+		content.Games = append(content.Games, a.userGameData(g, members[i]))
+	}
+	return content, nil
+}
+
+// userGameData returns the data for a game and a user's membership in it.
+func (a *App) userGameData(g GameRecord, m GameMemberRecord) GameData {
+	gd := GameData{Id: g.Id, Name: g.Name, Role: m.Role, Nation: m.Nation, Result: m.Result}
+	if !g.FinishedAt.IsZero() {
+		gd.Finished = g.FinishedAt.Format(a.timestampFormat)
+	}
+	return gd
+}
+
+// gameData returns the data for the game loaded by requireGameRole.
+func (a *App) gameData(r *http.Request) (GameData, error) {
+	user, game, member := a.currentUser(r), a.currentGame(r), a.currentGameMember(r)
+	content := GameData{
+		Id:     game.Id,
+		Name:   game.Name,
+		Role:   member.Role,
+		Nation: member.Nation,
+		IsGM:   member.HasRole(GameRoleGM) || user.Can(rbac.GameAdmin),
+		Roles:  gameRoles,
+		Result: member.Result,
+	}
+	if !game.FinishedAt.IsZero() {
+		content.Finished = game.FinishedAt.Format(a.timestampFormat)
+	}
+	if turn, err := a.latestTurn(game.Id); err == nil {
+		content.Started, content.Turn, content.NextTurn = true, turn, turn+1
+	} else if !errors.Is(err, ErrGameNotStarted) {
+		return content, err
+	}
+	members, err := a.db.GameMembers(game.Id)
+	if err != nil {
+		return content, err
+	}
+	for _, m := range members {
+		content.Members = append(content.Members, GameMemberData{Id: m.UserId, Handle: m.Handle, Role: m.Role, Nation: m.Nation, Result: m.Result})
+	}
+	if content.IsGM {
+		if content.Invites, err = a.invitesData(game.Id); err != nil {
+			return content, err
+		}
+	}
+	return content, nil
+}
+
+func (a *App) renderGame(w http.ResponseWriter, r *http.Request, t *templateHandler, user User, content GameData) {
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = content.Name
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Games", Url: "/games"},
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	t.render(w, r, payload)
+}
+
+func (a *App) renderGames(w http.ResponseWriter, r *http.Request, t *templateHandler, user User, content GamesData) {
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = "Games"
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	t.render(w, r, payload)
+}
+
+// currentGame returns the game loaded by requireGameRole.
+func (a *App) currentGame(r *http.Request) GameRecord {
+	if gc, ok := r.Context().Value(gameContextKey("game")).(gameContext); ok {
+		return gc.game
+	}
+	return GameRecord{}
+}
+
+// currentGameMember returns the current user's membership in the game loaded by requireGameRole.
+// The membership is empty if the user isn't a member, which happens for site administrators.
+func (a *App) currentGameMember(r *http.Request) GameMemberRecord {
+	if gc, ok := r.Context().Value(gameContextKey("game")).(gameContext); ok {
+		return gc.member
+	}
+	return GameMemberRecord{}
+}
+
+// gameContextKey is the context key type for storing the game in context.Context.
+type gameContextKey string
+
+// gameContext is the game and the current user's membership in it.
+type gameContext struct {
+	game   GameRecord
+	member GameMemberRecord
+}
+
+// gameParam returns the :game route parameter.
+func gameParam(r *http.Request) string {
+	return way.Param(r.Context(), "game")
+}
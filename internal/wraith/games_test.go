@@ -0,0 +1,83 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// answerGame adds the game and its members to the fake database.
+func answerGame(db *fakeDB, id, name string, members ...GameMemberRecord) {
+	now := time.Now().UTC()
+	db.answerFunc("from games where id = ?", func(args []driver.Value) [][]driver.Value {
+		if args[0] != id {
+			return nil
+		}
+		return [][]driver.Value{{id, name, nil, now, nil}}
+	})
+	db.answerFunc("where m.game_id = ? and m.user_id = ?", func(args []driver.Value) [][]driver.Value {
+		for _, m := range members {
+			if args[0] == id && args[1] == m.UserId {
+				return [][]driver.Value{{id, m.UserId, m.Handle, m.Role, int64(m.Nation), m.Result, now}}
+			}
+		}
+		return nil
+	})
+	db.answerFunc("from game_members m join users u on u.id = m.user_id", func(args []driver.Value) [][]driver.Value {
+		var rows [][]driver.Value
+		for _, m := range members {
+			if args[0] == id {
+				rows = append(rows, []driver.Value{id, m.UserId, m.Handle, m.Role, int64(m.Nation), m.Result, now})
+			}
+		}
+		return rows
+	})
+}
+
+func TestGameRoles(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-gm", Handle: "gm", Role: GameRoleGM},
+		GameMemberRecord{UserId: "u-player", Handle: "player", Role: GameRolePlayer, Nation: 1},
+		GameMemberRecord{UserId: "u-observer", Handle: "observer", Role: GameRoleObserver},
+	)
+	gm := signIn(t, a, "u-gm", "gm")
+	player := signIn(t, a, "u-player", "player")
+	observer := signIn(t, a, "u-observer", "observer")
+	outsider := signIn(t, a, "u-outsider", "outsider")
+	admin := signIn(t, a, "u-admin", "admin", "admin")
+
+	for _, tc := range []struct {
+		id      int
+		method  string
+		target  string
+		session *testSession
+		want    int
+	}{
+		{1, http.MethodGet, "/games/g1", gm, http.StatusOK},
+		{2, http.MethodGet, "/games/g1", player, http.StatusOK},
+		{3, http.MethodGet, "/games/g1", observer, http.StatusOK},
+		{4, http.MethodGet, "/games/g1", outsider, http.StatusNotFound},
+		{5, http.MethodGet, "/games/g1", nil, http.StatusNotFound},
+		{6, http.MethodGet, "/games/g1", admin, http.StatusOK},
+		{7, http.MethodGet, "/games/g2", gm, http.StatusNotFound},
+		{8, http.MethodGet, "/games/g2", admin, http.StatusNotFound},
+		{9, http.MethodPost, "/games/g1/finish", player, http.StatusNotFound},
+		{10, http.MethodPost, "/games/g1/finish", observer, http.StatusNotFound},
+		{11, http.MethodPost, "/games/g1/finish", outsider, http.StatusNotFound},
+		{12, http.MethodPost, "/games/g1/members", player, http.StatusNotFound},
+		{13, http.MethodPost, "/games/g1/finish", gm, http.StatusSeeOther},
+		{14, http.MethodPost, "/games/g1/finish", admin, http.StatusSeeOther},
+	} {
+		r := newTestRequest(a, tc.method, tc.target, nil, tc.session)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s %s: want %d, got %d", tc.id, tc.method, tc.target, tc.want, w.Code)
+		}
+	}
+	if got := len(db.executed("update games set finished_at")); got != 2 {
+		t.Errorf("finish: want 2 updates, got %d", got)
+	}
+}
@@ -3,8 +3,12 @@
 package wraith
 
 import (
+	"errors"
 	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
 	"github.com/mdhender/wraithi/internal/authn"
+	"github.com/mdhender/wraithi/internal/lockout"
+	"github.com/mdhender/wraithi/internal/passwords"
 	"github.com/mdhender/wraithi/internal/way"
 	"log"
 	"net/http"
@@ -116,6 +120,27 @@ func (a *App) assetServer(prefix, root string, spa bool) http.HandlerFunc {
 
 func (a *App) getAuthCallback() http.HandlerFunc {
 	nfh := a.notFound()
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "message")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getAuthCallback: %v", err))
+	}
+	// expired asks the user to start over when the sign-in took too long, was started
+	// in another browser, was cancelled, or the callback was replayed.
+	// That's not a fault on our side, so it isn't a server error.
+	expired := func(w http.ResponseWriter, r *http.Request) {
+		payload := Payload{Site: a.templates.site, Content: MessageData{
+			Title:   "Sign-In Expired",
+			Message: "Your sign-in has expired or was cancelled. Please try again.",
+			Link:    LinkData{Text: "Sign In", Url: "/signin"},
+		}}
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Home", Url: "/"},
+			{Text: "Sign In", Url: "/signin"},
+		}}
+		w.WriteHeader(http.StatusBadRequest)
+		t.render(w, r, payload)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		var provider authn.Provider
 		name := way.Param(r.Context(), "provider")
@@ -129,27 +154,90 @@ func (a *App) getAuthCallback() http.HandlerFunc {
 			nfh(w, r)
 			return
 		}
+		ip := lockout.IP(clientIP(r))
+		if a.throttled(w, r, ip) {
+			return
+		}
+
+		// the state must match the one bound to this browser when the login started
+		var bound string
+		if c, err := r.Cookie(a.cookies.preauth.name); err == nil {
+			bound = c.Value
+		}
+		a.clearPreAuthCookie(w)
+		if err := authn.CheckState(a.key, bound, provider.Code(), r.FormValue("state")); err != nil {
+			log.Printf("%s %s: %v\n", r.Method, r.URL.Path, err)
+			a.auditAs(r, "", audit.SignInFailed, "", "", provider.Code()+": "+err.Error())
+			if wait := a.failSignIn(r, ip); wait != 0 {
+				a.tooManyAttempts(w, wait)
+				return
+			}
+			expired(w, r)
+			return
+		}
 
 		authorization, err := provider.ProcessCallback(r)
 		if err != nil {
+			a.auditAs(r, "", audit.SignInFailed, "", "", provider.Code()+": "+err.Error())
+			if wait := a.failSignIn(r, ip); wait != 0 {
+				a.tooManyAttempts(w, wait)
+				return
+			}
+			// the nonce is used up by the first callback, so reloading or going back lands here
+			if errors.Is(err, authn.ErrInvalidNonce) || errors.Is(err, authn.ErrMissingVerifier) || errors.Is(err, authn.ErrAccessDenied) {
+				log.Printf("%s %s: %v\n", r.Method, r.URL.Path, err)
+				expired(w, r)
+				return
+			}
 			a.internalError(w, r, err)
 			return
 		}
 
-		http.Redirect(w, r, fmt.Sprintf("/users/%s", authorization.Id), http.StatusTemporaryRedirect)
-	}
-}
-
-func (a *App) getGames() http.HandlerFunc {
-	t := &templateHandler{}
-	if err := t.AddFiles(a.templates.path, "layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "games"); err != nil {
-		panic(fmt.Sprintf("[app] getGames: %v", err))
-	}
+		// the invitation, if any, came from the sign-up page
+		var invite string
+		if c, err := r.Cookie(a.cookies.invite); err == nil {
+			invite = c.Value
+			a.clearInviteCookie(w)
+		}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		payload := Payload{Site: a.templates.site}
-		payload.Page.Title = "Games"
-		a.render(w, r, t, payload)
+		current := a.currentUser(r)
+		user, err := a.userForIdentity(r, provider.Code(), authorization, invite)
+		if errors.Is(err, ErrInviteRequired) || errors.Is(err, ErrInvalidInvite) {
+			log.Printf("%s %s: %v\n", r.Method, r.URL.Path, err)
+			payload := Payload{Site: a.templates.site, Content: MessageData{
+				Title:   "Invitation Required",
+				Message: "You don't have an account yet, and " + err.Error() + ". Ask a game master for an invitation.",
+				Link:    LinkData{Text: "Sign Up", Url: "/signup"},
+			}}
+			payload.Site.NavBar = NavBarData{Links: []LinkData{
+				{Text: "Home", Url: "/"},
+				{Text: "Sign In", Url: "/signin"},
+			}}
+			w.WriteHeader(http.StatusForbidden)
+			t.render(w, r, payload)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		// linking a provider from the profile page keeps the current session
+		if current.IsAuthenticated() {
+			http.Redirect(w, r, fmt.Sprintf("/users/%s", user.Id), http.StatusSeeOther)
+			return
+		}
+		next, err := a.signInUser(w, r, user)
+		if errors.Is(err, ErrAccountDisabled) {
+			log.Printf("%s %s: %q: %v\n", r.Method, r.URL, user.Id, err)
+			a.auditAs(r, "", audit.SignInFailed, audit.TargetUser, user.Id, provider.Code()+": account disabled")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if !user.TOTPEnabled {
+			a.auditAs(r, user.Id, audit.SignIn, audit.TargetUser, user.Id, provider.Code())
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
 	}
 }
 
@@ -188,83 +276,93 @@ func (a *App) getIndex() func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (a *App) getSignIn() func(w http.ResponseWriter, r *http.Request) {
-	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "signin")
+func (a *App) getSessions() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "sessions")
 	if err != nil {
-		panic(fmt.Sprintf("[app] getSignIn: %v", err))
+		panic(fmt.Sprintf("[app] getSessions: %v", err))
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, &http.Cookie{
-			Name:     a.cookies.name,
-			Path:     "/",
-			HttpOnly: a.cookies.httpOnly,
-			Secure:   a.cookies.secure,
-		})
-
-		payload := Payload{Site: a.templates.site}
+		user := a.currentUser(r)
+		list, err := a.sessions.store.UserSessions(user.Id())
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		var content SessionsData
+		for _, s := range list {
+			content.Sessions = append(content.Sessions, SessionData{
+				Id:         s.Id,
+				Device:     s.Device,
+				IP:         s.IP,
+				CreatedAt:  s.CreatedAt.Format(a.timestampFormat),
+				LastSeenAt: s.LastSeenAt.Format(a.timestampFormat),
+				Current:    s.Id == user.sessionId,
+			})
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = "Sessions"
 		payload.Site.NavBar = NavBarData{Links: []LinkData{
-			{Text: "Home", Url: "/"},
+			{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
 			{Text: "Documentation", Url: "/docs"},
+			{Text: "Sign Out", Url: "/signout"},
 		}}
 		t.render(w, r, payload)
 	}
 }
 
-func (a *App) getSignOut() func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, &http.Cookie{
-			Name:     a.cookies.name,
-			Path:     "/",
-			HttpOnly: a.cookies.httpOnly,
-			Secure:   a.cookies.secure,
-		})
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-	}
-}
-
-func (a *App) getUsers() http.HandlerFunc {
-	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "users")
+func (a *App) getSignIn() func(w http.ResponseWriter, r *http.Request) {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "signin")
 	if err != nil {
-		panic(fmt.Sprintf("[app] getUsers: %v", err))
+		panic(fmt.Sprintf("[app] getSignIn: %v", err))
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		user := a.currentUser(r)
-		log.Printf("%s %s: user %+v\n", r.Method, r.URL, user)
-		if !user.IsAuthenticated() {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		} else if !user.IsAdmin() {
-			http.Redirect(w, r, fmt.Sprintf("/users/%s", user.Id()), http.StatusSeeOther)
-			return
+		a.clearSessionCookie(w)
+
+		content := SignInData{CSRFToken: csrfTokenFor(r)}
+		for _, p := range a.authn {
+			content.Providers = append(content.Providers, ProviderData{Code: p.Code(), Name: p.Name()})
 		}
-		payload := Payload{Site: a.templates.site}
+		payload := Payload{Site: a.templates.site, Content: content}
 		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Home", Url: "/"},
 			{Text: "Documentation", Url: "/docs"},
-			{Text: "Sign Out", Url: "/signout"},
 		}}
 		t.render(w, r, payload)
 	}
 }
 
-func (a *App) getUsersId() http.HandlerFunc {
-	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "users")
+func (a *App) getSignOut() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sid := a.currentUser(r).sessionId; sid != "" {
+			if err := a.sessions.store.Revoke(sid); err != nil {
+				log.Printf("%s %s: revoke: %v\n", r.Method, r.URL, err)
+			}
+		}
+		a.clearSessionCookie(w)
+		a.clearImpersonatorCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+func (a *App) getSignUp() func(w http.ResponseWriter, r *http.Request) {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "signup")
 	if err != nil {
-		panic(fmt.Sprintf("[app] getUsersId: %v", err))
+		panic(fmt.Sprintf("[app] getSignUp: %v", err))
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		user := a.currentUser(r)
-		log.Printf("%s %s: user %+v\n", r.Method, r.URL, user)
-		if !user.IsAuthenticated() {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+		content, err := a.signUpData(r, strings.TrimSpace(r.FormValue("invite")))
+		if err != nil {
+			a.internalError(w, r, err)
 			return
 		}
-		payload := Payload{Site: a.templates.site}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = "Sign Up"
 		payload.Site.NavBar = NavBarData{Links: []LinkData{
-			{Text: "Documentation", Url: "/docs"},
-			{Text: "Sign Out", Url: "/signout"},
+			{Text: "Home", Url: "/"},
+			{Text: "Sign In", Url: "/signin"},
 		}}
 		t.render(w, r, payload)
 	}
@@ -370,27 +468,62 @@ func (a *App) postAuthLogin() http.HandlerFunc {
 			nfh(w, r)
 			return
 		}
-		url, err := provider.LoginURL()
+		url, state, err := provider.LoginURL()
 		if err != nil {
 			a.internalError(w, r, err)
 			return
 		}
 		log.Printf("%s %s: url %q\n", r.Method, r.URL, url)
+		a.setPreAuthCookie(w, provider.Code(), state)
+		if invite := strings.TrimSpace(r.FormValue("invite")); invite != "" && len(invite) <= 64 {
+			a.setInviteCookie(w, invite)
+		}
 
 		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 	}
 }
 
+// postSessionsRevoke revokes one of the current user's sessions.
+// Revoking the current session signs the user out.
+func (a *App) postSessionsRevoke() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		session, err := a.sessions.store.Lookup(way.Param(r.Context(), "sid"))
+		if err != nil || session.UserId != user.Id() {
+			nfh(w, r)
+			return
+		} else if err = a.sessions.store.Revoke(session.Id); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		if session.Id == user.sessionId {
+			a.clearSessionCookie(w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+	}
+}
+
+// postSessionsRevokeAll signs the current user out everywhere.
+func (a *App) postSessionsRevokeAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := a.sessions.store.RevokeUser(a.currentUser(r).Id()); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.clearSessionCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
 func (a *App) postSignIn() func(w http.ResponseWriter, r *http.Request) {
 	type input struct {
 		Email    string
 		Password string
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Hx-Request") != "true" {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
 		// _ = r.ParseForm()
 		// log.Printf("%s %s: form %+v\n", r.Method, r.URL, r.Form)
 		input := input{
@@ -398,21 +531,157 @@ func (a *App) postSignIn() func(w http.ResponseWriter, r *http.Request) {
 			Password: r.FormValue("password"),
 		}
 		// log.Printf("%s %s: input %+v\n", r.Method, r.URL, input)
-		var user User
-		if input.Email == "user@example.com" && input.Password == "password" {
-			user.id, user.handle, user.roles = "1", "admin", []string{"authenticated", "admin"}
+		ip := lockout.IP(clientIP(r))
+		if a.throttled(w, r, ip) {
+			return
+		}
+		user, err := a.db.UserByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Printf("%s %s: %v\n", r.Method, r.URL, err)
+			} else {
+				a.auditAs(r, "", audit.SignInFailed, "", "", "password: unknown e-mail")
+				if wait := a.failSignIn(r, ip); wait != 0 {
+					a.tooManyAttempts(w, wait)
+					return
+				}
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
 		}
-		if user.id == "" {
+		// a locked account is refused before the password is checked so that guessing can't continue
+		account := lockout.Account(user.Id)
+		if a.throttled(w, r, account) {
+			return
+		} else if !passwords.Match(user.HashedPassword, input.Password, a.salt) {
+			a.auditAs(r, "", audit.SignInFailed, audit.TargetUser, user.Id, "password: wrong password")
+			if wait := a.failSignIn(r, account, ip); wait != 0 {
+				a.tooManyAttempts(w, wait)
+				return
+			}
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		http.SetCookie(w, &http.Cookie{
-			Name:     a.cookies.name,
-			Path:     "/",
-			Value:    "authenticated",
-			HttpOnly: a.cookies.httpOnly,
-			Secure:   a.cookies.secure,
-		})
-		http.Redirect(w, r, fmt.Sprintf("/users/%s", user.id), http.StatusSeeOther)
+		next, err := a.signInUser(w, r, user)
+		if errors.Is(err, ErrAccountDisabled) {
+			log.Printf("%s %s: %q: %v\n", r.Method, r.URL, user.Id, err)
+			a.auditAs(r, "", audit.SignInFailed, audit.TargetUser, user.Id, "password: account disabled")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if !user.TOTPEnabled {
+			// users with two-factor authentication are signed in when they present the second factor
+			a.succeedSignIn(r, user.Id)
+			a.auditAs(r, user.Id, audit.SignIn, audit.TargetUser, user.Id, "password")
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}
+}
+
+func (a *App) postSignUp() func(w http.ResponseWriter, r *http.Request) {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "signup")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postSignUp: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		handle, email := strings.TrimSpace(r.FormValue("handle")), strings.TrimSpace(r.FormValue("email"))
+		password, confirm := r.FormValue("password"), r.FormValue("confirm")
+		invite := strings.TrimSpace(r.FormValue("invite"))
+
+		var user UserRecord
+		err := validateSignUp(handle, email, password, confirm)
+		var redeemed InviteRecord
+		if err == nil && invite == "" && a.inviteOnly {
+			err = ErrInviteRequired
+		} else if err == nil && invite != "" {
+			_, err = a.db.InviteByHash(a.hashInviteCode(invite))
+		}
+		if err == nil {
+			var hashed string
+			if hashed, err = passwords.Hash(password, a.salt); err == nil && invite != "" {
+				// the account and the use of the invitation are saved together or not at all
+				user, redeemed, err = a.db.CreateUserWithInvite(handle, email, hashed, a.hashInviteCode(invite))
+			} else if err == nil {
+				user, err = a.db.CreateUser(handle, email, hashed)
+			}
+		}
+		if err != nil {
+			input, lerr := a.signUpData(r, invite)
+			if lerr != nil {
+				a.internalError(w, r, lerr)
+				return
+			}
+			input.Handle, input.Email = handle, email
+			switch {
+			case errors.Is(err, ErrDuplicateEmail), errors.Is(err, ErrDuplicateHandle),
+				errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrInvalidHandle), errors.Is(err, ErrInvalidPassword),
+				errors.Is(err, ErrInviteRequired), errors.Is(err, ErrInvalidInvite):
+				input.Error = err.Error()
+			default:
+				log.Printf("%s %s: %v\n", r.Method, r.URL, err)
+				input.Error = "unable to create account"
+			}
+			payload := Payload{Site: a.templates.site, Content: input}
+			payload.Page.Title = "Sign Up"
+			payload.Site.NavBar = NavBarData{Links: []LinkData{
+				{Text: "Home", Url: "/"},
+				{Text: "Sign In", Url: "/signin"},
+			}}
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			t.render(w, r, payload)
+			return
+		}
+		log.Printf("%s %s: created user %q %q\n", r.Method, r.URL, user.Id, user.Handle)
+		if redeemed.Id != "" {
+			a.auditAs(r, user.Id, audit.InviteRedeem, audit.TargetInvite, redeemed.Id, redeemed.GameId)
+		}
+		a.auditAs(r, user.Id, audit.SignUp, audit.TargetUser, user.Id, "password")
+		if err := a.sendVerification(r, user); err != nil {
+			log.Printf("%s %s: verify: %v\n", r.Method, r.URL, err)
+		}
+
+		if err := a.setSessionCookie(w, r, user); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/users/%s", user.Id), http.StatusSeeOther)
+	}
+}
+
+// postUsersIdSessionsRevoke lets an administrator kill every session for a user.
+func (a *App) postUsersIdSessionsRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if err := a.sessions.store.RevokeUser(id); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q revoked all sessions for %q\n", r.Method, r.URL, a.currentUser(r).Id(), id)
+		a.audit(r, audit.AdminRevokeSession, audit.TargetUser, id, "")
+		a.adminDone(w, r, id, "The user is signed out everywhere.", "")
+	}
+}
+
+// postUsersIdIdentitiesUnlink removes one of the current user's external identities.
+func (a *App) postUsersIdIdentitiesUnlink() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if id := way.Param(r.Context(), "id"); id != user.Id() {
+			nfh(w, r)
+			return
+		}
+		if err := a.db.UnlinkIdentity(user.Id(), way.Param(r.Context(), "provider")); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				nfh(w, r)
+				return
+			}
+			a.internalError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/users/%s", user.Id()), http.StatusSeeOther)
 	}
 }
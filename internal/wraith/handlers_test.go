@@ -0,0 +1,59 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAuthCallbackReplay(t *testing.T) {
+	a, _ := newTestApp(t)
+
+	// start a login to get a state nonce and the cookie that binds it to the browser
+	w := serve(a, newTestRequest(a, http.MethodPost, "/auth/login", url.Values{"provider": {"github"}}, nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("login: want %d, got %d", http.StatusTemporaryRedirect, w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	state := location.Query().Get("state")
+	var preauth *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == a.cookies.preauth.name {
+			preauth = c
+		}
+	}
+	if state == "" || preauth == nil {
+		t.Fatalf("login: want state and cookie, got %q and %v", state, preauth)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		query  url.Values
+		cookie *http.Cookie
+		want   int
+	}{
+		// the user declined on the provider's consent page, which uses up the nonce
+		{1, url.Values{"state": {state}, "error": {"access_denied"}}, preauth, http.StatusBadRequest},
+		// then pressed back, or reloaded the callback
+		{2, url.Values{"state": {state}, "code": {"code"}}, preauth, http.StatusBadRequest},
+		{3, url.Values{"state": {state}, "code": {"code"}}, nil, http.StatusBadRequest},
+		{4, url.Values{"state": {"unknown"}, "code": {"code"}}, preauth, http.StatusBadRequest},
+	} {
+		r := newTestRequest(a, http.MethodGet, "/auth/callback/github?"+tc.query.Encode(), nil, nil)
+		if tc.cookie != nil {
+			r.AddCookie(tc.cookie)
+		}
+		w := serve(a, r)
+		if w.Code != tc.want {
+			t.Errorf("%d: want %d, got %d", tc.id, tc.want, w.Code)
+		} else if !strings.Contains(w.Body.String(), "Sign-In Expired") {
+			t.Errorf("%d: want sign-in expired page, got %q", tc.id, w.Body.String())
+		}
+	}
+}
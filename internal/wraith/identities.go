@@ -0,0 +1,241 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/authn"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IdentityRecord is an external identity linked to a local user.
+type IdentityRecord struct {
+	Provider  string // provider code
+	Subject   string // the provider's id for the user
+	UserId    string
+	Email     string
+	Name      string
+	Avatar    string
+	CreatedAt time.Time
+}
+
+// Identity returns the identity for the provider and subject.
+// Returns ErrNotFound if the identity isn't linked to any user.
+func (db *DB) Identity(provider, subject string) (IdentityRecord, error) {
+	var i IdentityRecord
+	row := db.db.QueryRowContext(db.context,
+		"select provider, subject, user_id, email, name, avatar, created_at from user_identities where provider = ? and subject = ?",
+		provider, subject)
+	if err := row.Scan(&i.Provider, &i.Subject, &i.UserId, &i.Email, &i.Name, &i.Avatar, &i.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IdentityRecord{}, ErrNotFound
+		}
+		return IdentityRecord{}, err
+	}
+	return i, nil
+}
+
+// UserIdentities returns the identities linked to the user.
+func (db *DB) UserIdentities(userId string) ([]IdentityRecord, error) {
+	rows, err := db.db.QueryContext(db.context,
+		"select provider, subject, user_id, email, name, avatar, created_at from user_identities where user_id = ? order by provider",
+		userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []IdentityRecord
+	for rows.Next() {
+		var i IdentityRecord
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.UserId, &i.Email, &i.Name, &i.Avatar, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, i)
+	}
+	return list, rows.Err()
+}
+
+// LinkIdentity links the external identity to the user.
+// Returns ErrIdentityLinked if the identity belongs to another user
+// or if the user already has an identity from the provider.
+func (db *DB) LinkIdentity(userId, provider string, auth authn.Authentication) (IdentityRecord, error) {
+	if i, err := db.Identity(provider, auth.Id); err == nil {
+		if i.UserId != userId {
+			return IdentityRecord{}, ErrIdentityLinked
+		}
+		return i, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return IdentityRecord{}, err
+	}
+	i := IdentityRecord{
+		Provider:  provider,
+		Subject:   auth.Id,
+		UserId:    userId,
+		Email:     auth.Email,
+		Name:      auth.Name,
+		Avatar:    auth.Avatar,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err := db.db.ExecContext(db.context,
+		"insert into user_identities (provider, subject, user_id, email, name, avatar, created_at) values (?, ?, ?, ?, ?, ?, ?)",
+		i.Provider, i.Subject, i.UserId, i.Email, i.Name, i.Avatar, i.CreatedAt)
+	if err != nil {
+		// the unique key on (user_id, provider) means they already have one from this provider
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return IdentityRecord{}, ErrIdentityLinked
+		}
+		return IdentityRecord{}, err
+	}
+	// the first provider with an avatar supplies the user's default avatar
+	if isAvatarURL(i.Avatar) {
+		if _, err := db.db.ExecContext(db.context, "update users set avatar_url = ? where id = ? and avatar_url = ''", i.Avatar, userId); err != nil {
+			return IdentityRecord{}, err
+		}
+	}
+	return i, nil
+}
+
+// UnlinkIdentity removes the user's identity from the provider.
+// Returns ErrLastCredential if the user would be left without a way to sign in.
+func (db *DB) UnlinkIdentity(userId, provider string) error {
+	user, err := db.UserById(userId)
+	if err != nil {
+		return err
+	}
+	identities, err := db.UserIdentities(userId)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, i := range identities {
+		found = found || i.Provider == provider
+	}
+	if !found {
+		return ErrNotFound
+	} else if len(identities) == 1 && user.HashedPassword == "" {
+		return ErrLastCredential
+	}
+	_, err = db.db.ExecContext(db.context, "delete from user_identities where user_id = ? and provider = ?", userId, provider)
+	return err
+}
+
+// userForIdentity returns the local user for an identity returned by a provider.
+//
+// If the identity is already linked, its user is returned.
+// If not, the identity is linked to the signed-in user, if there is one.
+// Otherwise, when the provider says the email is verified, it is linked to the
+// account with that address, but only if that account's address has been
+// verified too, since an unverified address could have been registered by
+// anybody. As a last resort, a new user is created, which uses up the
+// invitation. The invitation is required if the server is invite-only.
+func (a *App) userForIdentity(r *http.Request, provider string, auth authn.Authentication, invite string) (UserRecord, error) {
+	current := a.currentUser(r)
+	if auth.Id == "" {
+		return UserRecord{}, ErrNotFound
+	}
+	if i, err := a.db.Identity(provider, auth.Id); err == nil {
+		if current.IsAuthenticated() && current.Id() != i.UserId {
+			return UserRecord{}, ErrIdentityLinked
+		}
+		return a.db.UserById(i.UserId)
+	} else if !errors.Is(err, ErrNotFound) {
+		return UserRecord{}, err
+	}
+
+	// link to the signed-in user
+	if current.IsAuthenticated() {
+		if _, err := a.db.LinkIdentity(current.Id(), provider, auth); err != nil {
+			return UserRecord{}, err
+		}
+		return a.db.UserById(current.Id())
+	}
+
+	// link to an existing account by verified email
+	if auth.VerifiedEmail && auth.Email != "" {
+		if u, err := a.db.UserByEmail(auth.Email); err == nil && u.EmailVerified {
+			if _, err := a.db.LinkIdentity(u.Id, provider, auth); err != nil {
+				return UserRecord{}, err
+			}
+			return u, nil
+		} else if err != nil && !errors.Is(err, ErrNotFound) {
+			return UserRecord{}, err
+		}
+	}
+
+	// first login, so check the invitation before creating a new user.
+	var hashedCode string
+	if invite == "" && a.inviteOnly {
+		return UserRecord{}, ErrInviteRequired
+	} else if invite != "" {
+		hashedCode = a.hashInviteCode(invite)
+		if _, err := a.db.InviteByHash(hashedCode); err != nil {
+			return UserRecord{}, err
+		}
+	}
+	// we only keep the address if it's verified and no other account is using it.
+	email := ""
+	if auth.VerifiedEmail {
+		if _, err := a.db.UserByEmail(auth.Email); errors.Is(err, ErrNotFound) {
+			email = auth.Email
+		}
+	}
+	// the account and the use of the invitation are saved together or not at all
+	var u UserRecord
+	var redeemed InviteRecord
+	var err error
+	base := handleFrom(auth)
+	for attempt, handle := 0, base; attempt < 10; attempt++ {
+		if u, redeemed, err = a.db.createUser(handle, email, auth.VerifiedEmail, "", hashedCode); !errors.Is(err, ErrDuplicateHandle) {
+			break
+		}
+		n, rerr := rand.Int(rand.Reader, big.NewInt(10_000))
+		if rerr != nil {
+			return UserRecord{}, rerr
+		}
+		handle = fmt.Sprintf("%s-%04d", base, n.Int64())
+	}
+	if err != nil {
+		return UserRecord{}, err
+	}
+	if _, err := a.db.LinkIdentity(u.Id, provider, auth); err != nil {
+		return UserRecord{}, err
+	}
+	if redeemed.Id != "" {
+		a.auditAs(r, u.Id, audit.InviteRedeem, audit.TargetInvite, redeemed.Id, redeemed.GameId)
+	}
+	a.auditAs(r, u.Id, audit.SignUp, audit.TargetUser, u.Id, provider)
+	return u, nil
+}
+
+// handleFrom suggests a handle based on the name or email from a provider.
+func handleFrom(auth authn.Authentication) string {
+	name := auth.Name
+	if name == "" {
+		name, _, _ = strings.Cut(auth.Email, "@")
+	}
+	var sb strings.Builder
+	for _, ch := range name {
+		switch {
+		case ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') || ch == '_' || ch == '-':
+			sb.WriteRune(ch)
+		case ch == ' ' || ch == '.':
+			sb.WriteByte('_')
+		}
+		if sb.Len() >= 24 {
+			break
+		}
+	}
+	if sb.Len() < 3 {
+		return "player"
+	}
+	return sb.String()
+}
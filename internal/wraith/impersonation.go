@@ -0,0 +1,167 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"net/http"
+	"time"
+)
+
+// impersonationTTL limits how long an administrator can act as another user.
+const impersonationTTL = time.Hour
+
+// withImpersonationAudit records every request made while impersonating in the audit log.
+// It must run after withUser. If the request can't be recorded, it isn't served.
+func (a *App) withImpersonationAudit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := a.currentUser(r); u.IsImpersonated() {
+			if err := a.recordAudit(r, audit.AdminImpersonated, "", "", r.Method+" "+r.URL.RequestURI()); err != nil {
+				a.internalError(w, r, err)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// notImpersonating rejects requests made while an administrator is acting as another user.
+// It protects actions that only the real user should take, like changing credentials.
+func (a *App) notImpersonating() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u := a.currentUser(r); u.IsImpersonated() {
+				log.Printf("%s %s: %q acting as %q: blocked\n", r.Method, r.URL, u.impersonatorId, u.Id())
+				http.Error(w, "This action is not allowed while acting as another user.", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clearImpersonatorCookie tells the browser to delete the administrator's saved session.
+func (a *App) clearImpersonatorCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookies.impersonator,
+		Path:     "/",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   a.cookies.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// postUsersIdImpersonate starts acting as another user.
+// The administrator's own session cookie is set aside and restored when they stop.
+func (a *App) postUsersIdImpersonate() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := a.currentUser(r)
+		target, err := a.db.UserById(way.Param(r.Context(), "id"))
+		if errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if target.Id == admin.Id() {
+			a.adminDone(w, r, target.Id, "", "You can't act as yourself.")
+			return
+		} else if target.Disabled {
+			a.adminDone(w, r, target.Id, "", "You can't act as a disabled user.")
+			return
+		}
+		own, err := r.Cookie(a.cookies.name)
+		if err != nil {
+			// impersonation is only started from a browser session
+			nfh(w, r)
+			return
+		}
+
+		session, err := a.sessions.store.Impersonate(admin.Id(), target.Id, r.UserAgent(), clientIP(r), impersonationTTL)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		token, t, err := a.sessions.signer.Issue(session.Id, target.Id, target.Handle, target.Roles)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     a.cookies.impersonator,
+			Path:     "/",
+			Value:    own.Value,
+			Expires:  t.ExpiresAt,
+			HttpOnly: true,
+			Secure:   a.cookies.secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.SetCookie(w, &http.Cookie{
+			Name:     a.cookies.name,
+			Path:     "/",
+			Value:    token,
+			Expires:  t.ExpiresAt,
+			HttpOnly: a.cookies.httpOnly,
+			Secure:   a.cookies.secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		log.Printf("%s %s: %q started acting as %q in session %q\n", r.Method, r.URL, admin.Id(), target.Id, session.Id)
+		a.audit(r, audit.AdminImpersonate, audit.TargetUser, target.Id, "session "+session.Id)
+		next := fmt.Sprintf("/users/%s", target.Id)
+		if r.Header.Get("Hx-Request") == "true" {
+			w.Header().Set("HX-Redirect", next)
+			return
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}
+}
+
+// postImpersonateStop ends the impersonation and restores the administrator's own session.
+func (a *App) postImpersonateStop() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := a.currentUser(r)
+		if !u.IsImpersonated() {
+			nfh(w, r)
+			return
+		}
+		if err := a.sessions.store.Revoke(u.sessionId); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q stopped acting as %q\n", r.Method, r.URL, u.impersonatorId, u.Id())
+		a.auditAs(r, u.impersonatorId, audit.AdminImpersonateStop, audit.TargetUser, u.Id(), "session "+u.sessionId)
+
+		a.clearImpersonatorCookie(w)
+		// the saved cookie must still be a valid session for the impersonator
+		own, err := r.Cookie(a.cookies.impersonator)
+		if err != nil {
+			a.clearSessionCookie(w)
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+		t, err := a.sessions.signer.Verify(own.Value)
+		if err != nil || t.UserId != u.impersonatorId {
+			a.clearSessionCookie(w)
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     a.cookies.name,
+			Path:     "/",
+			Value:    own.Value,
+			Expires:  t.ExpiresAt,
+			HttpOnly: a.cookies.httpOnly,
+			Secure:   a.cookies.secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, fmt.Sprintf("/users/%s/admin", u.Id()), http.StatusSeeOther)
+	}
+}
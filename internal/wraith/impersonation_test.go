@@ -0,0 +1,71 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"github.com/mdhender/wraithi/internal/audit"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// impersonate starts a session in which the administrator acts as the user.
+func impersonate(t *testing.T, a *App, adminId, userId, handle string, roles ...string) *testSession {
+	t.Helper()
+	session, err := a.sessions.store.Impersonate(adminId, userId, "test", "192.0.2.1", impersonationTTL)
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	token, _, err := a.sessions.signer.Issue(session.Id, userId, handle, roles)
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	return &testSession{id: session.Id, cookie: &http.Cookie{Name: a.cookies.name, Value: token}}
+}
+
+func TestImpersonationBlocks(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-bob", Handle: "bob", Role: GameRolePlayer, Nation: 1},
+	)
+	// the administrator acts as a player; the session carries the player's roles, not theirs
+	s := impersonate(t, a, "u-admin", "u-bob", "bob")
+
+	for _, tc := range []struct {
+		id     int
+		method string
+		target string
+		form   url.Values
+		want   int
+	}{
+		{1, http.MethodGet, "/games/g1", nil, http.StatusOK},
+		{2, http.MethodPost, "/games/g1/orders", url.Values{"orders": {"build 1 scout at 1"}}, http.StatusForbidden},
+		{3, http.MethodGet, "/sessions", nil, http.StatusForbidden},
+		{4, http.MethodGet, "/users/u-bob/tokens", nil, http.StatusForbidden},
+		{5, http.MethodPost, "/users/u-bob/profile", url.Values{"timezone": {"UTC"}}, http.StatusForbidden},
+		{6, http.MethodPost, "/users/u-carol/impersonate", nil, http.StatusForbidden},
+	} {
+		r := newTestRequest(a, tc.method, tc.target, tc.form, s)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s %s: want %d, got %d", tc.id, tc.method, tc.target, tc.want, w.Code)
+		}
+	}
+	if list := db.executed("insert into orders"); len(list) != 0 {
+		t.Errorf("orders: want none saved, got %d", len(list))
+	}
+
+	// every request, blocked or not, is in the audit log with the administrator
+	list := db.executed("insert into audit_events")
+	var got int
+	for _, e := range list {
+		if e.args[3] == audit.AdminImpersonated {
+			got++
+			if e.args[1] != "u-bob" || e.args[2] != "u-admin" {
+				t.Errorf("audit: want u-bob by u-admin, got %v by %v", e.args[1], e.args[2])
+			}
+		}
+	}
+	if got != 6 {
+		t.Errorf("audit: want 6 requests recorded, got %d", got)
+	}
+}
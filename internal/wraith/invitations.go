@@ -0,0 +1,491 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/rbac"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inviteCodeAlphabet leaves out letters and digits that are easy to confuse
+// since people read codes to each other and type them in.
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// inviteLifetimes are the choices for how long an invitation lasts, in days.
+// Zero means it never expires.
+var inviteLifetimes = []int{1, 7, 30, 0}
+
+// InviteRecord is an invitation as stored in the database.
+// Only the hash of the code is stored.
+type InviteRecord struct {
+	Id        string
+	CreatedBy string
+	Creator   string // handle of the user who created the invitation
+	GameId    string // empty if the invitation isn't for a game
+	GameName  string
+	MaxUses   int // zero for unlimited
+	Uses      int
+	CreatedAt time.Time
+	ExpiresAt time.Time // zero if the invitation never expires
+}
+
+// IsUsable returns true if the invitation can still be redeemed at the given time.
+func (i InviteRecord) IsUsable(now time.Time) bool {
+	return (i.MaxUses == 0 || i.Uses < i.MaxUses) && (i.ExpiresAt.IsZero() || now.Before(i.ExpiresAt))
+}
+
+// CreateInvite saves a new invitation.
+func (db *DB) CreateInvite(createdBy, hashedCode, gameId string, maxUses int, ttl time.Duration) (InviteRecord, error) {
+	now := time.Now().UTC()
+	i := InviteRecord{
+		Id:        uuid.NewString(),
+		CreatedBy: createdBy,
+		GameId:    gameId,
+		MaxUses:   maxUses,
+		CreatedAt: now,
+	}
+	if ttl != 0 {
+		i.ExpiresAt = now.Add(ttl)
+	}
+	_, err := db.db.ExecContext(db.context,
+		"insert into invitations (id, hashed_code, created_by, game_id, max_uses, uses, created_at, expires_at) values (?, ?, ?, ?, ?, 0, ?, ?)",
+		i.Id, hashedCode, i.CreatedBy, sql.NullString{String: i.GameId, Valid: i.GameId != ""}, i.MaxUses, i.CreatedAt, sql.NullTime{Time: i.ExpiresAt, Valid: !i.ExpiresAt.IsZero()})
+	if err != nil {
+		return InviteRecord{}, err
+	}
+	return i, nil
+}
+
+// inviteColumns are the columns read by scanInvite.
+const inviteColumns = `i.id, i.created_by, coalesce(u.handle, ''), i.game_id, coalesce(g.name, ''), i.max_uses, i.uses, i.created_at, i.expires_at
+	from invitations i left join users u on u.id = i.created_by left join games g on g.id = i.game_id`
+
+// scanInvite reads an invitation selected with inviteColumns.
+func scanInvite(scan func(dest ...any) error) (InviteRecord, error) {
+	var i InviteRecord
+	var gameId sql.NullString
+	var expiresAt sql.NullTime
+	if err := scan(&i.Id, &i.CreatedBy, &i.Creator, &gameId, &i.GameName, &i.MaxUses, &i.Uses, &i.CreatedAt, &expiresAt); err != nil {
+		return InviteRecord{}, err
+	}
+	i.GameId, i.ExpiresAt = gameId.String, expiresAt.Time
+	return i, nil
+}
+
+// Invites returns the invitations for the game, or every invitation if the game is empty.
+// Invitations that can't be used any more are included.
+func (db *DB) Invites(gameId string) ([]InviteRecord, error) {
+	query, args := "select "+inviteColumns+" order by i.created_at desc", []any{}
+	if gameId != "" {
+		query, args = "select "+inviteColumns+" where i.game_id = ? order by i.created_at desc", append(args, gameId)
+	}
+	rows, err := db.db.QueryContext(db.context, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []InviteRecord
+	for rows.Next() {
+		i, err := scanInvite(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, i)
+	}
+	return list, rows.Err()
+}
+
+// InviteByHash returns the invitation with the given hash.
+// Returns ErrInvalidInvite if there is no such invitation or it can't be used any more.
+func (db *DB) InviteByHash(hashedCode string) (InviteRecord, error) {
+	i, err := scanInvite(db.db.QueryRowContext(db.context, "select "+inviteColumns+" where i.hashed_code = ?", hashedCode).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return InviteRecord{}, ErrInvalidInvite
+	} else if err != nil {
+		return InviteRecord{}, err
+	} else if !i.IsUsable(time.Now()) {
+		return InviteRecord{}, ErrInvalidInvite
+	}
+	return i, nil
+}
+
+// RedeemInvite uses the invitation for the user.
+// If the invitation is for a game, the user joins it as an observer
+// until the game master gives them a nation; members keep their role.
+// Returns ErrInvalidInvite if the invitation can't be used any more.
+func (db *DB) RedeemInvite(hashedCode, userId string) (InviteRecord, error) {
+	tx, err := db.db.BeginTx(db.context, nil)
+	if err != nil {
+		return InviteRecord{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	i, err := db.lockInvite(tx, hashedCode)
+	if err != nil {
+		return InviteRecord{}, err
+	} else if err := db.useInvite(tx, &i, userId); err != nil {
+		return InviteRecord{}, err
+	}
+	return i, tx.Commit()
+}
+
+// lockInvite reads the invitation for update, so that nobody else can use it
+// until the transaction ends.
+// Returns ErrInvalidInvite if there is no such invitation or it can't be used any more.
+func (db *DB) lockInvite(tx *sql.Tx, hashedCode string) (InviteRecord, error) {
+	i, err := scanInvite(tx.QueryRowContext(db.context, "select "+inviteColumns+" where i.hashed_code = ? for update", hashedCode).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return InviteRecord{}, ErrInvalidInvite
+	} else if err != nil {
+		return InviteRecord{}, err
+	} else if !i.IsUsable(time.Now()) {
+		return InviteRecord{}, ErrInvalidInvite
+	}
+	return i, nil
+}
+
+// useInvite counts a use of an invitation locked by lockInvite.
+// If the invitation is for a game, the user joins it as an observer
+// until the game master gives them a nation; members keep their role.
+func (db *DB) useInvite(tx *sql.Tx, i *InviteRecord, userId string) error {
+	if _, err := tx.ExecContext(db.context, "update invitations set uses = uses + 1 where id = ?", i.Id); err != nil {
+		return err
+	}
+	if i.GameId != "" {
+		_, err := tx.ExecContext(db.context,
+			"insert into game_members (game_id, user_id, role, nation, result, joined_at) values (?, ?, ?, null, '', ?) on duplicate key update game_id = game_id",
+			i.GameId, userId, GameRoleObserver, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+	i.Uses++
+	return nil
+}
+
+// DeleteInvite deletes the invitation.
+// If the game isn't empty, the invitation must be for that game.
+// Returns ErrNotFound if there is no such invitation.
+func (db *DB) DeleteInvite(id, gameId string) error {
+	query, args := "delete from invitations where id = ?", []any{id}
+	if gameId != "" {
+		query, args = query+" and game_id = ?", append(args, gameId)
+	}
+	result, err := db.db.ExecContext(db.context, query, args...)
+	if err != nil {
+		return err
+	} else if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// newInviteCode returns a new invitation code, like ABCD-EFGH-JKLM, and its hash.
+func (a *App) newInviteCode() (code, hashed string, err error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+	for n := 0; n < 12; n++ {
+		if n != 0 && n%4 == 0 {
+			sb.WriteByte('-')
+		}
+		ch, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", "", err
+		}
+		sb.WriteByte(inviteCodeAlphabet[ch.Int64()])
+	}
+	code = sb.String()
+	return code, a.hashInviteCode(code), nil
+}
+
+// hashInviteCode returns the keyed hash of an invitation code.
+// Codes are compared without case, spaces, or dashes since people type them.
+func (a *App) hashInviteCode(code string) string {
+	code = strings.Map(func(ch rune) rune {
+		if ch == '-' || ch == ' ' {
+			return -1
+		}
+		return ch
+	}, strings.ToUpper(strings.TrimSpace(code)))
+	mac := hmac.New(sha256.New, []byte(a.salt))
+	mac.Write([]byte("invite:" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// InvitesData is the data for a list of invitations and the form to create one.
+type InvitesData struct {
+	GameId    string // set when the invitations are for one game
+	GameName  string
+	Invites   []InviteData
+	Lifetimes []int // choices for the lifetime, in days; zero is never
+	NewCode   string
+	NewLink   string // sign-up link with the new code
+	Error     string
+}
+
+// InviteData is the data for an invitation.
+type InviteData struct {
+	Id        string
+	Creator   string
+	GameId    string
+	GameName  string
+	MaxUses   int
+	Uses      int
+	CreatedAt string
+	ExpiresAt string // empty if the invitation never expires
+	Usable    bool
+}
+
+// getInvites lists every invitation.
+func (a *App) getInvites() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "invites")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getInvites: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, err := a.invitesData("")
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.renderInvites(w, r, t, content)
+	}
+}
+
+// postInvites creates an invitation, which may be for a game.
+func (a *App) postInvites() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "invites")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postInvites: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var gameId string
+		var err error
+		if name := strings.TrimSpace(r.FormValue("game")); name != "" {
+			var game GameRecord
+			if game, err = a.db.GameByName(name); errors.Is(err, ErrNotFound) {
+				err = ErrUnknownGame
+			}
+			gameId = game.Id
+		}
+		var code string
+		if err == nil {
+			code, err = a.createInvite(r, gameId)
+		}
+		content, lerr := a.invitesData("")
+		if lerr != nil {
+			a.internalError(w, r, lerr)
+			return
+		}
+		if errors.Is(err, ErrUnknownGame) || errors.Is(err, ErrInvalidLifetime) || errors.Is(err, ErrInvalidMaxUses) {
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else {
+			content.NewCode, content.NewLink = code, a.inviteLink(code)
+		}
+		a.renderInvites(w, r, t, content)
+	}
+}
+
+// postInvitesDelete deletes any invitation.
+func (a *App) postInvitesDelete() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "iid")
+		if err := a.db.DeleteInvite(id, ""); errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.audit(r, audit.InviteDelete, audit.TargetInvite, id, "")
+		http.Redirect(w, r, "/invites", http.StatusSeeOther)
+	}
+}
+
+// postGamesGameInvites creates an invitation to the game.
+func (a *App) postGamesGameInvites() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "game")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postGamesGameInvites: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game := a.currentUser(r), a.currentGame(r)
+		code, err := a.createInvite(r, game.Id)
+		content, lerr := a.gameData(r)
+		if lerr != nil {
+			a.internalError(w, r, lerr)
+			return
+		}
+		if errors.Is(err, ErrInvalidLifetime) || errors.Is(err, ErrInvalidMaxUses) {
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else {
+			content.Invites.NewCode, content.Invites.NewLink = code, a.inviteLink(code)
+		}
+		a.renderGame(w, r, t, user, content)
+	}
+}
+
+// postGamesGameInvitesDelete deletes an invitation to the game.
+func (a *App) postGamesGameInvitesDelete() http.HandlerFunc {
+	nfh := a.notFound()
+	return func(w http.ResponseWriter, r *http.Request) {
+		game, id := a.currentGame(r), way.Param(r.Context(), "iid")
+		if err := a.db.DeleteInvite(id, game.Id); errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.audit(r, audit.InviteDelete, audit.TargetInvite, id, game.Id)
+		http.Redirect(w, r, fmt.Sprintf("/games/%s", game.Id), http.StatusSeeOther)
+	}
+}
+
+// postInvitesRedeem lets a signed-in user join a game with an invitation.
+func (a *App) postInvitesRedeem() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "games")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postInvitesRedeem: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, hashed := a.currentUser(r), a.hashInviteCode(r.FormValue("code"))
+		// invitations that aren't for a game are only for signing up, so don't use them up
+		i, err := a.db.InviteByHash(hashed)
+		if err == nil && i.GameId == "" {
+			err = ErrInvalidInvite
+		}
+		if err == nil {
+			i, err = a.db.RedeemInvite(hashed, user.Id())
+		}
+		if errors.Is(err, ErrInvalidInvite) {
+			content, lerr := a.gamesData(user)
+			if lerr != nil {
+				a.internalError(w, r, lerr)
+				return
+			}
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			a.renderGames(w, r, t, user, content)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q joined game %q with invitation %q\n", r.Method, r.URL, user.Id(), i.GameId, i.Id)
+		a.audit(r, audit.InviteRedeem, audit.TargetInvite, i.Id, i.GameId)
+		http.Redirect(w, r, fmt.Sprintf("/games/%s", i.GameId), http.StatusSeeOther)
+	}
+}
+
+// createInvite creates an invitation from the form and returns its code.
+func (a *App) createInvite(r *http.Request, gameId string) (string, error) {
+	days, err := strconv.Atoi(r.FormValue("lifetime"))
+	if err != nil {
+		return "", ErrInvalidLifetime
+	}
+	valid := false
+	for _, n := range inviteLifetimes {
+		valid = valid || n == days
+	}
+	if !valid {
+		return "", ErrInvalidLifetime
+	}
+	maxUses := 0
+	if s := strings.TrimSpace(r.FormValue("max_uses")); s != "" {
+		if maxUses, err = strconv.Atoi(s); err != nil || maxUses < 0 || maxUses > 1000 {
+			return "", ErrInvalidMaxUses
+		}
+	}
+	code, hashed, err := a.newInviteCode()
+	if err != nil {
+		return "", err
+	}
+	i, err := a.db.CreateInvite(a.currentUser(r).Id(), hashed, gameId, maxUses, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("%s %s: %q created invitation %q for game %q\n", r.Method, r.URL, a.currentUser(r).Id(), i.Id, gameId)
+	a.audit(r, audit.InviteCreate, audit.TargetInvite, i.Id, gameId)
+	return code, nil
+}
+
+// inviteLink returns the sign-up link for an invitation code.
+func (a *App) inviteLink(code string) string {
+	return a.baseURL + "/signup?" + url.Values{"invite": {code}}.Encode()
+}
+
+// invitesData returns the invitations for the game, or every invitation if the game is empty.
+func (a *App) invitesData(gameId string) (InvitesData, error) {
+	content := InvitesData{GameId: gameId, Lifetimes: inviteLifetimes}
+	list, err := a.db.Invites(gameId)
+	if err != nil {
+		return content, err
+	}
+	now := time.Now()
+	for _, i := range list {
+		row := InviteData{
+			Id:        i.Id,
+			Creator:   i.Creator,
+			GameId:    i.GameId,
+			GameName:  i.GameName,
+			MaxUses:   i.MaxUses,
+			Uses:      i.Uses,
+			CreatedAt: i.CreatedAt.Format(a.timestampFormat),
+			Usable:    i.IsUsable(now),
+		}
+		if !i.ExpiresAt.IsZero() {
+			row.ExpiresAt = i.ExpiresAt.Format(a.timestampFormat)
+		}
+		content.Invites = append(content.Invites, row)
+	}
+	return content, nil
+}
+
+func (a *App) renderInvites(w http.ResponseWriter, r *http.Request, t *templateHandler, content InvitesData) {
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = "Invitations"
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Games", Url: "/games"},
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", a.currentUser(r).Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	if a.currentUser(r).Can(rbac.UserManage) {
+		payload.Site.NavBar.Links = append([]LinkData{{Text: "Users", Url: "/users"}}, payload.Site.NavBar.Links...)
+	}
+	t.render(w, r, payload)
+}
@@ -0,0 +1,125 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInvitePermissions(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-gm", Handle: "gm", Role: GameRoleGM},
+		GameMemberRecord{UserId: "u-player", Handle: "player", Role: GameRolePlayer, Nation: 1},
+	)
+	admin := signIn(t, a, "u-admin", "admin", "admin")
+	gm := signIn(t, a, "u-gm", "gm")
+	player := signIn(t, a, "u-player", "player")
+	week := url.Values{"lifetime": {"7"}}
+
+	for _, tc := range []struct {
+		id      int
+		method  string
+		target  string
+		form    url.Values
+		session *testSession
+		want    int
+	}{
+		{1, http.MethodGet, "/invites", nil, admin, http.StatusOK},
+		{2, http.MethodGet, "/invites", nil, gm, http.StatusNotFound},
+		{3, http.MethodPost, "/invites", week, player, http.StatusNotFound},
+		{4, http.MethodPost, "/invites/i1/delete", nil, gm, http.StatusNotFound},
+		{5, http.MethodPost, "/games/g1/invites", week, player, http.StatusNotFound},
+		{6, http.MethodPost, "/games/g1/invites/i1/delete", nil, player, http.StatusNotFound},
+		{7, http.MethodPost, "/games/g1/invites", url.Values{"lifetime": {"2"}}, gm, http.StatusUnprocessableEntity},
+		{8, http.MethodPost, "/games/g1/invites", week, gm, http.StatusOK},
+		{9, http.MethodPost, "/invites/redeem", url.Values{"code": {"no-such-code"}}, player, http.StatusUnprocessableEntity},
+		{10, http.MethodPost, "/invites/redeem", url.Values{"code": {"no-such-code"}}, nil, http.StatusNotFound},
+	} {
+		r := newTestRequest(a, tc.method, tc.target, tc.form, tc.session)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s %s: want %d, got %d", tc.id, tc.method, tc.target, tc.want, w.Code)
+		}
+	}
+	// only the game master's invitation was created
+	if list := db.executed("insert into invitations"); len(list) != 1 {
+		t.Errorf("create: want 1 invitation, got %d", len(list))
+	}
+	if list := db.executed("delete from invitations"); len(list) != 0 {
+		t.Errorf("delete: want none deleted, got %d", len(list))
+	}
+}
+
+func TestInviteOnlySignUp(t *testing.T) {
+	a, db := newTestApp(t)
+	a.inviteOnly = true
+	form := url.Values{
+		"handle":   {"newbie"},
+		"email":    {"newbie@example.com"},
+		"password": {"correct-horse-battery"},
+		"confirm":  {"correct-horse-battery"},
+	}
+	for _, tc := range []struct {
+		id     int
+		invite string
+	}{
+		{1, ""},
+		{2, "no-such-code"},
+	} {
+		form.Set("invite", tc.invite)
+		r := newTestRequest(a, http.MethodPost, "/signup", form, nil)
+		if w := serve(a, r); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%d: want %d, got %d", tc.id, http.StatusUnprocessableEntity, w.Code)
+		}
+	}
+	if list := db.executed("insert into users"); len(list) != 0 {
+		t.Errorf("signup: want no accounts, got %d", len(list))
+	}
+}
+
+func TestInviteSignUpUsedUp(t *testing.T) {
+	now := time.Now().UTC()
+	form := url.Values{
+		"handle":   {"newbie"},
+		"email":    {"newbie@example.com"},
+		"password": {"correct-horse-battery"},
+		"confirm":  {"correct-horse-battery"},
+	}
+	for _, tc := range []struct {
+		id       int
+		uses     int64 // when the sign-up locks the invitation, which allows one use
+		want     int
+		accounts int
+	}{
+		{1, 0, http.StatusSeeOther, 1},
+		// another sign-up used the invitation after it was checked
+		{2, 1, http.StatusUnprocessableEntity, 0},
+	} {
+		a, db := newTestApp(t)
+		a.inviteOnly = true
+		code, _, err := a.newInviteCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.answer("where i.hashed_code = ? for update", []driver.Value{"i1", "u-gm", "gm", nil, "", int64(1), tc.uses, now, nil})
+		db.answer("where i.hashed_code = ?", []driver.Value{"i1", "u-gm", "gm", nil, "", int64(1), int64(0), now, nil})
+		form.Set("invite", code)
+		r := newTestRequest(a, http.MethodPost, "/signup", form, nil)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: want %d, got %d", tc.id, tc.want, w.Code)
+		}
+		if got := len(db.executed("insert into users")); got != tc.accounts {
+			t.Errorf("%d: accounts: want %d, got %d", tc.id, tc.accounts, got)
+		}
+		if got := len(db.executed("update invitations set uses")); got != tc.accounts {
+			t.Errorf("%d: uses: want %d, got %d", tc.id, tc.accounts, got)
+		}
+		if got := len(db.executed("delete from users")); got != 0 {
+			t.Errorf("%d: want no accounts deleted, got %d", tc.id, got)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/lockout"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttled answers the request with 429 Too Many Requests if any of the keys are locked out.
+// It returns true if the request has been answered.
+func (a *App) throttled(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	wait, err := a.lockout.Check(time.Now(), keys...)
+	if err != nil {
+		a.internalError(w, r, err)
+		return true
+	} else if wait == 0 {
+		return false
+	}
+	log.Printf("%s %s: %v: locked out for %v\n", r.Method, r.URL, keys, wait)
+	a.tooManyAttempts(w, wait)
+	return true
+}
+
+// tooManyAttempts answers with 429 Too Many Requests and tells the client how long to wait.
+func (a *App) tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed attempts. Try again later.", http.StatusTooManyRequests)
+}
+
+// failSignIn counts a failed sign-in against the keys.
+// It returns how long the failure locked the keys out for, which is zero if it didn't.
+// Failing to count the attempt is logged but doesn't change the response.
+func (a *App) failSignIn(r *http.Request, keys ...string) time.Duration {
+	wait, err := a.lockout.Fail(time.Now(), keys...)
+	if err != nil {
+		log.Printf("%s %s: lockout: %v\n", r.Method, r.URL, err)
+		return 0
+	} else if wait != 0 {
+		log.Printf("%s %s: %v: locked out for %v\n", r.Method, r.URL, keys, wait)
+		for _, key := range keys {
+			targetType, targetId := lockoutTarget(key)
+			a.auditAs(r, "", audit.SignInLocked, targetType, targetId, fmt.Sprintf("locked for %v", wait))
+		}
+	}
+	return wait
+}
+
+// succeedSignIn forgets the failed sign-ins against the user's account.
+func (a *App) succeedSignIn(r *http.Request, userId string) {
+	if err := a.lockout.Succeed(lockout.Account(userId)); err != nil {
+		log.Printf("%s %s: lockout: %v\n", r.Method, r.URL, err)
+	}
+}
+
+// lockoutTarget returns the audit target for a lockout key.
+func lockoutTarget(key string) (string, string) {
+	if id, ok := strings.CutPrefix(key, "account:"); ok {
+		return audit.TargetUser, id
+	} else if addr, ok := strings.CutPrefix(key, "ip:"); ok {
+		return audit.TargetIP, addr
+	}
+	return "", key
+}
+
+// LockoutsData is the data for the list of sign-in failures.
+type LockoutsData struct {
+	Lockouts []LockoutData
+	Message  string
+}
+
+// LockoutData is the data for the failures counted against an account or an address.
+type LockoutData struct {
+	Key         string
+	UserId      string // set if the key is an account
+	Handle      string
+	IP          string // set if the key is an address
+	Failures    int
+	LastFailure string
+	LockedUntil string // empty if the key isn't locked
+}
+
+// getLockouts lists the accounts and addresses with recent sign-in failures.
+func (a *App) getLockouts() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "lockouts")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getLockouts: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		list, err := a.lockout.Counters(now)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		var content LockoutsData
+		if r.FormValue("cleared") != "" {
+			content.Message = "The lockout is cleared."
+		}
+		for _, c := range list {
+			row := LockoutData{Key: c.Key, Failures: c.Failures, LastFailure: c.LastFailure.UTC().Format(a.timestampFormat)}
+			if c.IsLocked(now) {
+				row.LockedUntil = c.LockedUntil.UTC().Format(a.timestampFormat)
+			}
+			switch targetType, targetId := lockoutTarget(c.Key); targetType {
+			case audit.TargetUser:
+				row.UserId = targetId
+				if u, err := a.db.UserById(targetId); err == nil {
+					row.Handle = u.Handle
+				}
+			case audit.TargetIP:
+				row.IP = targetId
+			}
+			content.Lockouts = append(content.Lockouts, row)
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = "Sign-in lockouts"
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Users", Url: "/users"},
+			{Text: "Profile", Url: fmt.Sprintf("/users/%s", a.currentUser(r).Id())},
+			{Text: "Sign Out", Url: "/signout"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// postLockoutsClear clears the failures and any lock for an account or an address.
+func (a *App) postLockoutsClear() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.FormValue("key")
+		targetType, targetId := lockoutTarget(key)
+		if targetType == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		} else if err := a.lockout.Clear(key); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q cleared lockout %q\n", r.Method, r.URL, a.currentUser(r).Id(), key)
+		a.audit(r, audit.AdminClearLockout, targetType, targetId, "")
+		http.Redirect(w, r, "/lockouts?cleared=1", http.StatusSeeOther)
+	}
+}
+
+// postUsersIdAdminUnlock clears the failures and any lock for the user's account.
+func (a *App) postUsersIdAdminUnlock() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if err := a.lockout.Clear(lockout.Account(id)); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q cleared lockout for %q\n", r.Method, r.URL, a.currentUser(r).Id(), id)
+		a.audit(r, audit.AdminClearLockout, audit.TargetUser, id, "")
+		a.adminDone(w, r, id, "The lockout is cleared.", "")
+	}
+}
@@ -0,0 +1,66 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"github.com/mdhender/wraithi/internal/passwords"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignInLockout(t *testing.T) {
+	a, db := newTestApp(t)
+	hashed, err := passwords.Hash("right", a.salt)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	answerUsers(db,
+		UserRecord{Id: "u-admin", Handle: "admin", Roles: []string{"admin"}, CreatedAt: time.Now().UTC()},
+		UserRecord{Id: "u-bob", Handle: "bob", Email: "bob@example.com", EmailVerified: true, HashedPassword: hashed, CreatedAt: time.Now().UTC()},
+	)
+	signInAs := func(password string) *http.Response {
+		r := newTestRequest(a, http.MethodPost, "/signin", url.Values{"email": {"bob@example.com"}, "password": {password}}, nil)
+		return serve(a, r).Result()
+	}
+
+	// the account locks on the fifth failure, and stays locked for the right password
+	for i := 1; i <= 4; i++ {
+		if got := signInAs("wrong").StatusCode; got != http.StatusUnauthorized {
+			t.Fatalf("%d: want %d, got %d", i, http.StatusUnauthorized, got)
+		}
+	}
+	for _, password := range []string{"wrong", "right"} {
+		w := signInAs(password)
+		if w.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("%s: want %d, got %d", password, http.StatusTooManyRequests, w.StatusCode)
+		} else if got := w.Header.Get("Retry-After"); got != "60" && got != "59" {
+			t.Errorf("%s: retry-after: want 60, got %q", password, got)
+		}
+	}
+
+	// only administrators see and clear lockouts
+	admin := signIn(t, a, "u-admin", "admin", "admin")
+	gm := signIn(t, a, "u-gm", "gm", "gm")
+	for _, tc := range []struct {
+		id      int
+		method  string
+		target  string
+		session *testSession
+		want    int
+	}{
+		{1, http.MethodGet, "/lockouts", gm, http.StatusNotFound},
+		{2, http.MethodPost, "/users/u-bob/admin/unlock", gm, http.StatusNotFound},
+		{3, http.MethodGet, "/lockouts", admin, http.StatusOK},
+		{4, http.MethodPost, "/users/u-bob/admin/unlock", admin, http.StatusSeeOther},
+	} {
+		r := newTestRequest(a, tc.method, tc.target, nil, tc.session)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s %s: want %d, got %d", tc.id, tc.method, tc.target, tc.want, w.Code)
+		}
+	}
+	if got := signInAs("right").StatusCode; got != http.StatusSeeOther {
+		t.Errorf("unlocked: want %d, got %d", http.StatusSeeOther, got)
+	}
+}
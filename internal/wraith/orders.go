@@ -0,0 +1,393 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/engine"
+	"github.com/mdhender/wraithi/internal/orders"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOrdersLength is the longest orders text that a nation may submit.
+const maxOrdersLength = 64 << 10
+
+// OrdersRecord is a nation's orders for a turn as stored in the database.
+type OrdersRecord struct {
+	GameId      string
+	Nation      int
+	Turn        int    // the turn the orders will be carried out in
+	Text        string // the orders language
+	SubmittedBy string // id of the user who submitted the orders; empty if they were deleted
+	SubmittedAt time.Time
+}
+
+// SaveOrders saves the nation's orders for the turn, replacing any that were submitted earlier.
+func (db *DB) SaveOrders(o OrdersRecord) error {
+	_, err := db.db.ExecContext(db.context,
+		`insert into orders (game_id, nation, turn, text, submitted_by, submitted_at) values (?, ?, ?, ?, ?, ?)
+		 on duplicate key update text = values(text), submitted_by = values(submitted_by), submitted_at = values(submitted_at)`,
+		o.GameId, o.Nation, o.Turn, o.Text, o.SubmittedBy, o.SubmittedAt)
+	return err
+}
+
+// Orders returns the nation's orders for the turn.
+// Returns ErrNotFound if the nation hasn't submitted any.
+func (db *DB) Orders(gameId string, nation, turn int) (OrdersRecord, error) {
+	o := OrdersRecord{GameId: gameId, Nation: nation, Turn: turn}
+	var submittedBy sql.NullString
+	row := db.db.QueryRowContext(db.context,
+		"select text, submitted_by, submitted_at from orders where game_id = ? and nation = ? and turn = ?",
+		gameId, nation, turn)
+	if err := row.Scan(&o.Text, &submittedBy, &o.SubmittedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrdersRecord{}, ErrNotFound
+		}
+		return OrdersRecord{}, err
+	}
+	o.SubmittedBy = submittedBy.String
+	return o, nil
+}
+
+// GameOrders returns every nation's orders for the turn, in order of nation.
+func (db *DB) GameOrders(gameId string, turn int) ([]OrdersRecord, error) {
+	rows, err := db.db.QueryContext(db.context,
+		"select nation, text, submitted_by, submitted_at from orders where game_id = ? and turn = ? order by nation",
+		gameId, turn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var list []OrdersRecord
+	for rows.Next() {
+		o := OrdersRecord{GameId: gameId, Turn: turn}
+		var submittedBy sql.NullString
+		if err := rows.Scan(&o.Nation, &o.Text, &submittedBy, &o.SubmittedAt); err != nil {
+			return nil, err
+		}
+		o.SubmittedBy = submittedBy.String
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+// OrdersData is the data for a nation's orders page.
+type OrdersData struct {
+	Id        string // the game's id
+	Name      string // the game's name
+	Nation    int
+	Turn      int    // the turn the orders will be carried out in
+	Text      string // the orders as submitted
+	Submitted string // when the orders were submitted; empty if they haven't been
+	Accepted  []string
+	Problems  []OrderProblemData // syntax errors and rejected orders, by line
+	Kinds     []engine.OrderKind // for the order builder
+	Products  []string           // for the order builder
+	Error     string
+}
+
+// OrderProblemData is an order that couldn't be read or won't be carried out.
+type OrderProblemData struct {
+	Line   int
+	Col    int
+	Source string // the line of text that the order is on
+	Reason string
+}
+
+// OrdersResult is the response to orders submitted as plain text.
+type OrdersResult struct {
+	Turn     int                `json:"turn"`
+	Accepted []engine.Order     `json:"accepted"`
+	Rejected []engine.Rejection `json:"rejected"`
+	Errors   orders.Errors      `json:"errors"` // lines that couldn't be read
+}
+
+// getGamesGameOrders shows the player's orders for the current turn.
+func (a *App) getGamesGameOrders() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "orders")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getGamesGameOrders: %v", err))
+	}
+	m, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "message")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getGamesGameOrders: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game, member := a.currentUser(r), a.currentGame(r), a.currentGameMember(r)
+		view, err := a.openGameView(game, member.Nation)
+		if errors.Is(err, ErrGameNotStarted) || errors.Is(err, ErrGameFinished) || errors.Is(err, engine.ErrUnknownNation) {
+			a.renderOrdersMessage(w, r, m, user, game, err)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		content := a.ordersData(game, view)
+		if saved, err := a.db.Orders(game.Id, member.Nation, content.Turn); err == nil {
+			content.Submitted = saved.SubmittedAt.Format(a.timestampFormat)
+			a.checkOrders(&content, view, saved.Text)
+		} else if !errors.Is(err, ErrNotFound) {
+			a.internalError(w, r, err)
+			return
+		}
+		a.renderOrders(w, r, t, user, content)
+	}
+}
+
+// postGamesGameOrders saves the player's orders for the current turn and reports the mistakes in them.
+// The orders come from the form's text box, with the order from the builder appended when the
+// action is "add". Orders sent as text/plain, which is how API clients submit them, are answered
+// with an OrdersResult.
+// Orders are saved even when some are rejected; only the accepted ones are carried out.
+func (a *App) postGamesGameOrders() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "orders")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postGamesGameOrders: %v", err))
+	}
+	m, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "message")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postGamesGameOrders: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game, member := a.currentUser(r), a.currentGame(r), a.currentGameMember(r)
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		plain := mediaType == "text/plain"
+
+		view, err := a.openGameView(game, member.Nation)
+		if errors.Is(err, ErrGameNotStarted) || errors.Is(err, ErrGameFinished) || errors.Is(err, engine.ErrUnknownNation) {
+			if plain {
+				msg, status := "You don't have a nation in this game.", http.StatusForbidden
+				if !errors.Is(err, engine.ErrUnknownNation) {
+					msg, status = fmt.Sprintf("Orders can't be submitted because %s.", err), http.StatusConflict
+				}
+				http.Error(w, msg, status)
+				return
+			}
+			w.WriteHeader(http.StatusConflict)
+			a.renderOrdersMessage(w, r, m, user, game, err)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+
+		content := a.ordersData(game, view)
+		var text string
+		if plain {
+			b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOrdersLength))
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				http.Error(w, ErrLongOrders.Error(), http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				a.internalError(w, r, err)
+				return
+			}
+			text = string(b)
+		} else {
+			text = strings.TrimRight(r.FormValue("orders"), "\r\n ")
+			if r.FormValue("action") == "add" {
+				if o, err := builderOrder(r); err != nil {
+					content.Error = err.Error()
+				} else if text == "" {
+					text = o.String()
+				} else {
+					text += "\n" + o.String()
+				}
+			}
+			if len(text) > maxOrdersLength {
+				content.Error = ErrLongOrders.Error()
+			}
+			if content.Error != "" {
+				content.Text = text
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				a.renderOrders(w, r, t, user, content)
+				return
+			}
+		}
+
+		saved := OrdersRecord{
+			GameId:      game.Id,
+			Nation:      member.Nation,
+			Turn:        content.Turn,
+			Text:        text,
+			SubmittedBy: user.Id(),
+			SubmittedAt: time.Now().UTC(),
+		}
+		if err := a.db.SaveOrders(saved); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		result := a.checkOrders(&content, view, text)
+		log.Printf("%s %s: %q submitted orders for nation %d, turn %d\n", r.Method, r.URL, user.Id(), member.Nation, content.Turn)
+		a.audit(r, audit.OrdersSubmit, audit.TargetGame, game.Id,
+			fmt.Sprintf("nation %d, turn %d: %d accepted, %d rejected", member.Nation, content.Turn, len(result.Accepted), len(result.Rejected)+len(result.Errors)))
+
+		if plain {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(result); err != nil {
+				log.Printf("%s %s: json: %v\n", r.Method, r.URL, err)
+			}
+			return
+		}
+		content.Submitted = saved.SubmittedAt.Format(a.timestampFormat)
+		a.renderOrders(w, r, t, user, content)
+	}
+}
+
+// openGameView returns the nation's view of a game that is accepting orders.
+// Returns ErrGameNotStarted or ErrGameFinished if it isn't,
+// and engine.ErrUnknownNation if the nation isn't in the game.
+func (a *App) openGameView(game GameRecord, nation int) (*engine.View, error) {
+	if !game.FinishedAt.IsZero() {
+		return nil, ErrGameFinished
+	}
+	latest, err := a.latestTurn(game.Id)
+	if err != nil {
+		return nil, err
+	}
+	return a.loadGameView(game.Id, latest, nation)
+}
+
+// builderOrder returns the order from the builder fields of the orders form.
+func builderOrder(r *http.Request) (engine.Order, error) {
+	o := engine.Order{Kind: engine.OrderKind(r.FormValue("kind")), Product: r.FormValue("product")}
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"amount", &o.Amount},
+		{"colony", &o.Colony},
+		{"fleet", &o.Fleet},
+		{"nation", &o.Nation},
+		{"planet", &o.Planet},
+		{"ship", &o.Ship},
+		{"system", &o.System},
+	} {
+		if s := strings.TrimSpace(r.FormValue(field.name)); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return o, fmt.Errorf("%s must be a number", field.name)
+			}
+			*field.value = n
+		}
+	}
+	for _, kind := range orderKinds {
+		if o.Kind == kind {
+			return o, nil
+		}
+	}
+	return o, fmt.Errorf("choose an order to add")
+}
+
+// orderKinds is the list of orders, in the order they're shown in the builder.
+var orderKinds = []engine.OrderKind{engine.Build, engine.Colonize, engine.Hold, engine.Move, engine.Research, engine.Scrap, engine.Transfer}
+
+// checkOrders reads and validates the orders text against the nation's view
+// and adds the results to the page.
+func (a *App) checkOrders(content *OrdersData, view *engine.View, text string) OrdersResult {
+	result := OrdersResult{Turn: content.Turn}
+	parsed, err := orders.Parse(text)
+	if !errors.As(err, &result.Errors) && err != nil {
+		// Parse only returns Errors, but don't lose anything if that changes
+		result.Errors = orders.Errors{{Line: 1, Col: 1, Msg: err.Error()}}
+	}
+	v := engine.ValidateOrders(view.Game, view.Nation, parsed)
+	result.Accepted, result.Rejected = v.Accepted, v.Rejected
+	// clients get empty lists rather than nulls
+	if result.Accepted == nil {
+		result.Accepted = []engine.Order{}
+	}
+	if result.Rejected == nil {
+		result.Rejected = []engine.Rejection{}
+	}
+	if result.Errors == nil {
+		result.Errors = orders.Errors{}
+	}
+
+	lines := strings.Split(text, "\n")
+	source := func(line int) string {
+		if line < 1 || line > len(lines) {
+			return ""
+		}
+		return strings.TrimRight(lines[line-1], "\r")
+	}
+	content.Text = text
+	for _, o := range v.Accepted {
+		content.Accepted = append(content.Accepted, o.String())
+	}
+	for _, e := range result.Errors {
+		content.Problems = append(content.Problems, OrderProblemData{Line: e.Line, Col: e.Col, Source: source(e.Line), Reason: e.Msg})
+	}
+	for _, rj := range v.Rejected {
+		content.Problems = append(content.Problems, OrderProblemData{Line: rj.Order.Line, Col: rj.Order.Col, Source: source(rj.Order.Line), Reason: rj.Reason})
+	}
+	sort.SliceStable(content.Problems, func(i, j int) bool {
+		if content.Problems[i].Line != content.Problems[j].Line {
+			return content.Problems[i].Line < content.Problems[j].Line
+		}
+		return content.Problems[i].Col < content.Problems[j].Col
+	})
+	return result
+}
+
+// ordersData returns the data for the nation's orders page without any orders.
+func (a *App) ordersData(game GameRecord, view *engine.View) OrdersData {
+	return OrdersData{
+		Id:       game.Id,
+		Name:     game.Name,
+		Nation:   view.Nation,
+		Turn:     view.Turn + 1,
+		Kinds:    orderKinds,
+		Products: engine.Products(),
+	}
+}
+
+func (a *App) renderOrders(w http.ResponseWriter, r *http.Request, t *templateHandler, user User, content OrdersData) {
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = fmt.Sprintf("%s: Orders", content.Name)
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Game", Url: fmt.Sprintf("/games/%s", content.Id)},
+		{Text: "Games", Url: "/games"},
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	t.render(w, r, payload)
+}
+
+// renderOrdersMessage explains why the user can't submit orders for the game.
+func (a *App) renderOrdersMessage(w http.ResponseWriter, r *http.Request, t *templateHandler, user User, game GameRecord, err error) {
+	content := MessageData{
+		Title:   "Orders",
+		Message: "You don't have a nation in this game.",
+		Link:    LinkData{Text: "Back to the game", Url: fmt.Sprintf("/games/%s", game.Id)},
+	}
+	if errors.Is(err, ErrGameNotStarted) {
+		content.Message = "The game hasn't started yet. Orders can be submitted once the game master sets up the galaxy."
+	} else if errors.Is(err, ErrGameFinished) {
+		content.Message = "The game has finished, so no more orders can be submitted."
+	}
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = fmt.Sprintf("%s: Orders", game.Name)
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Games", Url: "/games"},
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	t.render(w, r, payload)
+}
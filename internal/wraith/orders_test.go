@@ -0,0 +1,101 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// startGame has the game master generate the galaxy for the game with a fixed seed.
+func startGame(t *testing.T, a *App, gameId string, gm *testSession) {
+	t.Helper()
+	r := newTestRequest(a, http.MethodPost, "/games/"+gameId+"/start", map[string][]string{"seed": {"1"}}, gm)
+	if w := serve(a, r); w.Code != http.StatusSeeOther {
+		t.Fatalf("start: want %d, got %d", http.StatusSeeOther, w.Code)
+	}
+}
+
+// homeFleet returns the id of the nation's first fleet.
+func homeFleet(t *testing.T, a *App, gameId string, nation int) int {
+	t.Helper()
+	state, err := a.loadGameState(gameId)
+	if err != nil {
+		t.Fatalf("state: %v", err)
+	}
+	for _, f := range state.Fleets {
+		if f.Nation == nation {
+			return f.Id
+		}
+	}
+	t.Fatalf("state: nation %d has no fleets", nation)
+	return 0
+}
+
+func TestOrderSubmission(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-gm", Handle: "gm", Role: GameRoleGM},
+		GameMemberRecord{UserId: "u-p1", Handle: "p1", Role: GameRolePlayer, Nation: 1},
+		GameMemberRecord{UserId: "u-p2", Handle: "p2", Role: GameRolePlayer, Nation: 2},
+		GameMemberRecord{UserId: "u-observer", Handle: "observer", Role: GameRoleObserver},
+	)
+	gm := signIn(t, a, "u-gm", "gm")
+	p1 := signIn(t, a, "u-p1", "p1")
+	observer := signIn(t, a, "u-observer", "observer")
+	outsider := signIn(t, a, "u-outsider", "outsider")
+	startGame(t, a, "g1", gm)
+	own, theirs := homeFleet(t, a, "g1", 1), homeFleet(t, a, "g1", 2)
+	text := fmt.Sprintf("hold fleet %d\nhold fleet %d\n", own, theirs)
+
+	for _, tc := range []struct {
+		id      int
+		method  string
+		session *testSession
+		want    int
+	}{
+		{1, http.MethodGet, p1, http.StatusOK},
+		{2, http.MethodGet, gm, http.StatusNotFound},
+		{3, http.MethodGet, observer, http.StatusNotFound},
+		{4, http.MethodGet, outsider, http.StatusNotFound},
+		{5, http.MethodPost, gm, http.StatusNotFound},
+		{6, http.MethodPost, observer, http.StatusNotFound},
+		{7, http.MethodPost, outsider, http.StatusNotFound},
+		{8, http.MethodPost, nil, http.StatusNotFound},
+	} {
+		r := newTestRequest(a, tc.method, "/games/g1/orders", map[string][]string{"orders": {text}}, tc.session)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s: want %d, got %d", tc.id, tc.method, tc.want, w.Code)
+		}
+	}
+	if list := db.executed("insert into orders"); len(list) != 0 {
+		t.Fatalf("orders: want none saved, got %d", len(list))
+	}
+
+	// the player's orders are checked against their own nation
+	r := newTestRequest(a, http.MethodPost, "/games/g1/orders", nil, p1)
+	r.Body = io.NopCloser(strings.NewReader(text))
+	r.Header.Set("Content-Type", "text/plain")
+	w := serve(a, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("submit: want %d, got %d", http.StatusOK, w.Code)
+	}
+	var result struct {
+		Accepted []json.RawMessage `json:"accepted"`
+		Rejected []json.RawMessage `json:"rejected"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("submit: json: %v", err)
+	} else if len(result.Accepted) != 1 || len(result.Rejected) != 1 {
+		t.Errorf("submit: want 1 accepted and 1 rejected, got %d and %d", len(result.Accepted), len(result.Rejected))
+	}
+	if list := db.executed("insert into orders"); len(list) != 1 {
+		t.Fatalf("submit: want 1 saved, got %d", len(list))
+	} else if nation := list[0].args[1]; nation != int64(1) {
+		t.Errorf("submit: want nation 1, got %v", nation)
+	}
+}
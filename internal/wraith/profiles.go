@@ -0,0 +1,189 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/rbac"
+	"github.com/mdhender/wraithi/internal/way"
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata" // so timezones can be checked on hosts without the zoneinfo database
+)
+
+// UpdateProfile saves the fields of the user's profile that the user can change.
+func (db *DB) UpdateProfile(userId, avatarURL, timezone string, notifyTurns, notifyInvites bool) error {
+	_, err := db.db.ExecContext(db.context,
+		"update users set avatar_url = ?, timezone = ?, notify_turns = ?, notify_invites = ? where id = ?",
+		avatarURL, timezone, notifyTurns, notifyInvites, userId)
+	return err
+}
+
+// isAvatarURL returns true if the url is safe to use as the source of an image.
+// We only accept secure links so pages don't get mixed content warnings.
+func isAvatarURL(url string) bool {
+	return strings.HasPrefix(url, "https://") && len(url) <= 1024
+}
+
+// getUsersId shows a user's profile.
+// Other users get the public view; the owner and user managers also see the private fields.
+func (a *App) getUsersId() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "user")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getUsersId: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, err := a.profileData(r, way.Param(r.Context(), "id"))
+		if errors.Is(err, ErrNotFound) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.renderProfile(w, r, t, content)
+	}
+}
+
+// postUsersIdProfile saves the owner's changes to their profile.
+func (a *App) postUsersIdProfile() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "user")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postUsersIdProfile: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		timezone, avatar := strings.TrimSpace(r.FormValue("timezone")), r.FormValue("avatar")
+		notifyTurns, notifyInvites := r.FormValue("notify_turns") == "on", r.FormValue("notify_invites") == "on"
+
+		var err error
+		if _, lerr := time.LoadLocation(timezone); timezone == "" || lerr != nil {
+			err = ErrInvalidTimezone
+		} else if avatar != "" {
+			// the avatar must come from one of the user's linked identities
+			identities, ierr := a.db.UserIdentities(user.Id())
+			if ierr != nil {
+				a.internalError(w, r, ierr)
+				return
+			}
+			err = ErrInvalidAvatar
+			for _, i := range identities {
+				if i.Avatar == avatar && isAvatarURL(avatar) {
+					err = nil
+					break
+				}
+			}
+		}
+		if err == nil {
+			if err = a.db.UpdateProfile(user.Id(), avatar, timezone, notifyTurns, notifyInvites); err != nil {
+				a.internalError(w, r, err)
+				return
+			}
+		}
+
+		content, lerr := a.profileData(r, user.Id())
+		if lerr != nil {
+			a.internalError(w, r, lerr)
+			return
+		}
+		if err != nil {
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else {
+			content.Message = "Your profile is saved."
+		}
+		a.renderProfile(w, r, t, content)
+	}
+}
+
+// profileData returns the profile of the user with the given id, as seen by the current user.
+func (a *App) profileData(r *http.Request, id string) (ProfileData, error) {
+	viewer := a.currentUser(r)
+	rec, err := a.db.UserById(id)
+	if err != nil {
+		return ProfileData{}, err
+	}
+	content := ProfileData{
+		Id:          rec.Id,
+		Handle:      rec.Handle,
+		AvatarURL:   rec.AvatarURL,
+		IsOwner:     rec.Id == viewer.Id(),
+		CSRFToken:   csrfTokenFor(r),
+		ShowPrivate: rec.Id == viewer.Id() || viewer.Can(rbac.UserManage),
+	}
+
+	games, members, err := a.db.UserGames(rec.Id)
+	if err != nil {
+		return content, err
+	}
+	for i, g := range games {
+		gd := a.userGameData(g, members[i])
+		if !content.ShowPrivate {
+			// which nation a player controls is not public
+			gd.Nation = 0
+		}
+		if g.FinishedAt.IsZero() {
+			content.ActiveGames = append(content.ActiveGames, gd)
+		} else {
+			content.PastGames = append(content.PastGames, gd)
+		}
+	}
+
+	if !content.ShowPrivate {
+		return content, nil
+	}
+	content.Email, content.EmailVerified = rec.Email, rec.EmailVerified
+	content.Timezone, content.NotifyTurns, content.NotifyInvites = rec.Timezone, rec.NotifyTurns, rec.NotifyInvites
+	identities, err := a.db.UserIdentities(rec.Id)
+	if err != nil {
+		return content, err
+	}
+	linked := make(map[string]bool)
+	for _, i := range identities {
+		linked[i.Provider] = true
+		content.Identities = append(content.Identities, IdentityData{
+			Provider: i.Provider,
+			Name:     i.Name,
+			Email:    i.Email,
+			LinkedAt: i.CreatedAt.Format(a.timestampFormat),
+		})
+		if isAvatarURL(i.Avatar) {
+			content.Avatars = append(content.Avatars, AvatarData{Provider: i.Provider, URL: i.Avatar, Selected: i.Avatar == rec.AvatarURL})
+		}
+	}
+	if content.IsOwner {
+		for _, p := range a.authn {
+			if !linked[p.Code()] {
+				content.Providers = append(content.Providers, ProviderData{Code: p.Code(), Name: p.Name()})
+			}
+		}
+	}
+	return content, nil
+}
+
+func (a *App) renderProfile(w http.ResponseWriter, r *http.Request, t *templateHandler, content ProfileData) {
+	viewer := a.currentUser(r)
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = content.Handle
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", viewer.Id())},
+		{Text: "Games", Url: "/games"},
+		{Text: "Sessions", Url: "/sessions"},
+		{Text: "Documentation", Url: "/docs"},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	if viewer.Can(rbac.UserManage) {
+		payload.Site.NavBar.Links = append(payload.Site.NavBar.Links, LinkData{Text: "Users", Url: "/users"})
+	}
+	t.render(w, r, payload)
+}
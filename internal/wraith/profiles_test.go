@@ -0,0 +1,52 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileViews(t *testing.T) {
+	a, db := newTestApp(t)
+	answerUsers(db,
+		UserRecord{Id: "u-bob", Handle: "bob", Email: "bob@example.com", Timezone: "UTC", CreatedAt: time.Now().UTC()},
+	)
+	bob := signIn(t, a, "u-bob", "bob")
+	carol := signIn(t, a, "u-carol", "carol")
+	admin := signIn(t, a, "u-admin", "admin", "admin")
+
+	for _, tc := range []struct {
+		id        int
+		target    string
+		session   *testSession
+		want      int
+		wantEmail bool
+	}{
+		{1, "/users/u-bob", bob, http.StatusOK, true},
+		{2, "/users/u-bob", admin, http.StatusOK, true},
+		{3, "/users/u-bob", carol, http.StatusOK, false},
+		{4, "/users/u-bob", nil, http.StatusNotFound, false},
+		{5, "/users/nobody", bob, http.StatusNotFound, false},
+	} {
+		r := newTestRequest(a, http.MethodGet, tc.target, nil, tc.session)
+		w := serve(a, r)
+		if w.Code != tc.want {
+			t.Errorf("%d: %s: want %d, got %d", tc.id, tc.target, tc.want, w.Code)
+		} else if got := strings.Contains(w.Body.String(), "bob@example.com"); got != tc.wantEmail {
+			t.Errorf("%d: %s: e-mail shown: want %v, got %v", tc.id, tc.target, tc.wantEmail, got)
+		}
+	}
+
+	// only the owner changes the profile
+	r := newTestRequest(a, http.MethodPost, "/users/u-bob/profile", url.Values{"timezone": {"UTC"}}, carol)
+	if w := serve(a, r); w.Code != http.StatusNotFound {
+		t.Errorf("update: want %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if list := db.executed("update users set"); len(list) != 0 {
+		t.Errorf("update: want no changes, got %d", len(list))
+	}
+}
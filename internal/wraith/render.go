@@ -30,6 +30,11 @@ type SiteData struct {
 	UseCDN      bool // if true, serve css and js from CDN
 	UseOutliner bool // if true, add outlines for debugging
 	Version     string
+	// Impersonating is the handle of the user an administrator
+	// is acting as. It is set for every page while impersonating.
+	Impersonating string
+	// CSRFToken must be sent with every request that changes state.
+	CSRFToken string
 }
 
 // PageData is the data for a page.
@@ -53,6 +58,72 @@ type LinkData struct {
 	Target string
 }
 
+// SignInData is the data for the sign-in page.
+type SignInData struct {
+	Providers []ProviderData
+	CSRFToken string // for the provider forms, which aren't sent by htmx
+}
+
+// ProviderData is the data for an authentication provider.
+type ProviderData struct {
+	Code string
+	Name string
+}
+
+// ProfileData is the data for a user's profile page.
+// Private fields are only set when the viewer owns the profile or manages users.
+type ProfileData struct {
+	Id          string
+	Handle      string
+	AvatarURL   string
+	IsOwner     bool // true if the viewer may edit the profile
+	ShowPrivate bool // true if the private fields are set
+	ActiveGames []GameData
+	PastGames   []GameData
+	// private fields
+	Email         string
+	EmailVerified bool
+	Timezone      string
+	NotifyTurns   bool
+	NotifyInvites bool
+	Avatars       []AvatarData // avatars the owner can choose from
+	Identities    []IdentityData
+	Providers     []ProviderData // providers that can still be linked
+	CSRFToken     string         // for the provider forms, which aren't sent by htmx
+	Message       string
+	Error         string
+}
+
+// AvatarData is an avatar the user can choose for their profile.
+type AvatarData struct {
+	Provider string
+	URL      string
+	Selected bool
+}
+
+// IdentityData is the data for an external identity linked to a user.
+type IdentityData struct {
+	Provider string
+	Name     string
+	Email    string
+	LinkedAt string
+}
+
+// SessionsData is the data for the list of a user's sessions.
+type SessionsData struct {
+	Sessions []SessionData
+}
+
+// SessionData is the data for a single session.
+type SessionData struct {
+	Id         string
+	Device     string
+	IP         string
+	CreatedAt  string
+	LastSeenAt string
+	Current    bool // true if this is the session making the request
+}
+
 // FooterData is the data for a page's footer.
 type FooterData struct{}
 
@@ -83,6 +154,7 @@ func (t *templateHandler) AddFiles(root string, files ...string) error {
 }
 
 func (t *templateHandler) render(w http.ResponseWriter, r *http.Request, data any) {
+	data = withRequestData(r, data)
 	buf := &bytes.Buffer{}
 	var err error
 	t.t, err = template.ParseFiles(t.files...)
@@ -102,12 +174,45 @@ func (t *templateHandler) render(w http.ResponseWriter, r *http.Request, data an
 	_, _ = w.Write(buf.Bytes())
 }
 
+// withRequestData adds the data that every page needs from the request to a page's payload.
+// That is the CSRF token and the impersonation banner, so that an administrator
+// can't forget who they are acting as.
+func withRequestData(r *http.Request, data any) any {
+	p, ok := data.(Payload)
+	if !ok {
+		return data
+	}
+	p.Site.CSRFToken = csrfTokenFor(r)
+	if u, ok := r.Context().Value(userContextKey("user")).(User); ok && u.IsImpersonated() {
+		p.Site.Impersonating = u.handle
+	}
+	return p
+}
+
+// renderPartial renders a single named template without the layout.
+// It is used to answer htmx requests that replace part of a page.
+func (t *templateHandler) renderPartial(w http.ResponseWriter, r *http.Request, name string, data any) {
+	buf := &bytes.Buffer{}
+	tt, err := template.ParseFiles(t.files...)
+	if err != nil {
+		log.Printf("%s %s: render: parse: %v\n", r.Method, r.URL.Path, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	} else if err = tt.ExecuteTemplate(buf, name, data); err != nil {
+		log.Printf("%s %s: render: execute: %v\n", r.Method, r.URL.Path, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
 func (a *App) render(w http.ResponseWriter, r *http.Request, t *templateHandler, data any) {
 	//if p, ok := data.(Payload); ok {
 	//	log.Printf("%s %s: render: content %+v\n", r.Method, r.URL, p.Content)
 	//}
 
 	w.Header().Set("Wraith-Version", a.version)
+	data = withRequestData(r, data)
 
 	var err error
 	t.t, err = template.ParseFiles(t.files...)
@@ -0,0 +1,261 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/engine"
+	"github.com/mdhender/wraithi/internal/mail"
+	"github.com/mdhender/wraithi/internal/rbac"
+	"github.com/mdhender/wraithi/internal/reports"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportsData is the data for the list of a nation's turn reports.
+type ReportsData struct {
+	Id      string // the game's id
+	Name    string // the game's name
+	Nation  int
+	Nations []int // nations that a game master can pick from; empty for players
+	Turns   []int // latest first
+}
+
+// ReportData is the data for a nation's report for a turn.
+type ReportData struct {
+	Id       string // the game's id
+	Nation   int
+	Previous int // the turn before; -1 if there isn't one
+	Next     int // the turn after; zero if there isn't one
+	Report   *reports.Report
+}
+
+// getGamesGameReports lists the turns that the nation has reports for.
+func (a *App) getGamesGameReports() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "reports")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getGamesGameReports: %v", err))
+	}
+	m, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "message")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getGamesGameReports: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game := a.currentUser(r), a.currentGame(r)
+		latest, err := a.latestTurn(game.Id)
+		if errors.Is(err, ErrGameNotStarted) {
+			a.renderReportsMessage(w, r, m, user, game)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		nation, ok := a.reportNation(r)
+		if !ok {
+			nfh(w, r)
+			return
+		}
+		view, err := a.loadGameView(game.Id, latest, nation)
+		if errors.Is(err, engine.ErrUnknownNation) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		content := ReportsData{Id: game.Id, Name: game.Name, Nation: nation}
+		if a.currentGameMember(r).Nation == 0 {
+			for _, n := range view.Nations {
+				content.Nations = append(content.Nations, n.Id)
+			}
+		}
+		for turn := latest; turn >= 0; turn-- {
+			content.Turns = append(content.Turns, turn)
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = fmt.Sprintf("%s: Reports", game.Name)
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Game", Url: fmt.Sprintf("/games/%s", game.Id)},
+			{Text: "Games", Url: "/games"},
+			{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+			{Text: "Sign Out", Url: "/signout"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// getGamesGameReportsTurn shows the nation's report for a turn.
+// The report is HTML, or plain text or JSON when the turn ends in ".txt" or ".json".
+func (a *App) getGamesGameReportsTurn() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "report")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getGamesGameReportsTurn: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game := a.currentUser(r), a.currentGame(r)
+		param, format := way.Param(r.Context(), "turn"), "html"
+		if s, ok := strings.CutSuffix(param, ".txt"); ok {
+			param, format = s, "text"
+		} else if s, ok := strings.CutSuffix(param, ".json"); ok {
+			param, format = s, "json"
+		}
+		turn, err := strconv.Atoi(param)
+		if err != nil || turn < 0 {
+			nfh(w, r)
+			return
+		}
+		nation, ok := a.reportNation(r)
+		if !ok {
+			nfh(w, r)
+			return
+		}
+		view, err := a.loadGameView(game.Id, turn, nation)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, engine.ErrUnknownNation) {
+			nfh(w, r)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		report, err := a.gameReport(game.Id, view)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+
+		switch format {
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				log.Printf("%s %s: json: %v\n", r.Method, r.URL, err)
+			}
+			return
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if err := report.WriteText(w); err != nil {
+				log.Printf("%s %s: text: %v\n", r.Method, r.URL, err)
+			}
+			return
+		}
+
+		content := ReportData{Id: game.Id, Nation: nation, Previous: turn - 1, Report: report}
+		if latest, err := a.latestTurn(game.Id); err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if turn < latest {
+			content.Next = turn + 1
+		}
+		payload := Payload{Site: a.templates.site, Content: content}
+		payload.Page.Title = fmt.Sprintf("%s: Turn %d", game.Name, turn)
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Reports", Url: fmt.Sprintf("/games/%s/reports?nation=%d", game.Id, nation)},
+			{Text: "Game", Url: fmt.Sprintf("/games/%s", game.Id)},
+			{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+			{Text: "Sign Out", Url: "/signout"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// reportNation returns the nation whose reports the user may read.
+// Players and eliminated players only get their own nation's reports.
+// Game masters pick any nation with the "nation" query parameter.
+func (a *App) reportNation(r *http.Request) (int, bool) {
+	user, member := a.currentUser(r), a.currentGameMember(r)
+	if member.Nation != 0 {
+		return member.Nation, true
+	} else if !member.HasRole(GameRoleGM) && !user.Can(rbac.GameAdmin) {
+		return 0, false
+	}
+	nation := 1
+	if s := r.FormValue("nation"); s != "" {
+		var err error
+		if nation, err = strconv.Atoi(s); err != nil {
+			return 0, false
+		}
+	}
+	return nation, true
+}
+
+// gameReport returns the nation's report for the turn that ended with the view.
+// Reports are made from the saved state and events whenever they're asked for,
+// so every past turn's report stays available.
+func (a *App) gameReport(gameId string, view *engine.View) (*reports.Report, error) {
+	events, err := a.loadGameEvents(gameId, view.Turn)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return reports.New(view, events)
+}
+
+// mailReports e-mails each player who asked for it their report for the turn.
+// Mistakes are logged; they don't undo the turn.
+func (a *App) mailReports(r *http.Request, game GameRecord, turn engine.Turn) {
+	members, err := a.db.GameMembers(game.Id)
+	if err != nil {
+		log.Printf("%s %s: mail reports: %v\n", r.Method, r.URL, err)
+		return
+	}
+	for _, m := range members {
+		view, err := turn.Game.View(m.Nation)
+		if err != nil {
+			// observers and game masters don't have a nation
+			continue
+		}
+		u, err := a.db.UserById(m.UserId)
+		if err != nil {
+			log.Printf("%s %s: mail reports: %q: %v\n", r.Method, r.URL, m.UserId, err)
+			continue
+		} else if !u.NotifyTurns || !u.EmailVerified || u.Disabled {
+			continue
+		}
+		report, err := reports.New(view, turn.Events)
+		if err != nil {
+			log.Printf("%s %s: mail reports: %q: %v\n", r.Method, r.URL, m.UserId, err)
+			continue
+		}
+		body := &bytes.Buffer{}
+		_, _ = fmt.Fprintf(body, "Hi %s,\n\nTurn %d of %s has been run. Your report is below and at\n\n    %s/games/%s/reports/%d\n\n",
+			u.Handle, turn.Number, game.Name, a.baseURL, game.Id, turn.Number)
+		if err := report.WriteText(body); err != nil {
+			log.Printf("%s %s: mail reports: %q: %v\n", r.Method, r.URL, m.UserId, err)
+			continue
+		}
+		if err := a.mail.mailer.Send(r.Context(), mail.Message{
+			From:    a.mail.from,
+			To:      []string{u.Email},
+			Subject: fmt.Sprintf("%s: turn %d report", game.Name, turn.Number),
+			Body:    body.String(),
+			Date:    time.Now(),
+		}); err != nil {
+			log.Printf("%s %s: mail reports: %q: %v\n", r.Method, r.URL, m.UserId, err)
+		}
+	}
+}
+
+// renderReportsMessage explains that there are no reports until the game starts.
+func (a *App) renderReportsMessage(w http.ResponseWriter, r *http.Request, t *templateHandler, user User, game GameRecord) {
+	payload := Payload{Site: a.templates.site, Content: MessageData{
+		Title:   "Reports",
+		Message: "The game hasn't started yet, so there are no reports.",
+		Link:    LinkData{Text: "Back to the game", Url: fmt.Sprintf("/games/%s", game.Id)},
+	}}
+	payload.Page.Title = fmt.Sprintf("%s: Reports", game.Name)
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Games", Url: "/games"},
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	t.render(w, r, payload)
+}
@@ -0,0 +1,74 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReportAccess(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-gm", Handle: "gm", Role: GameRoleGM},
+		GameMemberRecord{UserId: "u-p1", Handle: "p1", Role: GameRolePlayer, Nation: 1},
+		GameMemberRecord{UserId: "u-p2", Handle: "p2", Role: GameRoleEliminated, Nation: 2},
+		GameMemberRecord{UserId: "u-observer", Handle: "observer", Role: GameRoleObserver},
+	)
+	gm := signIn(t, a, "u-gm", "gm")
+	p1 := signIn(t, a, "u-p1", "p1")
+	p2 := signIn(t, a, "u-p2", "p2")
+	observer := signIn(t, a, "u-observer", "observer")
+	outsider := signIn(t, a, "u-outsider", "outsider")
+	admin := signIn(t, a, "u-admin", "admin", "admin")
+	startGame(t, a, "g1", gm)
+
+	for _, tc := range []struct {
+		id         int
+		target     string
+		session    *testSession
+		want       int
+		wantNation int // the nation whose report is returned
+	}{
+		{1, "/games/g1/reports", p1, http.StatusOK, 0},
+		{2, "/games/g1/reports", gm, http.StatusOK, 0},
+		{3, "/games/g1/reports", observer, http.StatusNotFound, 0},
+		{4, "/games/g1/reports", outsider, http.StatusNotFound, 0},
+		{5, "/games/g1/reports/0", p1, http.StatusOK, 0},
+		{6, "/games/g1/reports/0.json", p1, http.StatusOK, 1},
+		{7, "/games/g1/reports/0.json", p2, http.StatusOK, 2},
+		// players only ever get their own nation's report
+		{8, "/games/g1/reports/0.json?nation=2", p1, http.StatusOK, 1},
+		// game masters and administrators pick the nation
+		{9, "/games/g1/reports/0.json?nation=2", gm, http.StatusOK, 2},
+		{10, "/games/g1/reports/0.json?nation=2", admin, http.StatusOK, 2},
+		{11, "/games/g1/reports/0.json?nation=99", gm, http.StatusNotFound, 0},
+		{12, "/games/g1/reports/0.json?nation=x", gm, http.StatusNotFound, 0},
+		{13, "/games/g1/reports/0.json", observer, http.StatusNotFound, 0},
+		{14, "/games/g1/reports/0.json", outsider, http.StatusNotFound, 0},
+		{15, "/games/g1/reports/0.txt", nil, http.StatusNotFound, 0},
+		{16, "/games/g1/reports/1.json", p1, http.StatusNotFound, 0},
+		{17, "/games/g1/reports/-1.json", p1, http.StatusNotFound, 0},
+		{18, "/games/g2/reports/0.json", p1, http.StatusNotFound, 0},
+	} {
+		r := newTestRequest(a, http.MethodGet, tc.target, nil, tc.session)
+		w := serve(a, r)
+		if w.Code != tc.want {
+			t.Errorf("%d: %s: want %d, got %d", tc.id, tc.target, tc.want, w.Code)
+			continue
+		} else if tc.wantNation == 0 {
+			continue
+		}
+		var report struct {
+			Nation struct {
+				Id int `json:"id"`
+			} `json:"nation"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Errorf("%d: %s: json: %v", tc.id, tc.target, err)
+		} else if report.Nation.Id != tc.wantNation {
+			t.Errorf("%d: %s: want nation %d, got %d", tc.id, tc.target, tc.wantNation, report.Nation.Id)
+		}
+	}
+}
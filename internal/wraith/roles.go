@@ -0,0 +1,29 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+// Grants returns the permissions granted by each role.
+func (db *DB) Grants() (map[string][]string, error) {
+	rows, err := db.db.QueryContext(db.context, "select r.name, p.permission from roles r left join role_permissions p on p.role = r.name order by r.name, p.permission")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	grants := make(map[string][]string)
+	for rows.Next() {
+		var role string
+		var perm *string
+		if err := rows.Scan(&role, &perm); err != nil {
+			return nil, err
+		}
+		// roles without permissions are still roles
+		if perm == nil {
+			grants[role] = grants[role]
+		} else {
+			grants[role] = append(grants[role], *perm)
+		}
+	}
+	return grants, rows.Err()
+}
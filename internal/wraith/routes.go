@@ -6,6 +6,7 @@ import (
 	"fmt"
 	//"github.com/go-chi/chi/v5"
 	//"github.com/go-chi/chi/v5/middleware"
+	"github.com/mdhender/wraithi/internal/rbac"
 	"github.com/mdhender/wraithi/internal/way"
 	"net/http"
 )
@@ -37,9 +38,18 @@ func (a *App) routes() http.Handler {
 	// public routes
 	wayRouter.HandleFunc("GET", "/", a.getIndex())
 	wayRouter.HandleFunc("GET", "/guest", a.getGuest())
+	wayRouter.HandleFunc("GET", "/reset", a.getReset())
+	wayRouter.HandleFunc("POST", "/reset", a.postReset())
+	wayRouter.HandleFunc("GET", "/reset/confirm", a.getResetConfirm())
+	wayRouter.HandleFunc("POST", "/reset/confirm", a.postResetConfirm())
 	wayRouter.HandleFunc("GET", "/signin", a.getSignIn())
 	wayRouter.HandleFunc("POST", "/signin", a.postSignIn())
+	wayRouter.HandleFunc("GET", "/signin/2fa", a.getSignIn2FA())
+	wayRouter.HandleFunc("POST", "/signin/2fa", a.postSignIn2FA())
 	wayRouter.HandleFunc("GET", "/signout", a.getSignOut())
+	wayRouter.HandleFunc("GET", "/signup", a.getSignUp())
+	wayRouter.HandleFunc("POST", "/signup", a.postSignUp())
+	wayRouter.HandleFunc("GET", "/verify", a.getVerify())
 	wayRouter.HandleFunc("GET", "/welcome", a.getWelcome())
 
 	wayRouter.HandleFunc("GET", "/index.html", func(w http.ResponseWriter, r *http.Request) {
@@ -56,9 +66,60 @@ func (a *App) routes() http.Handler {
 	wayRouter.HandleFunc("POST", "/auth/login", a.postAuthLogin())
 
 	// protected routes
-	wayRouter.Handle("GET", "/games", a.authOnly(a.getGames()))
-	wayRouter.Handle("GET", "/users", a.authOnly(a.getUsers()))
-	wayRouter.Handle("GET", "/users/:id", a.authOnly(a.getUsersId()))
+	wayRouter.HandleFunc("POST", "/impersonate/stop", a.postImpersonateStop())
+	// account management is only for the real user, never an administrator acting as them
+	account := func(h http.Handler) http.Handler {
+		return a.requirePermission(rbac.AccountManage)(a.notImpersonating()(h))
+	}
+	manage := func(h http.Handler) http.Handler {
+		return a.requirePermission(rbac.UserManage)(a.notImpersonating()(h))
+	}
+	invite := func(h http.Handler) http.Handler {
+		return a.requirePermission(rbac.InviteCreate)(a.notImpersonating()(h))
+	}
+	wayRouter.Handle("GET", "/audit", a.requirePermission(rbac.AuditRead)(a.notImpersonating()(a.getAudit())))
+	wayRouter.Handle("GET", "/games", a.requirePermission(rbac.GamesRead)(a.getGames()))
+	wayRouter.Handle("POST", "/games", account(a.requirePermission(rbac.GameCreate)(a.postGames())))
+	wayRouter.Handle("GET", "/games/:game", a.requirePermission(rbac.GamesRead)(a.requireGameRole(gameRoles...)(a.getGamesGame())))
+	wayRouter.Handle("POST", "/games/:game/finish", account(a.requireGameRole(GameRoleGM)(a.postGamesGameFinish())))
+	wayRouter.Handle("POST", "/games/:game/invites", account(a.requireGameRole(GameRoleGM)(a.postGamesGameInvites())))
+	wayRouter.Handle("POST", "/games/:game/invites/:iid/delete", account(a.requireGameRole(GameRoleGM)(a.postGamesGameInvitesDelete())))
+	wayRouter.Handle("POST", "/games/:game/members", account(a.requireGameRole(GameRoleGM)(a.postGamesGameMembers())))
+	wayRouter.Handle("GET", "/games/:game/orders", a.requirePermission(rbac.GamesRead)(a.requireGameRole(GameRolePlayer)(a.getGamesGameOrders())))
+	wayRouter.Handle("POST", "/games/:game/orders", a.requirePermission(rbac.OrdersSubmit)(a.notImpersonating()(a.requireGameRole(GameRolePlayer)(a.postGamesGameOrders()))))
+	wayRouter.Handle("GET", "/games/:game/reports", a.requirePermission(rbac.GamesRead)(a.requireGameRole(GameRolePlayer, GameRoleEliminated, GameRoleGM)(a.getGamesGameReports())))
+	wayRouter.Handle("GET", "/games/:game/reports/:turn", a.requirePermission(rbac.GamesRead)(a.requireGameRole(GameRolePlayer, GameRoleEliminated, GameRoleGM)(a.getGamesGameReportsTurn())))
+	wayRouter.Handle("POST", "/games/:game/start", account(a.requireGameRole(GameRoleGM)(a.postGamesGameStart())))
+	wayRouter.Handle("POST", "/games/:game/turn", account(a.requireGameRole(GameRoleGM)(a.postGamesGameTurn())))
+	wayRouter.Handle("GET", "/invites", invite(a.getInvites()))
+	wayRouter.Handle("POST", "/invites", invite(a.postInvites()))
+	wayRouter.Handle("POST", "/invites/redeem", account(a.postInvitesRedeem()))
+	wayRouter.Handle("POST", "/invites/:iid/delete", invite(a.postInvitesDelete()))
+	wayRouter.Handle("GET", "/lockouts", manage(a.getLockouts()))
+	wayRouter.Handle("POST", "/lockouts/clear", manage(a.postLockoutsClear()))
+	wayRouter.Handle("GET", "/sessions", account(a.getSessions()))
+	wayRouter.Handle("POST", "/sessions/revoke", account(a.postSessionsRevokeAll()))
+	wayRouter.Handle("POST", "/sessions/:sid/revoke", account(a.postSessionsRevoke()))
+	wayRouter.Handle("GET", "/users", manage(a.getUsers()))
+	wayRouter.Handle("GET", "/users/:id", a.requirePermission(rbac.ProfileRead)(a.getUsersId()))
+	wayRouter.Handle("GET", "/users/:id/admin", manage(a.getUsersIdAdmin()))
+	wayRouter.Handle("POST", "/users/:id/admin/delete", manage(a.postUsersIdAdminDelete()))
+	wayRouter.Handle("POST", "/users/:id/admin/disable", manage(a.postUsersIdAdminDisable()))
+	wayRouter.Handle("POST", "/users/:id/admin/enable", manage(a.postUsersIdAdminEnable()))
+	wayRouter.Handle("POST", "/users/:id/admin/roles", manage(a.postUsersIdAdminRoles()))
+	wayRouter.Handle("POST", "/users/:id/admin/unlock", manage(a.postUsersIdAdminUnlock()))
+	wayRouter.Handle("GET", "/users/:id/2fa", account(a.getUsersId2FA()))
+	wayRouter.Handle("POST", "/users/:id/2fa/disable", account(a.postUsersId2FADisable()))
+	wayRouter.Handle("POST", "/users/:id/2fa/enable", account(a.postUsersId2FAEnable()))
+	wayRouter.Handle("POST", "/users/:id/2fa/reset", manage(a.postUsersId2FAReset()))
+	wayRouter.Handle("POST", "/users/:id/impersonate", a.notImpersonating()(a.requirePermission(rbac.UserImpersonate)(a.postUsersIdImpersonate())))
+	wayRouter.Handle("POST", "/users/:id/identities/:provider/unlink", account(a.postUsersIdIdentitiesUnlink()))
+	wayRouter.Handle("POST", "/users/:id/profile", account(a.postUsersIdProfile()))
+	wayRouter.Handle("GET", "/users/:id/tokens", account(a.getUsersIdTokens()))
+	wayRouter.Handle("POST", "/users/:id/tokens", account(a.postUsersIdTokens()))
+	wayRouter.Handle("POST", "/users/:id/tokens/:tid/revoke", account(a.postUsersIdTokensRevoke()))
+	wayRouter.Handle("POST", "/users/:id/verify", account(a.postUsersIdVerify()))
+	wayRouter.Handle("POST", "/users/:id/sessions/revoke", manage(a.postUsersIdSessionsRevoke()))
 
 	// not found is also our assets server
 	wayRouter.NotFound = a.assetServer("", a.assets, false)
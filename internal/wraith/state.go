@@ -0,0 +1,182 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/engine"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Each game's state is kept under the data path, one file for each turn,
+// so that past turns can be read back:
+//
+//	games/<game id>/turn-0000.json
+//	games/<game id>/turn-0001.json
+//	games/<game id>/events-0001.json
+//
+// The events file holds what happened while the turn was run.
+//
+// The files hold the complete state, which only the turn runner uses.
+// Everything that is shown to players is read with loadGameView,
+// so that a page can't show a nation more than it can see.
+
+// gameStateDir returns the folder that holds the game's state files.
+func (a *App) gameStateDir(gameId string) string {
+	return filepath.Join(a.data, "games", gameId)
+}
+
+// gameStatePath returns the file that holds the game's state at the start of the turn.
+func (a *App) gameStatePath(gameId string, turn int) string {
+	return filepath.Join(a.gameStateDir(gameId), fmt.Sprintf("turn-%04d.json", turn))
+}
+
+// gameEventsPath returns the file that holds the events of the turn.
+func (a *App) gameEventsPath(gameId string, turn int) string {
+	return filepath.Join(a.gameStateDir(gameId), fmt.Sprintf("events-%04d.json", turn))
+}
+
+// loadGameState returns the game's state at the start of the latest turn.
+// Returns ErrGameNotStarted if no state has been saved.
+func (a *App) loadGameState(gameId string) (*engine.Game, error) {
+	latest, err := a.latestTurn(gameId)
+	if err != nil {
+		return nil, err
+	}
+	return a.loadGameTurn(gameId, latest)
+}
+
+// latestTurn returns the number of the latest turn that has been saved.
+// Returns ErrGameNotStarted if no state has been saved.
+func (a *App) latestTurn(gameId string) (int, error) {
+	entries, err := os.ReadDir(a.gameStateDir(gameId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrGameNotStarted
+	} else if err != nil {
+		return 0, err
+	}
+	latest := -1
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "turn-")
+		if !ok || entry.IsDir() {
+			continue
+		} else if name, ok = strings.CutSuffix(name, ".json"); !ok {
+			continue
+		} else if n, err := strconv.Atoi(name); err == nil && n > latest {
+			latest = n
+		}
+	}
+	if latest < 0 {
+		return 0, ErrGameNotStarted
+	}
+	return latest, nil
+}
+
+// loadGameTurn returns the game's state at the start of the turn.
+// Returns ErrNotFound if the turn wasn't saved.
+func (a *App) loadGameTurn(gameId string, turn int) (*engine.Game, error) {
+	fp, err := os.Open(a.gameStatePath(gameId, turn))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+	return engine.Load(fp)
+}
+
+// loadGameView returns the nation's view of the game at the start of the turn.
+// Returns ErrNotFound if the turn wasn't saved and engine.ErrUnknownNation
+// if the nation isn't in the game.
+func (a *App) loadGameView(gameId string, turn, nation int) (*engine.View, error) {
+	state, err := a.loadGameTurn(gameId, turn)
+	if err != nil {
+		return nil, err
+	}
+	return state.View(nation)
+}
+
+// loadGameEvents returns the events of the turn.
+// Returns ErrNotFound if the turn wasn't saved.
+func (a *App) loadGameEvents(gameId string, turn int) ([]engine.Event, error) {
+	fp, err := os.Open(a.gameEventsPath(gameId, turn))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+	return engine.LoadEvents(fp)
+}
+
+// saveGameTurn saves the game's state at the end of the turn and the events of the turn.
+// Returns ErrTurnExists if the turn has already been saved, so that two game masters
+// running the same turn can't overwrite each other's results.
+// The turn's state file is linked last because its existence is what makes the turn
+// the latest; readers never see a turn whose events are missing.
+func (a *App) saveGameTurn(gameId string, turn engine.Turn) error {
+	dir := a.gameStateDir(gameId)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	state, err := writeTemp(dir, turn.Game.Save)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(state)
+	}()
+	events, err := writeTemp(dir, func(w io.Writer) error {
+		return engine.SaveEvents(w, turn.Events)
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(events)
+	}()
+	// linking fails if the file exists, which renaming wouldn't.
+	// an events file without a state file is left by a run that failed part way,
+	// and is replaced by the next run of the turn.
+	if _, err := os.Stat(a.gameStatePath(gameId, turn.Number)); err == nil {
+		return ErrTurnExists
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(events, a.gameEventsPath(gameId, turn.Number)); err != nil {
+		return err
+	}
+	if err := os.Link(state, a.gameStatePath(gameId, turn.Number)); errors.Is(err, fs.ErrExist) {
+		return ErrTurnExists
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeTemp writes a temporary file in the folder and returns its name.
+func writeTemp(dir string, write func(w io.Writer) error) (string, error) {
+	fp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if err := write(fp); err != nil {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+		return "", err
+	} else if err := fp.Close(); err != nil {
+		_ = os.Remove(fp.Name())
+		return "", err
+	}
+	return fp.Name(), nil
+}
@@ -0,0 +1,166 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/engine"
+	"github.com/mdhender/wraithi/internal/orders"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// postGamesGameStart generates the galaxy and saves the game's state for turn zero.
+// There is a nation for each number up to the highest one given to a player.
+// The seed is optional; a random one is used if it is left out.
+func (a *App) postGamesGameStart() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "game")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postGamesGameStart: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game := a.currentUser(r), a.currentGame(r)
+		var seed uint64
+		var err error
+		if s := strings.TrimSpace(r.FormValue("seed")); s == "" {
+			var b [8]byte
+			if _, err := rand.Read(b[:]); err != nil {
+				a.internalError(w, r, err)
+				return
+			}
+			seed = binary.BigEndian.Uint64(b[:])
+		} else if seed, err = strconv.ParseUint(s, 0, 64); err != nil {
+			err = ErrInvalidSeed
+		}
+		var state *engine.Game
+		if err == nil {
+			state, err = a.newGameState(game, seed)
+		}
+		if err == nil {
+			if err = a.saveGameTurn(game.Id, engine.Turn{Game: state}); errors.Is(err, ErrTurnExists) {
+				err = ErrGameStarted
+			}
+		}
+		if errors.Is(err, ErrInvalidSeed) || errors.Is(err, ErrGameFinished) || errors.Is(err, ErrGameStarted) || errors.Is(err, ErrNoPlayers) || errors.Is(err, engine.ErrInvalidOption) {
+			content, lerr := a.gameData(r)
+			if lerr != nil {
+				a.internalError(w, r, lerr)
+				return
+			}
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			a.renderGame(w, r, t, user, content)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q started game %q with seed %d\n", r.Method, r.URL, user.Id(), game.Id, seed)
+		a.audit(r, audit.GameStart, audit.TargetGame, game.Id, fmt.Sprintf("seed %d: %d nations", seed, len(state.Nations)))
+		http.Redirect(w, r, fmt.Sprintf("/games/%s", game.Id), http.StatusSeeOther)
+	}
+}
+
+// postGamesGameTurn runs the next turn with the orders that the nations have submitted.
+// Lines that can't be read are left out; the players were shown them when they submitted.
+func (a *App) postGamesGameTurn() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "game")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postGamesGameTurn: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, game := a.currentUser(r), a.currentGame(r)
+		state, err := a.openGameState(game)
+		if errors.Is(err, ErrGameNotStarted) || errors.Is(err, ErrGameFinished) {
+			content, lerr := a.gameData(r)
+			if lerr != nil {
+				a.internalError(w, r, lerr)
+				return
+			}
+			content.Error = err.Error()
+			w.WriteHeader(http.StatusConflict)
+			a.renderGame(w, r, t, user, content)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+
+		submitted, err := a.db.GameOrders(game.Id, state.Turn+1)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		list := map[int][]engine.Order{}
+		for _, o := range submitted {
+			if state.Nation(o.Nation) == nil {
+				log.Printf("%s %s: game %q: turn %d: ignoring orders for unknown nation %d\n", r.Method, r.URL, game.Id, o.Turn, o.Nation)
+				continue
+			}
+			list[o.Nation], _ = orders.Parse(o.Text)
+		}
+		turn, err := engine.Advance(state, list)
+		if err == nil {
+			err = a.saveGameTurn(game.Id, turn)
+		}
+		if errors.Is(err, ErrTurnExists) {
+			// another game master ran the turn first
+			http.Redirect(w, r, fmt.Sprintf("/games/%s", game.Id), http.StatusSeeOther)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q ran turn %d of game %q\n", r.Method, r.URL, user.Id(), turn.Number, game.Id)
+		a.audit(r, audit.TurnRun, audit.TargetGame, game.Id, fmt.Sprintf("turn %d: orders from %d of %d nations", turn.Number, len(list), len(state.Nations)))
+		a.mailReports(r, game, turn)
+		http.Redirect(w, r, fmt.Sprintf("/games/%s", game.Id), http.StatusSeeOther)
+	}
+}
+
+// openGameState returns the complete state of a game that is accepting orders.
+// It is only for running turns; pages use openGameView.
+// Returns ErrGameNotStarted or ErrGameFinished if the game isn't accepting orders.
+func (a *App) openGameState(game GameRecord) (*engine.Game, error) {
+	if !game.FinishedAt.IsZero() {
+		return nil, ErrGameFinished
+	}
+	return a.loadGameState(game.Id)
+}
+
+// newGameState generates the galaxy for the game with the default options.
+func (a *App) newGameState(game GameRecord, seed uint64) (*engine.Game, error) {
+	if !game.FinishedAt.IsZero() {
+		return nil, ErrGameFinished
+	} else if _, err := a.latestTurn(game.Id); err == nil {
+		return nil, ErrGameStarted
+	} else if !errors.Is(err, ErrGameNotStarted) {
+		return nil, err
+	}
+	members, err := a.db.GameMembers(game.Id)
+	if err != nil {
+		return nil, err
+	}
+	opts := engine.NewGalaxyOptions()
+	opts.Players = 0
+	for _, m := range members {
+		opts.Players = max(opts.Players, m.Nation)
+	}
+	if opts.Players == 0 {
+		return nil, ErrNoPlayers
+	}
+	state, err := engine.Generate(seed, opts)
+	if err != nil {
+		return nil, err
+	}
+	state.Id, state.Name = game.Id, game.Name
+	return state, nil
+}
@@ -0,0 +1,96 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"errors"
+	"github.com/mdhender/wraithi/internal/engine"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestTurnPermissions(t *testing.T) {
+	a, db := newTestApp(t)
+	answerGame(db, "g1", "Alpha",
+		GameMemberRecord{UserId: "u-gm", Handle: "gm", Role: GameRoleGM},
+		GameMemberRecord{UserId: "u-p1", Handle: "p1", Role: GameRolePlayer, Nation: 1},
+		GameMemberRecord{UserId: "u-observer", Handle: "observer", Role: GameRoleObserver},
+	)
+	gm := signIn(t, a, "u-gm", "gm")
+	p1 := signIn(t, a, "u-p1", "p1")
+	observer := signIn(t, a, "u-observer", "observer")
+
+	for _, tc := range []struct {
+		id      int
+		target  string
+		session *testSession
+		want    int
+	}{
+		{1, "/games/g1/start", p1, http.StatusNotFound},
+		{2, "/games/g1/start", observer, http.StatusNotFound},
+		{3, "/games/g1/turn", gm, http.StatusConflict},
+		{4, "/games/g1/start", gm, http.StatusSeeOther},
+		{5, "/games/g1/start", gm, http.StatusUnprocessableEntity},
+		{6, "/games/g1/turn", p1, http.StatusNotFound},
+		{7, "/games/g1/turn", observer, http.StatusNotFound},
+		{8, "/games/g1/turn", gm, http.StatusSeeOther},
+	} {
+		r := newTestRequest(a, http.MethodPost, tc.target, map[string][]string{"seed": {"1"}}, tc.session)
+		if w := serve(a, r); w.Code != tc.want {
+			t.Errorf("%d: %s: want %d, got %d", tc.id, tc.target, tc.want, w.Code)
+		}
+	}
+	if latest, err := a.latestTurn("g1"); err != nil || latest != 1 {
+		t.Errorf("latest: want 1, got %d, %v", latest, err)
+	} else if _, err := a.loadGameEvents("g1", 1); err != nil {
+		t.Errorf("events: want nil, got %v", err)
+	}
+}
+
+func TestSaveGameTurn(t *testing.T) {
+	a, _ := newTestApp(t)
+	state, err := engine.Generate(1, engine.NewGalaxyOptions())
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	first, err := engine.Advance(state, nil)
+	if err != nil {
+		t.Fatalf("advance: %v", err)
+	}
+	if err := a.saveGameTurn("g1", engine.Turn{Game: state}); err != nil {
+		t.Fatalf("save: turn 0: %v", err)
+	}
+
+	// the events of a run that failed before its state was saved are replaced
+	if err := os.WriteFile(a.gameEventsPath("g1", 1), []byte("left over"), 0644); err != nil {
+		t.Fatalf("events: %v", err)
+	} else if latest, err := a.latestTurn("g1"); err != nil || latest != 0 {
+		t.Fatalf("latest: want 0, got %d, %v", latest, err)
+	}
+	if err := a.saveGameTurn("g1", first); err != nil {
+		t.Fatalf("save: turn 1: %v", err)
+	}
+	events, err := a.loadGameEvents("g1", 1)
+	if err != nil {
+		t.Fatalf("events: want nil, got %v", err)
+	} else if len(events) != len(first.Events) {
+		t.Errorf("events: want %d, got %d", len(first.Events), len(events))
+	}
+
+	// a turn that has been saved isn't overwritten, and neither are its events
+	second := first
+	second.Events = nil
+	if err := a.saveGameTurn("g1", second); !errors.Is(err, ErrTurnExists) {
+		t.Errorf("save: again: want %v, got %v", ErrTurnExists, err)
+	}
+	if events, err := a.loadGameEvents("g1", 1); err != nil || len(events) != len(first.Events) {
+		t.Errorf("events: again: want %d, got %d, %v", len(first.Events), len(events), err)
+	}
+	// no temporary files are left behind
+	if entries, err := os.ReadDir(a.gameStateDir("g1")); err != nil {
+		t.Fatalf("files: %v", err)
+	} else if len(entries) != 4 {
+		t.Errorf("files: want 4, got %d", len(entries))
+	}
+}
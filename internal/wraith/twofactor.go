@@ -0,0 +1,408 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mdhender/wraithi/internal/audit"
+	"github.com/mdhender/wraithi/internal/lockout"
+	"github.com/mdhender/wraithi/internal/totp"
+	"github.com/mdhender/wraithi/internal/way"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pendingMFATTL is how long a user has to present the second factor after the first.
+const pendingMFATTL = 5 * time.Minute
+
+// recoveryCodeCount is the number of recovery codes issued at enrollment.
+const recoveryCodeCount = 10
+
+// TwoFactorData is the data for the two-factor enrollment page.
+type TwoFactorData struct {
+	Id            string
+	Enabled       bool
+	Secret        string   // only set while enrolling
+	URI           string   // otpauth URI for the secret
+	RecoveryCodes []string // only set right after enrollment
+	Remaining     int      // number of unused recovery codes
+	Error         string
+}
+
+// TOTP returns the user's TOTP secret, whether it's enabled, and the last step accepted.
+func (db *DB) TOTP(userId string) (secret string, enabled bool, lastStep int64, err error) {
+	row := db.db.QueryRowContext(db.context,
+		"select totp_secret, totp_enabled, totp_last_step from users where id = ?",
+		userId)
+	if err = row.Scan(&secret, &enabled, &lastStep); errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return secret, enabled, lastStep, err
+}
+
+// SetTOTPSecret saves a new secret for a user who is enrolling.
+// It doesn't change the secret once two-factor is enabled.
+func (db *DB) SetTOTPSecret(userId, secret string) error {
+	_, err := db.db.ExecContext(db.context,
+		"update users set totp_secret = ?, totp_last_step = 0 where id = ? and totp_enabled = false",
+		secret, userId)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication and replaces the user's recovery codes.
+func (db *DB) EnableTOTP(userId string, step int64, hashedCodes []string) error {
+	tx, err := db.db.BeginTx(db.context, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.ExecContext(db.context, "update users set totp_enabled = true, totp_last_step = ? where id = ?", step, userId); err != nil {
+		return err
+	} else if _, err := tx.ExecContext(db.context, "delete from user_recovery_codes where user_id = ?", userId); err != nil {
+		return err
+	}
+	for _, code := range hashedCodes {
+		if _, err := tx.ExecContext(db.context, "insert into user_recovery_codes (user_id, hashed_code) values (?, ?)", userId, code); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication and deletes the user's recovery codes.
+func (db *DB) DisableTOTP(userId string) error {
+	tx, err := db.db.BeginTx(db.context, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.ExecContext(db.context, "update users set totp_secret = '', totp_enabled = false, totp_last_step = 0 where id = ?", userId); err != nil {
+		return err
+	} else if _, err := tx.ExecContext(db.context, "delete from user_recovery_codes where user_id = ?", userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records the step of an accepted code.
+// Returns false if the step was already used, which stops two requests racing with the same code.
+func (db *DB) UseTOTPStep(userId string, step int64) (bool, error) {
+	result, err := db.db.ExecContext(db.context,
+		"update users set totp_last_step = ? where id = ? and totp_last_step < ?",
+		step, userId, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode marks the recovery code as used.
+// Returns false if the code doesn't exist or was already used.
+func (db *DB) UseRecoveryCode(userId, hashedCode string) (bool, error) {
+	result, err := db.db.ExecContext(db.context,
+		"update user_recovery_codes set used_at = ? where user_id = ? and hashed_code = ? and used_at is null",
+		time.Now().UTC(), userId, hashedCode)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes.
+func (db *DB) RemainingRecoveryCodes(userId string) (int, error) {
+	var n int
+	err := db.db.QueryRowContext(db.context,
+		"select count(*) from user_recovery_codes where user_id = ? and used_at is null",
+		userId).Scan(&n)
+	return n, err
+}
+
+// newRecoveryCodes returns a set of codes and their hashes.
+// Codes are 50 random bits, formatted as "xxxxx-xxxxx" to make them easier to copy.
+func (a *App) newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		code := s[:5] + "-" + s[5:]
+		codes, hashes = append(codes, code), append(hashes, a.hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the keyed hash of a recovery code.
+// The codes have enough entropy that a fast hash is safe.
+func (a *App) hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	mac := hmac.New(sha256.New, []byte(a.salt))
+	mac.Write([]byte("recovery-code:" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSecondFactor returns true if the code is a valid TOTP code or an unused recovery code.
+func (a *App) checkSecondFactor(userId, code string) (bool, error) {
+	secret, enabled, lastStep, err := a.db.TOTP(userId)
+	if err != nil {
+		return false, err
+	} else if !enabled {
+		return false, nil
+	}
+	if step, ok := totp.Validate(secret, code, time.Now(), lastStep); ok {
+		return a.db.UseTOTPStep(userId, step)
+	}
+	return a.db.UseRecoveryCode(userId, a.hashRecoveryCode(code))
+}
+
+// getSignIn2FA shows the form for the second factor.
+func (a *App) getSignIn2FA() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "signin_2fa")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getSignIn2FA: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.currentUser(r).pendingUserId == "" {
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+		payload := Payload{Site: a.templates.site, Content: MessageData{}}
+		payload.Site.NavBar = NavBarData{Links: []LinkData{
+			{Text: "Home", Url: "/"},
+		}}
+		t.render(w, r, payload)
+	}
+}
+
+// postSignIn2FA checks the second factor and, if it's good, replaces
+// the pending session with an active one.
+func (a *App) postSignIn2FA() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "signin_2fa")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postSignIn2FA: %v", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		pending := a.currentUser(r)
+		if pending.pendingUserId == "" {
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+		account, ip := lockout.Account(pending.pendingUserId), lockout.IP(clientIP(r))
+		if a.throttled(w, r, account, ip) {
+			return
+		}
+		ok, err := a.checkSecondFactor(pending.pendingUserId, r.FormValue("code"))
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if !ok {
+			log.Printf("%s %s: invalid second factor for %q\n", r.Method, r.URL, pending.pendingUserId)
+			a.auditAs(r, "", audit.SignInFailed, audit.TargetUser, pending.pendingUserId, "second factor: invalid code")
+			if wait := a.failSignIn(r, account, ip); wait != 0 {
+				a.tooManyAttempts(w, wait)
+				return
+			}
+			payload := Payload{Site: a.templates.site, Content: MessageData{Message: "That code is not valid."}}
+			payload.Site.NavBar = NavBarData{Links: []LinkData{
+				{Text: "Home", Url: "/"},
+			}}
+			w.WriteHeader(http.StatusUnauthorized)
+			t.render(w, r, payload)
+			return
+		}
+		user, err := a.db.UserById(pending.pendingUserId)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		// never promote the pending session; start a fresh one
+		if err := a.sessions.store.Revoke(pending.sessionId); err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err := a.setSessionCookie(w, r, user); errors.Is(err, ErrAccountDisabled) {
+			log.Printf("%s %s: %q: %v\n", r.Method, r.URL, user.Id, err)
+			a.auditAs(r, "", audit.SignInFailed, audit.TargetUser, user.Id, "second factor: account disabled")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		} else if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.succeedSignIn(r, user.Id)
+		a.auditAs(r, user.Id, audit.SignIn, audit.TargetUser, user.Id, "second factor")
+		http.Redirect(w, r, fmt.Sprintf("/users/%s", user.Id), http.StatusSeeOther)
+	}
+}
+
+// getUsersId2FA shows the enrollment page.
+// If the user isn't enrolled, a new secret is generated each time the page is shown.
+func (a *App) getUsersId2FA() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "two_factor")
+	if err != nil {
+		panic(fmt.Sprintf("[app] getUsersId2FA: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		content, err := a.twoFactorData(user)
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		a.renderTwoFactor(w, r, t, user, content)
+	}
+}
+
+// postUsersId2FAEnable confirms enrollment with a code from the authenticator
+// and shows the recovery codes, which are never shown again.
+func (a *App) postUsersId2FAEnable() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "two_factor")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postUsersId2FAEnable: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		secret, enabled, _, err := a.db.TOTP(user.Id())
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if enabled || secret == "" {
+			http.Redirect(w, r, fmt.Sprintf("/users/%s/2fa", user.Id()), http.StatusSeeOther)
+			return
+		}
+		step, ok := totp.Validate(secret, r.FormValue("code"), time.Now(), 0)
+		if !ok {
+			content := TwoFactorData{Id: user.Id(), Secret: secret, URI: totp.URI("Wraith", user.handle, secret), Error: "That code is not valid. Check the clock on your device and try again."}
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			a.renderTwoFactor(w, r, t, user, content)
+			return
+		}
+		codes, hashes, err := a.newRecoveryCodes()
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err = a.db.EnableTOTP(user.Id(), step, hashes); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q enabled two-factor authentication\n", r.Method, r.URL, user.Id())
+		a.renderTwoFactor(w, r, t, user, TwoFactorData{Id: user.Id(), Enabled: true, RecoveryCodes: codes, Remaining: len(codes)})
+	}
+}
+
+// postUsersId2FADisable turns off two-factor authentication.
+// The user must present a current code so that a stolen session can't remove it.
+func (a *App) postUsersId2FADisable() http.HandlerFunc {
+	t, err := a.newTemplate("layout", "head", "site_header_default", "site_navbar_default", "site_footer_default", "two_factor")
+	if err != nil {
+		panic(fmt.Sprintf("[app] postUsersId2FADisable: %v", err))
+	}
+	nfh := a.notFound()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.currentUser(r)
+		if way.Param(r.Context(), "id") != user.Id() {
+			nfh(w, r)
+			return
+		}
+		ok, err := a.checkSecondFactor(user.Id(), r.FormValue("code"))
+		if err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if !ok {
+			content, err := a.twoFactorData(user)
+			if err != nil {
+				a.internalError(w, r, err)
+				return
+			}
+			content.Error = "That code is not valid."
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			a.renderTwoFactor(w, r, t, user, content)
+			return
+		}
+		if err := a.db.DisableTOTP(user.Id()); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q disabled two-factor authentication\n", r.Method, r.URL, user.Id())
+		http.Redirect(w, r, fmt.Sprintf("/users/%s", user.Id()), http.StatusSeeOther)
+	}
+}
+
+// postUsersId2FAReset lets an administrator turn off two-factor authentication
+// for a user who has lost their device and their recovery codes.
+// The user's sessions are revoked, too.
+func (a *App) postUsersId2FAReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		if err := a.db.DisableTOTP(id); err != nil {
+			a.internalError(w, r, err)
+			return
+		} else if err := a.sessions.store.RevokeUser(id); err != nil {
+			a.internalError(w, r, err)
+			return
+		}
+		log.Printf("%s %s: %q reset two-factor authentication for %q\n", r.Method, r.URL, a.currentUser(r).Id(), id)
+		a.audit(r, audit.AdminTwoFactor, audit.TargetUser, id, "")
+		a.adminDone(w, r, id, "Two-factor authentication is off and the user is signed out everywhere.", "")
+	}
+}
+
+// twoFactorData returns the enrollment state for the user.
+// If the user isn't enrolled, a new secret is saved for them to enroll with.
+func (a *App) twoFactorData(user User) (TwoFactorData, error) {
+	content := TwoFactorData{Id: user.Id()}
+	_, enabled, _, err := a.db.TOTP(user.Id())
+	if err != nil {
+		return content, err
+	} else if enabled {
+		content.Enabled = true
+		content.Remaining, err = a.db.RemainingRecoveryCodes(user.Id())
+		return content, err
+	}
+	if content.Secret, err = totp.NewSecret(); err != nil {
+		return content, err
+	} else if err = a.db.SetTOTPSecret(user.Id(), content.Secret); err != nil {
+		return content, err
+	}
+	content.URI = totp.URI("Wraith", user.handle, content.Secret)
+	return content, nil
+}
+
+func (a *App) renderTwoFactor(w http.ResponseWriter, r *http.Request, t *templateHandler, user User, content TwoFactorData) {
+	payload := Payload{Site: a.templates.site, Content: content}
+	payload.Page.Title = "Two-Factor Authentication"
+	payload.Site.NavBar = NavBarData{Links: []LinkData{
+		{Text: "Profile", Url: fmt.Sprintf("/users/%s", user.Id())},
+		{Text: "Sign Out", Url: "/signout"},
+	}}
+	t.render(w, r, payload)
+}
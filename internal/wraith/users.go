@@ -0,0 +1,218 @@
+// wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package wraith
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UserRecord is a user as stored in the database.
+type UserRecord struct {
+	Id             string
+	Handle         string
+	Email          string // empty if we don't have an address for the user
+	EmailVerified  bool
+	HashedPassword string // empty if the user only signs in with providers
+	TOTPEnabled    bool   // true if the user must present a second factor
+	Disabled       bool   // true if an administrator has disabled the account
+	AvatarURL      string // empty if the user doesn't have an avatar
+	Timezone       string // IANA name of the user's timezone
+	NotifyTurns    bool   // true to send e-mail when a turn is processed
+	NotifyInvites  bool   // true to send e-mail when invited to a game
+	Roles          []string
+	CreatedAt      time.Time
+}
+
+// CreateUser creates a new local account.
+// The caller is responsible for hashing the password.
+// Returns ErrDuplicateHandle or ErrDuplicateEmail if the account already exists.
+func (db *DB) CreateUser(handle, email, hashedPassword string) (UserRecord, error) {
+	u, _, err := db.createUser(handle, email, false, hashedPassword, "")
+	return u, err
+}
+
+// CreateUserWithInvite creates a new local account that uses up the invitation.
+// The caller is responsible for hashing the password and the invitation code.
+// Returns ErrInvalidInvite, and creates nothing, if the invitation can't be used.
+func (db *DB) CreateUserWithInvite(handle, email, hashedPassword, hashedCode string) (UserRecord, InviteRecord, error) {
+	return db.createUser(handle, email, false, hashedPassword, hashedCode)
+}
+
+// createUser creates a new account.
+// The email is optional for accounts created by a provider.
+// If there is an invitation code, the invitation is locked and checked before the
+// account is inserted, and both are rolled back if either fails.
+func (db *DB) createUser(handle, email string, emailVerified bool, hashedPassword, hashedCode string) (UserRecord, InviteRecord, error) {
+	handle, email = strings.TrimSpace(handle), strings.ToLower(strings.TrimSpace(email))
+	if _, err := db.UserByHandle(handle); err == nil {
+		return UserRecord{}, InviteRecord{}, ErrDuplicateHandle
+	} else if !errors.Is(err, ErrNotFound) {
+		return UserRecord{}, InviteRecord{}, err
+	}
+	if email != "" {
+		if _, err := db.UserByEmail(email); err == nil {
+			return UserRecord{}, InviteRecord{}, ErrDuplicateEmail
+		} else if !errors.Is(err, ErrNotFound) {
+			return UserRecord{}, InviteRecord{}, err
+		}
+	}
+
+	tx, err := db.db.BeginTx(db.context, nil)
+	if err != nil {
+		return UserRecord{}, InviteRecord{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var i InviteRecord
+	if hashedCode != "" {
+		if i, err = db.lockInvite(tx, hashedCode); err != nil {
+			return UserRecord{}, InviteRecord{}, err
+		}
+	}
+
+	u := UserRecord{
+		Id:             uuid.NewString(),
+		Handle:         handle,
+		Email:          email,
+		EmailVerified:  email != "" && emailVerified,
+		HashedPassword: hashedPassword,
+		CreatedAt:      time.Now().UTC(),
+	}
+	_, err = tx.ExecContext(db.context,
+		"insert into users (id, handle, email, email_verified, hashed_password, created_at) values (?, ?, ?, ?, ?, ?)",
+		u.Id, u.Handle, sql.NullString{String: u.Email, Valid: u.Email != ""}, u.EmailVerified, u.HashedPassword, u.CreatedAt)
+	if err != nil {
+		return UserRecord{}, InviteRecord{}, err
+	}
+	if hashedCode != "" {
+		if err := db.useInvite(tx, &i, u.Id); err != nil {
+			return UserRecord{}, InviteRecord{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return UserRecord{}, InviteRecord{}, err
+	}
+	return u, i, nil
+}
+
+// UserByEmail returns the user with the given email address.
+// Returns ErrNotFound if there is no such user.
+func (db *DB) UserByEmail(email string) (UserRecord, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return UserRecord{}, ErrNotFound
+	}
+	return db.fetchUser("email", email)
+}
+
+// UserByHandle returns the user with the given handle.
+// Returns ErrNotFound if there is no such user.
+func (db *DB) UserByHandle(handle string) (UserRecord, error) {
+	return db.fetchUser("handle", strings.TrimSpace(handle))
+}
+
+// UserById returns the user with the given id.
+// Returns ErrNotFound if there is no such user.
+func (db *DB) UserById(id string) (UserRecord, error) {
+	return db.fetchUser("id", id)
+}
+
+// fetchUser returns the user with the matching key.
+// The column is never user input, so it is safe to add it to the query.
+func (db *DB) fetchUser(column, value string) (UserRecord, error) {
+	var u UserRecord
+	var email sql.NullString
+	var disabledAt sql.NullTime
+	row := db.db.QueryRowContext(db.context,
+		"select id, handle, email, email_verified, hashed_password, totp_enabled, disabled_at, avatar_url, timezone, notify_turns, notify_invites, created_at from users where "+column+" = ?",
+		value)
+	if err := row.Scan(&u.Id, &u.Handle, &email, &u.EmailVerified, &u.HashedPassword, &u.TOTPEnabled, &disabledAt, &u.AvatarURL, &u.Timezone, &u.NotifyTurns, &u.NotifyInvites, &u.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserRecord{}, ErrNotFound
+		}
+		return UserRecord{}, err
+	}
+	u.Email, u.Disabled = email.String, disabledAt.Valid
+	roles, err := db.userRoles(u.Id)
+	if err != nil {
+		return UserRecord{}, err
+	}
+	u.Roles = roles
+	return u, nil
+}
+
+// userRoles returns the roles granted to the user.
+func (db *DB) userRoles(id string) ([]string, error) {
+	rows, err := db.db.QueryContext(db.context, "select role from user_roles where user_id = ? order by role", id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// SignUpData is the data for the sign-up form.
+type SignUpData struct {
+	Handle     string
+	Email      string
+	Invite     string // the invitation code, which is carried through the forms
+	GameName   string // the game that the invitation is for
+	InviteOnly bool   // true if an invitation is required
+	Providers  []ProviderData
+	CSRFToken  string // for the provider forms, which htmx doesn't send
+	Error      string
+}
+
+// signUpData returns the data for the sign-up page.
+// An invitation that can't be used is dropped and explained in the error.
+func (a *App) signUpData(r *http.Request, invite string) (SignUpData, error) {
+	content := SignUpData{InviteOnly: a.inviteOnly, CSRFToken: csrfTokenFor(r)}
+	for _, p := range a.authn {
+		content.Providers = append(content.Providers, ProviderData{Code: p.Code(), Name: p.Name()})
+	}
+	if invite != "" {
+		if i, err := a.db.InviteByHash(a.hashInviteCode(invite)); errors.Is(err, ErrInvalidInvite) {
+			content.Error = err.Error()
+		} else if err != nil {
+			return content, err
+		} else {
+			content.Invite, content.GameName = invite, i.GameName
+		}
+	}
+	return content, nil
+}
+
+// validateSignUp checks the fields from the sign-up form.
+func validateSignUp(handle, email, password, confirm string) error {
+	if len(handle) < 3 || len(handle) > 32 {
+		return ErrInvalidHandle
+	}
+	for _, ch := range handle {
+		if !(('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') || ch == '_' || ch == '-') {
+			return ErrInvalidHandle
+		}
+	}
+	if local, domain, ok := strings.Cut(email, "@"); !ok || local == "" || !strings.Contains(domain, ".") || len(email) > 255 {
+		return ErrInvalidEmail
+	}
+	if len(password) < 8 || password != confirm {
+		return ErrInvalidPassword
+	}
+	return nil
+}
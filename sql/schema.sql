@@ -0,0 +1,229 @@
+-- wraith - Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+-- schema for the Wraith database (MySQL 8).
+
+create table users
+(
+    id              char(36)     not null,
+    handle          varchar(64)  not null,
+    email           varchar(255) null,     -- null when a provider didn't give us a verified address
+    email_verified  boolean      not null default false,
+    hashed_password varchar(255) not null default '', -- empty for accounts that only use providers
+    totp_secret     varchar(64)  not null default '',    -- set when enrollment starts
+    totp_enabled    boolean      not null default false, -- set when enrollment is confirmed
+    totp_last_step  bigint       not null default 0,     -- last accepted time step, to stop replays
+    disabled_at     datetime     null,                   -- set when an administrator disables the account
+    avatar_url      varchar(1024) not null default '',   -- copied from a linked provider
+    timezone        varchar(64)  not null default 'UTC', -- IANA timezone name
+    notify_turns    boolean      not null default true,  -- e-mail when a turn is processed
+    notify_invites  boolean      not null default true,  -- e-mail when invited to a game
+    created_at      datetime     not null default current_timestamp,
+    updated_at      datetime     not null default current_timestamp on update current_timestamp,
+    primary key (id),
+    unique key users_handle (handle),
+    unique key users_email (email)
+);
+
+-- roles map to the permissions they grant. handlers check permissions, never roles.
+-- the 'authenticated' role is held by every signed-in user and is never stored in user_roles.
+create table roles
+(
+    name        varchar(32)  not null,
+    description varchar(255) not null default '',
+    primary key (name)
+);
+
+create table role_permissions
+(
+    role       varchar(32) not null,
+    permission varchar(32) not null,
+    primary key (role, permission),
+    foreign key (role) references roles (name) on delete cascade
+);
+
+insert into roles (name, description)
+values ('authenticated', 'every signed-in user'),
+       ('gm', 'game masters, who may create games'),
+       ('admin', 'site administrators');
+
+insert into role_permissions (role, permission)
+values ('authenticated', 'account.manage'),
+       ('authenticated', 'games.read'),
+       ('authenticated', 'orders.submit'),
+       ('authenticated', 'profile.read'),
+       ('gm', 'game.create'),
+       ('admin', 'audit.read'),
+       ('admin', 'game.admin'),
+       ('admin', 'game.create'),
+       ('admin', 'invite.create'),
+       ('admin', 'user.impersonate'),
+       ('admin', 'user.manage');
+
+-- roles granted to users. to create the first administrator,
+--   insert into user_roles (user_id, role) select id, 'admin' from users where email = 'you@example.com';
+create table user_roles
+(
+    user_id char(36)    not null,
+    role    varchar(32) not null,
+    primary key (user_id, role),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (role) references roles (name) on delete cascade
+);
+
+-- server-side sessions. the id is carried in the signed session token.
+create table sessions
+(
+    id              char(48)     not null,
+    user_id         char(36)     not null,
+    state           varchar(16)  not null default 'active', -- 'active' or 'pending-mfa'
+    device          varchar(255) not null default '',
+    ip              varchar(64)  not null default '',
+    created_at      datetime     not null,
+    last_seen_at    datetime     not null,
+    expires_at      datetime     not null,
+    impersonator_id char(36)     null, -- the administrator acting as user_id, if any
+    primary key (id),
+    key sessions_user_id (user_id),
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+-- external identities (provider code, subject) linked to a local user.
+create table user_identities
+(
+    provider   varchar(32)  not null,
+    subject    varchar(255) not null,
+    user_id    char(36)     not null,
+    email      varchar(255) not null default '',
+    name       varchar(255) not null default '',
+    avatar     varchar(1024) not null default '',
+    created_at datetime     not null default current_timestamp,
+    primary key (provider, subject),
+    unique key user_identities_user_provider (user_id, provider),
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+-- one-time recovery codes for two-factor authentication. only the hash is stored.
+create table user_recovery_codes
+(
+    user_id     char(36) not null,
+    hashed_code char(64) not null,
+    used_at     datetime null,
+    primary key (user_id, hashed_code),
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+-- personal api tokens for bots and scripts. only the hash of the token is stored.
+-- scopes is a space separated list of the scopes granted to the token.
+create table api_tokens
+(
+    id           char(36)     not null,
+    user_id      char(36)     not null,
+    name         varchar(64)  not null,
+    hashed_token char(64)     not null,
+    scopes       varchar(255) not null default '',
+    created_at   datetime     not null,
+    expires_at   datetime     not null,
+    last_used_at datetime     null,
+    revoked_at   datetime     null,
+    primary key (id),
+    unique key api_tokens_hashed_token (hashed_token),
+    key api_tokens_user_id (user_id),
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+-- games hosted by the server.
+create table games
+(
+    id          char(36)    not null,
+    name        varchar(64) not null,
+    created_by  char(36)    null, -- null if the user who created the game was deleted
+    created_at  datetime    not null,
+    finished_at datetime    null, -- set when the game master ends the game
+    primary key (id),
+    unique key games_name (name),
+    foreign key (created_by) references users (id) on delete set null
+);
+
+-- users' roles in each game: 'gm', 'player', 'observer', or 'eliminated'.
+-- players are bound to a nation; nation is null for the other roles.
+create table game_members
+(
+    game_id   char(36)    not null,
+    user_id   char(36)    not null,
+    role      varchar(16) not null,
+    nation    int         null,
+    result    varchar(64) not null default '', -- recorded by the game master, e.g. 'won' or '3rd'
+    joined_at datetime    not null,
+    primary key (game_id, user_id),
+    unique key game_members_nation (game_id, nation),
+    key game_members_user_id (user_id),
+    foreign key (game_id) references games (id) on delete cascade,
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+-- the orders each nation has submitted for a turn, as written in the orders language.
+-- submitting again replaces the earlier orders.
+create table orders
+(
+    game_id      char(36)   not null,
+    nation       int        not null,
+    turn         int        not null,
+    text         mediumtext not null,
+    submitted_by char(36)   null, -- null if the user who submitted the orders was deleted
+    submitted_at datetime   not null,
+    primary key (game_id, nation, turn),
+    foreign key (game_id) references games (id) on delete cascade,
+    foreign key (submitted_by) references users (id) on delete set null
+);
+
+-- invitations to sign up or to join a game. only the hash of the code is stored.
+-- an invitation is deleted with the user who created it or the game it is for.
+create table invitations
+(
+    id          char(36)    not null,
+    hashed_code char(64)    not null,
+    created_by  char(36)    not null,
+    game_id     char(36)    null, -- null if the invitation is only for signing up
+    max_uses    int         not null, -- 0 for unlimited
+    uses        int         not null default 0,
+    created_at  datetime    not null,
+    expires_at  datetime    null, -- null if the invitation never expires
+    primary key (id),
+    unique key invitations_hashed_code (hashed_code),
+    key invitations_game_id (game_id),
+    foreign key (created_by) references users (id) on delete cascade,
+    foreign key (game_id) references games (id) on delete cascade
+);
+
+-- who did what, to what, and when.
+-- actor_id is null for anonymous requests, like failed sign-ins, and for the system.
+-- ids aren't foreign keys so that events outlive the users and games they name.
+create table audit_events
+(
+    id              bigint       not null auto_increment,
+    created_at      datetime     not null,
+    actor_id        char(36)     null,
+    impersonator_id char(36)     null, -- set when an administrator was acting as the actor
+    action          varchar(32)  not null,
+    target_type     varchar(16)  not null default '',
+    target_id       varchar(64)  not null default '',
+    ip              varchar(64)  not null default '',
+    detail          varchar(1024) not null default '',
+    primary key (id),
+    key audit_events_created_at (created_at),
+    key audit_events_actor_id (actor_id),
+    key audit_events_action (action),
+    key audit_events_target_id (target_id)
+);
+
+-- failed sign-ins counted against an account ('account:<id>') or an address ('ip:<addr>').
+-- rows are purged once the failures are forgotten.
+create table signin_failures
+(
+    lockout_key  varchar(128) not null,
+    failures     int          not null,
+    last_failure datetime     not null,
+    locked_until datetime     null, -- null if the key has never been locked
+    primary key (lockout_key),
+    key signin_failures_last_failure (last_failure)
+);